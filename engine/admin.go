@@ -0,0 +1,524 @@
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrStepNotFound         = errors.New("step not found")
+	ErrWorkflowExists       = errors.New("workflow already exists")
+	ErrWorkflowStillRunning = errors.New("workflow has running steps")
+	ErrStepStillRunning     = errors.New("step is running")
+)
+
+// RollbackTo rewinds a workflow to anchorStepKey: every step that started
+// after the anchor, whatever its step id, is deleted and the anchor is marked
+// failed so the next resume re-executes it, whatever its zombie timeout.
+// Start times are compared in Go at full precision, since julianday rounds to
+// milliseconds.
+func (s *Store) RollbackTo(workflowID, anchorStepKey string) error {
+	defer s.readCache.clear()
+	anchor, found, err := s.GetStep(workflowID, anchorStepKey)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("rollback anchor %s: %w", anchorStepKey, ErrStepNotFound)
+	}
+	anchorStart, err := time.Parse(time.RFC3339Nano, anchor.StartedAt)
+	if err != nil {
+		return fmt.Errorf("parse started_at for %s: %w", anchorStepKey, err)
+	}
+
+	rows, err := s.queryRows(`
+SELECT step_key, started_at
+FROM steps
+WHERE workflow_id=$1;`, workflowID)
+	if err != nil {
+		return err
+	}
+	var stmts []Statement
+	for _, row := range rows {
+		stepKey := asString(row["step_key"])
+		started, err := time.Parse(time.RFC3339Nano, asString(row["started_at"]))
+		if err != nil {
+			return fmt.Errorf("parse started_at for %s: %w", stepKey, err)
+		}
+		if stepKey != anchorStepKey && started.After(anchorStart) {
+			stmts = append(stmts, Statement{
+				Query: "DELETE FROM steps WHERE workflow_id=$1 AND step_key=$2;",
+				Args:  []any{workflowID, stepKey},
+			})
+		}
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	return s.execTx(context.Background(), append(stmts, Statement{Query: `
+UPDATE steps
+SET status=$1,
+    output_json=NULL,
+    output_checksum=NULL,
+    error_text=$2,
+    completed_at=NULL,
+    updated_at=$3
+WHERE workflow_id=$4 AND step_key=$5;`,
+		Args: []any{statusFailed, "rolled back for re-execution", now, workflowID, anchorStepKey},
+	}))
+}
+
+// Vacuum rebuilds the database file to return pages freed by bulk deletes to
+// the filesystem. With WAL enabled the log is checkpointed first; otherwise
+// freed pages still sitting in the WAL would keep the file from shrinking.
+func (s *Store) Vacuum() error {
+	if _, ok := s.dialect.(SQLiteDialect); !ok {
+		return s.execWrite("VACUUM;")
+	}
+	if err := s.execWrite("PRAGMA wal_checkpoint(TRUNCATE);"); err != nil {
+		return fmt.Errorf("checkpoint wal before vacuum: %w", err)
+	}
+	if err := s.execWrite("VACUUM;"); err != nil {
+		return fmt.Errorf("vacuum: %w", err)
+	}
+	// The rebuilt pages land in the WAL too; checkpoint again so the main
+	// file is truncated now rather than when the connection closes.
+	if err := s.execWrite("PRAGMA wal_checkpoint(TRUNCATE);"); err != nil {
+		return fmt.Errorf("checkpoint wal after vacuum: %w", err)
+	}
+	return nil
+}
+
+// BackupTo writes a consistent copy of the live database to destPath with
+// VACUUM INTO. The copy is read through a separate connection, so under WAL
+// the store's own reads and writes carry on while it is taken. The backup is
+// then opened as a Store and checked before BackupTo returns.
+func (s *Store) BackupTo(destPath string) error {
+	if _, ok := s.dialect.(SQLiteDialect); !ok {
+		return errors.New("backup requires the sqlite dialect")
+	}
+	if strings.TrimSpace(destPath) == "" {
+		return errors.New("backup path is required")
+	}
+	if _, err := os.Stat(destPath); err == nil {
+		return fmt.Errorf("backup %s: destination already exists", destPath)
+	}
+	if err := os.MkdirAll(filepath.Dir(destPath), 0o755); err != nil {
+		return fmt.Errorf("create backup dir: %w", err)
+	}
+
+	rows, err := s.queryRows("SELECT file FROM pragma_database_list WHERE name='main';")
+	if err != nil {
+		return fmt.Errorf("backup %s: %w", destPath, err)
+	}
+	if len(rows) == 0 || asString(rows[0]["file"]) == "" {
+		// An in-memory database cannot be opened twice.
+		err = s.execWrite(vacuumIntoSQL, destPath)
+	} else {
+		err = vacuumIntoFrom(asString(rows[0]["file"]), destPath)
+	}
+	if err != nil {
+		return fmt.Errorf("backup %s: %w", destPath, err)
+	}
+
+	want, err := s.SchemaVersion()
+	if err != nil {
+		return fmt.Errorf("backup %s: %w", destPath, err)
+	}
+	backup, err := NewStore(destPath, WithSchemaVersionCheck(want))
+	if err != nil {
+		return fmt.Errorf("verify backup %s: %w", destPath, err)
+	}
+	defer backup.Close()
+	check, err := backup.queryRows("PRAGMA quick_check;")
+	if err != nil {
+		return fmt.Errorf("verify backup %s: %w", destPath, err)
+	}
+	if len(check) != 1 || asString(check[0]["quick_check"]) != "ok" {
+		return fmt.Errorf("verify backup %s: integrity check failed: %v", destPath, check)
+	}
+	return nil
+}
+
+const vacuumIntoSQL = "VACUUM INTO $1;"
+
+func vacuumIntoFrom(srcPath, destPath string) error {
+	db, err := sql.Open("sqlite", srcPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.Exec(vacuumIntoSQL, destPath)
+	return err
+}
+
+// ListAllRunningSteps returns running steps across all workflows that have
+// not been touched for olderThan, oldest first.
+func (s *Store) ListAllRunningSteps(olderThan time.Duration) ([]StepRecord, error) {
+	return s.queryStepRecords(`
+SELECT `+stepColumns+`
+FROM steps
+WHERE status=$1 AND julianday(updated_at) < julianday($2)
+ORDER BY julianday(updated_at) ASC, workflow_id, step_key;`,
+		statusRunning,
+		sqlTime(time.Now().Add(-olderThan)),
+	)
+}
+
+const orphanedRunningError = "purged: orphaned running step"
+
+// PurgeOrphanedRunning fails running steps that have not been touched for
+// olderThan, so the next resume retries them instead of waiting on a dead
+// owner. It returns the number of steps it failed.
+func (s *Store) PurgeOrphanedRunning(olderThan time.Duration) (int, error) {
+	now := time.Now().UTC()
+	n, err := s.execWriteRows(`
+UPDATE steps
+SET status=$1,
+    error_text=$2,
+    updated_at=$3
+WHERE status=$4 AND julianday(updated_at) < julianday($5);`,
+		statusFailed,
+		orphanedRunningError,
+		sqlTime(now),
+		statusRunning,
+		sqlTime(now.Add(-olderThan)),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("purge orphaned running steps: %w", err)
+	}
+	return n, nil
+}
+
+const forcedRetryError = "forced retry by operator"
+
+// ForceComplete marks a step completed with outputJSON whatever its current
+// status, so the next resume replays outputJSON instead of running the step.
+func (s *Store) ForceComplete(workflowID, stepKey, outputJSON string) error {
+	defer s.readCache.remove(workflowID, stepKey)
+	if !json.Valid([]byte(outputJSON)) {
+		return fmt.Errorf("force complete %s: output is not valid JSON", stepKey)
+	}
+	now := time.Now().UTC()
+	n, err := s.execWriteRows(`
+UPDATE steps
+SET status=$1,
+    output_json=$2,
+    output_checksum=$3,
+    error_text=NULL,
+    updated_at=$4,
+    completed_at=$4
+WHERE workflow_id=$5 AND step_key=$6;`,
+		statusCompleted,
+		outputJSON,
+		outputChecksum(outputJSON),
+		sqlTime(now),
+		workflowID,
+		stepKey,
+	)
+	if err != nil {
+		return fmt.Errorf("force complete %s: %w", stepKey, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("force complete %s: %w", stepKey, ErrStepNotFound)
+	}
+	return nil
+}
+
+// ForceRetry marks a step failed and releases it from its run, so the next
+// resume executes it again even if it was stuck running under a live-looking
+// owner.
+func (s *Store) ForceRetry(workflowID, stepKey string) error {
+	defer s.readCache.remove(workflowID, stepKey)
+	n, err := s.execWriteRows(`
+UPDATE steps
+SET status=$1,
+    output_json=NULL,
+    output_checksum=NULL,
+    completed_at=NULL,
+    error_text=$2,
+    run_id='',
+    updated_at=$3
+WHERE workflow_id=$4 AND step_key=$5;`,
+		statusFailed,
+		forcedRetryError,
+		sqlTime(time.Now()),
+		workflowID,
+		stepKey,
+	)
+	if err != nil {
+		return fmt.Errorf("force retry %s: %w", stepKey, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("force retry %s: %w", stepKey, ErrStepNotFound)
+	}
+	return nil
+}
+
+const resetStepError = "reset by operator"
+
+// ResetStep puts a finished step back to failed with no output or run, so the
+// next resume executes it again. A running step is refused with
+// ErrStepStillRunning, since its owner would overwrite the reset.
+func (s *Store) ResetStep(workflowID, stepKey string) error {
+	// Dropped up front as well, so the GetStep below cannot see a stale row.
+	s.readCache.remove(workflowID, stepKey)
+	defer s.readCache.remove(workflowID, stepKey)
+	n, err := s.execWriteRows(`
+UPDATE steps
+SET status=$1,
+    output_json=NULL,
+    output_checksum=NULL,
+    completed_at=NULL,
+    error_text=$2,
+    run_id='',
+    updated_at=$3
+WHERE workflow_id=$4 AND step_key=$5 AND status <> $6;`,
+		statusFailed,
+		resetStepError,
+		sqlTime(time.Now()),
+		workflowID,
+		stepKey,
+		statusRunning,
+	)
+	if err != nil {
+		return fmt.Errorf("reset step %s: %w", stepKey, err)
+	}
+	if n > 0 {
+		return nil
+	}
+	if _, found, err := s.GetStep(workflowID, stepKey); err != nil {
+		return fmt.Errorf("reset step %s: %w", stepKey, err)
+	} else if found {
+		return fmt.Errorf("reset step %s: %w", stepKey, ErrStepStillRunning)
+	}
+	return fmt.Errorf("reset step %s: %w", stepKey, ErrStepNotFound)
+}
+
+// PurgeStepsByStatus deletes the workflow's steps in status last updated
+// before the cutoff and returns how many were removed.
+func (s *Store) PurgeStepsByStatus(workflowID, status string, before time.Time) (int, error) {
+	defer s.readCache.clear()
+	if workflowID == "" {
+		return 0, errors.New("purge steps: workflow id is required")
+	}
+	switch status {
+	case statusRunning, statusCompleted, statusFailed:
+	default:
+		return 0, fmt.Errorf("unknown step status %q", status)
+	}
+
+	n, err := s.execWriteRows(`
+DELETE FROM steps
+WHERE workflow_id=$1 AND status=$2 AND julianday(updated_at) < julianday($3);`,
+		workflowID,
+		status,
+		sqlTime(before),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("purge %s steps: %w", status, err)
+	}
+	return n, nil
+}
+
+// PurgeExpiredWorkflows deletes every workflow whose steps are all completed
+// or failed and were last updated more than olderThan ago, and returns how
+// many workflows it deleted. Each table is cleared by one DELETE selecting the
+// expired workflows, and all of them run in a single transaction.
+func (s *Store) PurgeExpiredWorkflows(olderThan time.Duration) (int, error) {
+	defer s.readCache.clear()
+	const expired = `
+SELECT workflow_id
+FROM steps
+GROUP BY workflow_id
+HAVING MAX(julianday(updated_at)) < julianday($1)
+   AND SUM(CASE WHEN status IN ($2, $3) THEN 0 ELSE 1 END) = 0`
+	args := []any{sqlTime(time.Now().Add(-olderThan)), statusCompleted, statusFailed}
+
+	n, err := s.retryWrite(context.Background(), func(conn *sql.Conn) (int64, error) {
+		if _, err := conn.ExecContext(context.Background(), s.beginSQL()); err != nil {
+			return 0, err
+		}
+		// steps goes last: the other deletes find the expired workflows through it.
+		for _, table := range workflowTables {
+			if table == "steps" {
+				continue
+			}
+			if _, err := conn.ExecContext(context.Background(), "DELETE FROM "+table+" WHERE workflow_id IN ("+expired+");", args...); err != nil {
+				return 0, err
+			}
+		}
+		rows, err := conn.QueryContext(context.Background(), "\nDELETE FROM steps\nWHERE workflow_id IN ("+expired+")\nRETURNING workflow_id;", args...)
+		if err != nil {
+			return 0, err
+		}
+		deleted, err := scanRows(rows)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := conn.ExecContext(context.Background(), "COMMIT;"); err != nil {
+			return 0, err
+		}
+		workflows := make(map[string]bool)
+		for _, row := range deleted {
+			workflows[asString(row["workflow_id"])] = true
+		}
+		return int64(len(workflows)), nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("purge expired workflows: %w", err)
+	}
+	return int(n), nil
+}
+
+// workflowTables lists every table keyed by workflow_id.
+var workflowTables = []string{"steps", "step_attempts", "step_history", "workflow_logs", "workflow_metadata", "workflows"}
+
+// DeleteWorkflow removes a workflow's steps, attempts, logs and workflow row.
+// It refuses with ErrWorkflowStillRunning while any step is running; the check
+// and the delete happen in the same transaction.
+func (s *Store) DeleteWorkflow(workflowID string) error {
+	if err := s.deleteWorkflowRows(workflowID, true); err != nil {
+		return err
+	}
+	rows, err := s.queryRows("SELECT COUNT(*) AS n FROM steps WHERE workflow_id=$1 AND status=$2;", workflowID, statusRunning)
+	if err != nil {
+		return fmt.Errorf("delete workflow %s: %w", workflowID, err)
+	}
+	if len(rows) > 0 && asInt(rows[0]["n"]) > 0 {
+		return fmt.Errorf("delete workflow %s: %w", workflowID, ErrWorkflowStillRunning)
+	}
+	return nil
+}
+
+// ForceDeleteWorkflow is DeleteWorkflow without the running-step check. A
+// process still executing the workflow will recreate the rows it writes next.
+func (s *Store) ForceDeleteWorkflow(workflowID string) error {
+	return s.deleteWorkflowRows(workflowID, false)
+}
+
+// deleteWorkflowRows deletes from every workflow table, steps last so the
+// running-step guard can still inspect them. With unlessRunning set nothing
+// is deleted while a step is running.
+func (s *Store) deleteWorkflowRows(workflowID string, unlessRunning bool) error {
+	defer s.readCache.clear()
+	where := "workflow_id=$1"
+	args := []any{workflowID}
+	if unlessRunning {
+		where += " AND NOT EXISTS (SELECT 1 FROM steps WHERE workflow_id=$1 AND status=$2)"
+		args = append(args, statusRunning)
+	}
+	stmts := make([]Statement, 0, len(workflowTables))
+	for _, table := range workflowTables {
+		if table == "steps" {
+			continue
+		}
+		stmts = append(stmts, Statement{Query: "DELETE FROM " + table + " WHERE " + where + ";", Args: args})
+	}
+	stmts = append(stmts, Statement{Query: "DELETE FROM steps WHERE " + where + ";", Args: args})
+	if err := s.execTx(context.Background(), stmts); err != nil {
+		return fmt.Errorf("delete workflow %s: %w", workflowID, err)
+	}
+	return nil
+}
+
+// MigrateWorkflowID renames a workflow across every table in one transaction.
+// It refuses to merge into a workflow that already has steps or a workflow row.
+func (s *Store) MigrateWorkflowID(oldID, newID string) error {
+	defer s.readCache.clear()
+	if oldID == newID {
+		return nil
+	}
+	exists := func(workflowID string) (bool, error) {
+		rows, err := s.queryRows(`
+SELECT (SELECT COUNT(*) FROM steps WHERE workflow_id=$1)
+     + (SELECT COUNT(*) FROM workflows WHERE workflow_id=$1) AS n;`, workflowID)
+		if err != nil {
+			return false, err
+		}
+		return len(rows) > 0 && asInt(rows[0]["n"]) > 0, nil
+	}
+
+	found, err := exists(oldID)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("migrate %s: %w", oldID, ErrWorkflowNotFound)
+	}
+	taken, err := exists(newID)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return fmt.Errorf("migrate %s to %s: %w", oldID, newID, ErrWorkflowExists)
+	}
+
+	stmts := make([]Statement, 0, len(workflowTables))
+	for _, table := range workflowTables {
+		stmts = append(stmts, Statement{Query: "UPDATE " + table + " SET workflow_id=$1 WHERE workflow_id=$2;", Args: []any{newID, oldID}})
+	}
+	if err := s.execTx(context.Background(), stmts); err != nil {
+		return fmt.Errorf("migrate %s to %s: %w", oldID, newID, err)
+	}
+	return nil
+}
+
+// DuplicateStep copies a completed step to dstKey, keeping its run id and
+// timestamps, as a failed step so the next run executes dstKey again. It is
+// the audit-safe alternative to ResetStep: the original row and its output
+// stay untouched.
+func (s *Store) DuplicateStep(workflowID, srcKey, dstKey string) error {
+	src, found, err := s.GetStep(workflowID, srcKey)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("duplicate step %s: %w", srcKey, ErrStepNotFound)
+	}
+	if src.Status != statusCompleted {
+		return fmt.Errorf("duplicate step %s: status is %s, only completed steps can be duplicated", srcKey, src.Status)
+	}
+	stepID, sequence, err := parseStepKey(dstKey)
+	if err != nil {
+		return err
+	}
+	if _, exists, err := s.GetStep(workflowID, dstKey); err != nil {
+		return err
+	} else if exists {
+		return fmt.Errorf("duplicate step %s: destination %s already exists", srcKey, dstKey)
+	}
+
+	return s.execWrite(`
+INSERT INTO steps(workflow_id, step_key, step_id, sequence, status, output_json, error_text, run_id, started_at, updated_at, completed_at, metadata_json, output_checksum)
+SELECT workflow_id, $1, $2, $3, $4, NULL, $5, run_id, started_at, updated_at, NULL, NULL, NULL
+FROM steps
+WHERE workflow_id=$6 AND step_key=$7;`,
+		dstKey,
+		stepID,
+		sequence,
+		statusFailed,
+		"duplicated from "+srcKey+" for re-execution",
+		workflowID,
+		srcKey,
+	)
+}
+
+func parseStepKey(stepKey string) (string, int, error) {
+	i := strings.LastIndex(stepKey, "#")
+	if i <= 0 {
+		return "", 0, fmt.Errorf("malformed step key %q", stepKey)
+	}
+	sequence, err := strconv.Atoi(stepKey[i+1:])
+	if err != nil || sequence <= 0 {
+		return "", 0, fmt.Errorf("malformed step key %q", stepKey)
+	}
+	return stepKey[:i], sequence, nil
+}
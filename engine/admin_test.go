@@ -0,0 +1,616 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRollbackToRemovesLaterSteps(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	const workflowID = "wf-rollback"
+
+	ctx := NewContext(workflowID, store)
+	for i := 0; i < 5; i++ {
+		i := i
+		if _, err := Step(ctx, "batch", func() (int, error) { return i, nil }); err != nil {
+			t.Fatalf("step %d failed: %v", i, err)
+		}
+	}
+
+	if err := store.RollbackTo(workflowID, "batch#000002"); err != nil {
+		t.Fatalf("rollback failed: %v", err)
+	}
+
+	rows, err := store.ListSteps(workflowID)
+	if err != nil {
+		t.Fatalf("list steps failed: %v", err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 rows after rollback, got %d", len(rows))
+	}
+	if rows[0].Status != statusCompleted || rows[1].Status != statusFailed {
+		t.Fatalf("unexpected statuses: %s=%s %s=%s", rows[0].StepKey, rows[0].Status, rows[1].StepKey, rows[1].Status)
+	}
+
+	calls := 0
+	// A zombie timeout must not make the resume wait on the rolled back anchor.
+	resume := NewContext(workflowID, store).WithZombieTimeout(time.Hour)
+	for i := 0; i < 5; i++ {
+		i := i
+		got, err := Step(resume, "batch", func() (int, error) {
+			calls++
+			return i * 10, nil
+		})
+		if err != nil {
+			t.Fatalf("resume step %d failed: %v", i, err)
+		}
+		if i == 0 && got != 0 {
+			t.Fatalf("expected cached first step, got %d", got)
+		}
+	}
+	if calls != 4 {
+		t.Fatalf("expected anchor and later steps to re-run (4 calls), got %d", calls)
+	}
+
+	if err := store.RollbackTo(workflowID, "missing#000001"); !errors.Is(err, ErrStepNotFound) {
+		t.Fatalf("expected ErrStepNotFound for missing anchor, got %v", err)
+	}
+}
+
+func TestRollbackToKeepsEarlierStepsOfOtherIDs(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	const workflowID = "wf-rollback-interleaved"
+
+	ctx := NewContext(workflowID, store)
+	for _, id := range []string{"fetch", "fetch", "fetch", "transform", "load", "transform"} {
+		if _, err := Step(ctx, id, func() (string, error) { return id, nil }); err != nil {
+			t.Fatalf("step %s failed: %v", id, err)
+		}
+	}
+
+	// transform#000001 ran after fetch#000003 despite its lower sequence,
+	// and load#000001 ran after it.
+	if err := store.RollbackTo(workflowID, "transform#000001"); err != nil {
+		t.Fatalf("rollback failed: %v", err)
+	}
+	rows, err := store.ListSteps(workflowID)
+	if err != nil {
+		t.Fatalf("list steps failed: %v", err)
+	}
+	got := make([]string, 0, len(rows))
+	for _, row := range rows {
+		got = append(got, row.StepKey+" "+row.Status)
+	}
+	sort.Strings(got)
+	want := []string{
+		"fetch#000001 completed",
+		"fetch#000002 completed",
+		"fetch#000003 completed",
+		"transform#000001 failed",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestDuplicateStepKeepsOriginal(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	const workflowID = "wf-duplicate"
+
+	ctx := NewContext(workflowID, store)
+	if _, err := Step(ctx, "charge", func() (string, error) { return "receipt-1", nil }); err != nil {
+		t.Fatalf("seed step failed: %v", err)
+	}
+
+	if err := store.DuplicateStep(workflowID, "charge#000001", "charge#000002"); err != nil {
+		t.Fatalf("duplicate failed: %v", err)
+	}
+	src, _, err := store.GetStep(workflowID, "charge#000001")
+	if err != nil {
+		t.Fatalf("load source failed: %v", err)
+	}
+	dst, found, err := store.GetStep(workflowID, "charge#000002")
+	if err != nil || !found {
+		t.Fatalf("load copy failed: found=%v err=%v", found, err)
+	}
+	if dst.Sequence != 2 || dst.StepID != "charge" || dst.RunID != src.RunID || dst.StartedAt != src.StartedAt || dst.UpdatedAt != src.UpdatedAt {
+		t.Fatalf("copy does not match source: src=%+v dst=%+v", src, dst)
+	}
+	if dst.Status != statusFailed || dst.OutputJSON != "" || src.Status != statusCompleted || src.OutputJSON == "" {
+		t.Fatalf("expected a retryable copy and an untouched source: src=%+v dst=%+v", src, dst)
+	}
+
+	// A resume replays the source and executes the copy again.
+	calls := 0
+	resume := NewContext(workflowID, store)
+	for i := 0; i < 2; i++ {
+		got, err := Step(resume, "charge", func() (string, error) {
+			calls++
+			return "receipt-2", nil
+		})
+		if err != nil {
+			t.Fatalf("resume step %d failed: %v", i, err)
+		}
+		if want := []string{"receipt-1", "receipt-2"}[i]; got != want {
+			t.Fatalf("resume step %d: expected %q, got %q", i, want, got)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("expected only the duplicated key to execute, ran %d times", calls)
+	}
+
+	if err := store.DuplicateStep(workflowID, "charge#000001", "charge#000002"); err == nil {
+		t.Fatalf("expected duplicate onto existing key to fail")
+	}
+	if err := store.DuplicateStep(workflowID, "missing#000001", "missing#000002"); !errors.Is(err, ErrStepNotFound) {
+		t.Fatalf("expected ErrStepNotFound, got %v", err)
+	}
+	if err := store.DuplicateStep(workflowID, "charge#000001", "no-sequence"); err == nil {
+		t.Fatalf("expected malformed destination key to fail")
+	}
+}
+
+func TestListAllRunningStepsFindsStuckSteps(t *testing.T) {
+	store := newSQLiteTestStore(t)
+
+	for _, wf := range []string{"wf-stuck-a", "wf-stuck-b", "wf-stuck-fresh"} {
+		ctx := NewContext(wf, store)
+		if err := store.UpsertRunning(wf, ctx.nextStepRef("work"), ctx.RunID); err != nil {
+			t.Fatalf("seed running row failed: %v", err)
+		}
+	}
+	if _, err := Step(NewContext("wf-stuck-done", store), "work", func() (int, error) { return 1, nil }); err != nil {
+		t.Fatalf("seed completed step failed: %v", err)
+	}
+	if err := store.execWrite(`
+UPDATE steps SET updated_at='2020-01-01T00:00:00Z' WHERE workflow_id IN ('wf-stuck-b', 'wf-stuck-done');
+UPDATE steps SET updated_at='2021-01-01T00:00:00Z' WHERE workflow_id='wf-stuck-a';`); err != nil {
+		t.Fatalf("age rows failed: %v", err)
+	}
+
+	stuck, err := store.ListAllRunningSteps(time.Hour)
+	if err != nil {
+		t.Fatalf("list running failed: %v", err)
+	}
+	if len(stuck) != 2 || stuck[0].WorkflowID != "wf-stuck-b" || stuck[1].WorkflowID != "wf-stuck-a" {
+		t.Fatalf("unexpected stuck steps: %+v", stuck)
+	}
+}
+
+func TestPurgeOrphanedRunningOnlyAffectsOldRows(t *testing.T) {
+	store := newSQLiteTestStore(t)
+
+	for _, wf := range []string{"wf-orphan-old", "wf-orphan-fresh"} {
+		ctx := NewContext(wf, store)
+		if err := store.UpsertRunning(wf, ctx.nextStepRef("work"), ctx.RunID); err != nil {
+			t.Fatalf("seed running row failed: %v", err)
+		}
+	}
+	if _, err := Step(NewContext("wf-orphan-done", store), "work", func() (int, error) { return 1, nil }); err != nil {
+		t.Fatalf("seed completed step failed: %v", err)
+	}
+	if err := store.execWrite(`
+UPDATE steps SET updated_at='2020-01-01T00:00:00Z' WHERE workflow_id IN ('wf-orphan-old', 'wf-orphan-done');`); err != nil {
+		t.Fatalf("age rows failed: %v", err)
+	}
+
+	n, err := store.PurgeOrphanedRunning(time.Hour)
+	if err != nil {
+		t.Fatalf("purge failed: %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 purged step, got %d", n)
+	}
+
+	want := map[string]string{
+		"wf-orphan-old":   statusFailed,
+		"wf-orphan-fresh": statusRunning,
+		"wf-orphan-done":  statusCompleted,
+	}
+	for wf, status := range want {
+		row, _, err := store.GetStep(wf, "work#000001")
+		if err != nil {
+			t.Fatalf("load %s failed: %v", wf, err)
+		}
+		if row.Status != status {
+			t.Fatalf("%s: expected %s, got %s", wf, status, row.Status)
+		}
+	}
+	old, _, _ := store.GetStep("wf-orphan-old", "work#000001")
+	if old.ErrorText != "purged: orphaned running step" {
+		t.Fatalf("unexpected error text %q", old.ErrorText)
+	}
+}
+
+func TestPurgeStepsByStatusOnlyAffectsTargetStatus(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	const workflowID = "wf-purge-status"
+
+	ctx := NewContext(workflowID, store)
+	if _, err := Step(ctx, "ship", func() (int, error) { return 1, nil }); err != nil {
+		t.Fatalf("seed completed step failed: %v", err)
+	}
+	_, _ = Step(ctx, "charge", func() (int, error) { return 0, errors.New("card declined") })
+	_, _ = Step(ctx, "notify", func() (int, error) { return 0, errors.New("smtp down") })
+	if err := store.execWrite(`
+UPDATE steps SET updated_at='2020-01-01T00:00:00Z' WHERE step_id IN ('ship', 'charge');`); err != nil {
+		t.Fatalf("age rows failed: %v", err)
+	}
+
+	n, err := store.PurgeStepsByStatus(workflowID, statusFailed, time.Now().Add(-24*time.Hour))
+	if err != nil {
+		t.Fatalf("purge failed: %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 purged step, got %d", n)
+	}
+	for key, wantFound := range map[string]bool{"ship#000001": true, "charge#000001": false, "notify#000001": true} {
+		if _, found, err := store.GetStep(workflowID, key); err != nil || found != wantFound {
+			t.Fatalf("%s: expected found=%v, got %v err=%v", key, wantFound, found, err)
+		}
+	}
+	if _, err := store.PurgeStepsByStatus(workflowID, "done", time.Now()); err == nil {
+		t.Fatalf("expected unknown status to be rejected")
+	}
+	if _, err := store.PurgeStepsByStatus("", statusCompleted, time.Now()); err == nil {
+		t.Fatalf("expected empty workflow id to be rejected")
+	}
+	if _, found, _ := store.GetStep(workflowID, "ship#000001"); !found {
+		t.Fatalf("expected rejected purge to leave steps alone")
+	}
+}
+
+func TestMigrateWorkflowIDIsAtomic(t *testing.T) {
+	store := newSQLiteTestStore(t)
+
+	err := RunWorkflow(store, "legacy-order-1", func(ctx *Context) error {
+		ctx.WithCustomLogger(NewStoreLogger(store, ctx.WorkflowID))
+		_, err := Step(ctx, "charge", func() (int, error) { return 1, nil })
+		return err
+	})
+	if err != nil {
+		t.Fatalf("seed workflow failed: %v", err)
+	}
+
+	// A stray log row under the new id makes the workflow_logs update violate
+	// its primary key, which must roll back the steps update as well.
+	if err := store.AppendWorkflowLog("legacy_order_1", []LogEntry{{Message: "stray"}}); err != nil {
+		t.Fatalf("seed conflicting log failed: %v", err)
+	}
+	if err := store.MigrateWorkflowID("legacy-order-1", "legacy_order_1"); err == nil {
+		t.Fatalf("expected conflicting migration to fail")
+	}
+	if rows, _ := store.ListSteps("legacy-order-1"); len(rows) != 1 {
+		t.Fatalf("expected steps to stay under the old id after rollback, got %d", len(rows))
+	}
+
+	if err := store.execWrite("DELETE FROM workflow_logs WHERE workflow_id='legacy_order_1';"); err != nil {
+		t.Fatalf("clear conflicting log failed: %v", err)
+	}
+	if err := store.MigrateWorkflowID("legacy-order-1", "legacy_order_1"); err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+	if rows, _ := store.ListSteps("legacy_order_1"); len(rows) != 1 {
+		t.Fatalf("expected steps under the new id, got %d", len(rows))
+	}
+	if runID, err := store.GetLatestRunID("legacy_order_1"); err != nil || runID == "" {
+		t.Fatalf("expected run id under new id, got %q err=%v", runID, err)
+	}
+	if logs, _ := store.GetWorkflowLog("legacy_order_1", 0); len(logs) == 0 {
+		t.Fatalf("expected workflow log to move with the workflow")
+	}
+
+	if err := store.MigrateWorkflowID("legacy-order-1", "other"); !errors.Is(err, ErrWorkflowNotFound) {
+		t.Fatalf("expected ErrWorkflowNotFound, got %v", err)
+	}
+	if err := RunWorkflow(store, "other", func(*Context) error { return nil }); err != nil {
+		t.Fatalf("seed other workflow failed: %v", err)
+	}
+	if err := store.MigrateWorkflowID("legacy_order_1", "other"); !errors.Is(err, ErrWorkflowExists) {
+		t.Fatalf("expected ErrWorkflowExists, got %v", err)
+	}
+}
+
+func TestDeleteWorkflowRefusesRunningSteps(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	const workflowID = "wf-delete"
+
+	ctx := NewContext(workflowID, store)
+	if _, err := Step(ctx, "done", func() (int, error) { return 1, nil }); err != nil {
+		t.Fatalf("seed completed step failed: %v", err)
+	}
+	if err := store.MarkWorkflowRunning(workflowID, ctx.RunID); err != nil {
+		t.Fatalf("seed workflow row failed: %v", err)
+	}
+	if err := store.UpsertRunning(workflowID, ctx.nextStepRef("busy"), ctx.RunID); err != nil {
+		t.Fatalf("seed running step failed: %v", err)
+	}
+
+	if err := store.DeleteWorkflow(workflowID); !errors.Is(err, ErrWorkflowStillRunning) {
+		t.Fatalf("expected ErrWorkflowStillRunning, got %v", err)
+	}
+	if steps, _ := store.ListSteps(workflowID); len(steps) != 2 {
+		t.Fatalf("expected refused delete to keep both steps, got %d", len(steps))
+	}
+	if _, found, _ := store.GetWorkflowRecord(workflowID); !found {
+		t.Fatalf("expected refused delete to keep the workflow row")
+	}
+
+	if err := store.ForceDeleteWorkflow(workflowID); err != nil {
+		t.Fatalf("force delete failed: %v", err)
+	}
+	if steps, _ := store.ListSteps(workflowID); len(steps) != 0 {
+		t.Fatalf("expected no steps after force delete, got %d", len(steps))
+	}
+	if _, found, _ := store.GetWorkflowRecord(workflowID); found {
+		t.Fatalf("expected workflow row to be deleted")
+	}
+	if err := store.DeleteWorkflow(workflowID); err != nil {
+		t.Fatalf("deleting a missing workflow should be a no-op, got %v", err)
+	}
+
+	finished := NewContext("wf-delete-finished", store)
+	if _, err := Step(finished, "done", func() (int, error) { return 1, nil }); err != nil {
+		t.Fatalf("seed finished workflow failed: %v", err)
+	}
+	if err := store.DeleteWorkflow("wf-delete-finished"); err != nil {
+		t.Fatalf("delete finished workflow failed: %v", err)
+	}
+	if steps, _ := store.ListSteps("wf-delete-finished"); len(steps) != 0 {
+		t.Fatalf("expected finished workflow steps to be deleted, got %d", len(steps))
+	}
+}
+
+func TestPurgeExpiredWorkflowsDeletesOnlyAgedTerminalWorkflows(t *testing.T) {
+	store := newSQLiteTestStore(t)
+
+	for i := 0; i < 50; i++ {
+		ctx := NewContext(fmt.Sprintf("wf-expire-%02d", i), store)
+		if _, err := Step(ctx, "load", func() (int, error) { return i, nil }); err != nil {
+			t.Fatalf("seed workflow %d failed: %v", i, err)
+		}
+		if _, err := Step(ctx, "notify", func() (int, error) { return 0, errors.New("smtp down") }); err == nil {
+			t.Fatalf("expected notify to fail")
+		}
+	}
+	old := sqlTime(time.Now().Add(-48 * time.Hour))
+	if err := store.execWrite("UPDATE steps SET updated_at=$1 WHERE workflow_id < 'wf-expire-30';", old); err != nil {
+		t.Fatalf("age workflows failed: %v", err)
+	}
+	// Aged but still running, so it must survive.
+	stuck := NewContext("wf-expire-stuck", store)
+	if err := store.UpsertRunning(stuck.WorkflowID, stuck.nextStepRef("load"), stuck.RunID); err != nil {
+		t.Fatalf("seed running step failed: %v", err)
+	}
+	if err := store.execWrite("UPDATE steps SET updated_at=$1 WHERE workflow_id='wf-expire-stuck';", old); err != nil {
+		t.Fatalf("age running workflow failed: %v", err)
+	}
+
+	deleted, err := store.PurgeExpiredWorkflows(24 * time.Hour)
+	if err != nil {
+		t.Fatalf("purge failed: %v", err)
+	}
+	if deleted != 30 {
+		t.Fatalf("expected 30 workflows deleted, got %d", deleted)
+	}
+	if steps, _ := store.ListSteps("wf-expire-29"); len(steps) != 0 {
+		t.Fatalf("expected aged workflow to be gone, got %d steps", len(steps))
+	}
+	if n, _ := store.GetStepAttemptCount("wf-expire-29", "load#000001"); n != 0 {
+		t.Fatalf("expected aged workflow's attempts deleted with it, got %d", n)
+	}
+	if n, _ := store.GetStepAttemptCount("wf-expire-30", "load#000001"); n != 1 {
+		t.Fatalf("expected recent workflow's attempts to remain, got %d", n)
+	}
+	if steps, _ := store.ListSteps("wf-expire-30"); len(steps) != 2 {
+		t.Fatalf("expected recent workflow to remain, got %d steps", len(steps))
+	}
+	if steps, _ := store.ListSteps("wf-expire-stuck"); len(steps) != 1 {
+		t.Fatalf("expected running workflow to remain, got %d steps", len(steps))
+	}
+}
+
+func TestBackupToCopiesLiveDatabase(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	const workflowID = "wf-backup"
+
+	ctx := NewContext(workflowID, store)
+	for i := 0; i < 100; i++ {
+		if _, err := Step(ctx, "row", func() (int, error) { return i, nil }); err != nil {
+			t.Fatalf("step %d failed: %v", i, err)
+		}
+	}
+
+	dest := filepath.Join(t.TempDir(), "backups", "nightly.db")
+	if err := store.BackupTo(dest); err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+	if err := store.BackupTo(dest); err == nil {
+		t.Fatalf("expected existing destination to be refused")
+	}
+
+	backup, err := NewStore(dest)
+	if err != nil {
+		t.Fatalf("open backup failed: %v", err)
+	}
+	t.Cleanup(func() { backup.Close() })
+
+	want, err := store.ListSteps(workflowID)
+	if err != nil {
+		t.Fatalf("list source steps failed: %v", err)
+	}
+	got, err := backup.ListSteps(workflowID)
+	if err != nil {
+		t.Fatalf("list backup steps failed: %v", err)
+	}
+	if len(got) != 100 || !reflect.DeepEqual(got, want) {
+		t.Fatalf("backup differs from source: got %d steps, want %d", len(got), len(want))
+	}
+}
+
+func TestForceRetryLetsRunnerReexecuteStuckStep(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	const workflowID = "wf-force"
+
+	owner := NewContext(workflowID, store)
+	ref := owner.nextStepRef("provision")
+	if err := store.UpsertRunning(workflowID, ref, owner.RunID); err != nil {
+		t.Fatalf("seed running step failed: %v", err)
+	}
+
+	calls := 0
+	wf := func(ctx *Context) error {
+		ctx.WithZombieTimeout(time.Hour)
+		_, err := Step(ctx, "provision", func() (string, error) {
+			calls++
+			return "granted", nil
+		})
+		return err
+	}
+	if err := RunWorkflow(store, workflowID, wf); err == nil {
+		t.Fatalf("expected the stuck step to block the run")
+	}
+
+	if err := store.ForceRetry(workflowID, ref.StepKey); err != nil {
+		t.Fatalf("force retry failed: %v", err)
+	}
+	if row, _, _ := store.GetStep(workflowID, ref.StepKey); row.Status != statusFailed || row.RunID != "" {
+		t.Fatalf("expected failed row without run id, got %+v", row)
+	}
+	if err := RunWorkflow(store, workflowID, wf); err != nil {
+		t.Fatalf("run after force retry failed: %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected step to run once after force retry, ran %d times", calls)
+	}
+
+	if err := store.ForceRetry(workflowID, "missing#000001"); !errors.Is(err, ErrStepNotFound) {
+		t.Fatalf("expected ErrStepNotFound, got %v", err)
+	}
+}
+
+func TestForceCompleteOverridesAnyStatus(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	const workflowID = "wf-force-complete"
+
+	ctx := NewContext(workflowID, store)
+	if _, err := Step(ctx, "charge", func() (int, error) { return 0, errors.New("gateway down") }); err == nil {
+		t.Fatalf("expected charge to fail")
+	}
+	if err := store.ForceComplete(workflowID, "charge#000001", "{bad"); err == nil {
+		t.Fatalf("expected invalid JSON to be rejected")
+	}
+	if err := store.ForceComplete(workflowID, "missing#000001", "1"); !errors.Is(err, ErrStepNotFound) {
+		t.Fatalf("expected ErrStepNotFound, got %v", err)
+	}
+	if err := store.ForceComplete(workflowID, "charge#000001", "1250"); err != nil {
+		t.Fatalf("force complete failed: %v", err)
+	}
+
+	out, err := Step(NewContext(workflowID, store), "charge", func() (int, error) {
+		return 0, errors.New("must not run again")
+	})
+	if err != nil || out != 1250 {
+		t.Fatalf("expected forced output to replay, got %d err=%v", out, err)
+	}
+}
+
+func TestResetStepReexecutesCompletedStep(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	const workflowID = "wf-reset"
+
+	endpoint := "http://staging.invalid"
+	calls := 0
+	wf := func(ctx *Context) error {
+		_, err := Step(ctx, "notify", func() (string, error) {
+			calls++
+			return "sent to " + endpoint, nil
+		})
+		return err
+	}
+	if err := RunWorkflow(store, workflowID, wf); err != nil {
+		t.Fatalf("first run failed: %v", err)
+	}
+
+	endpoint = "https://prod.example"
+	if err := store.ResetStep(workflowID, "notify#000001"); err != nil {
+		t.Fatalf("reset failed: %v", err)
+	}
+	if row, _, _ := store.GetStep(workflowID, "notify#000001"); row.Status != statusFailed || row.ErrorText != resetStepError {
+		t.Fatalf("expected a failed row explaining the reset, got %+v", row)
+	}
+	if problems, err := store.ValidateIntegrity(workflowID); err != nil || len(problems) != 0 {
+		t.Fatalf("expected the reset row to pass integrity checks, got %v err=%v", problems, err)
+	}
+	if err := RunWorkflow(store, workflowID, wf); err != nil {
+		t.Fatalf("run after reset failed: %v", err)
+	}
+	row, _, err := store.GetStep(workflowID, "notify#000001")
+	if err != nil || calls != 2 || row.Status != statusCompleted || row.OutputJSON != `"sent to https://prod.example"` {
+		t.Fatalf("expected re-executed step with new output, calls=%d row=%+v err=%v", calls, row, err)
+	}
+
+	ctx := NewContext(workflowID, store)
+	ref := ctx.nextStepRef("busy")
+	if err := store.UpsertRunning(workflowID, ref, ctx.RunID); err != nil {
+		t.Fatalf("seed running step failed: %v", err)
+	}
+	if err := store.ResetStep(workflowID, ref.StepKey); !errors.Is(err, ErrStepStillRunning) {
+		t.Fatalf("expected ErrStepStillRunning, got %v", err)
+	}
+	if err := store.ResetStep(workflowID, "missing#000001"); !errors.Is(err, ErrStepNotFound) {
+		t.Fatalf("expected ErrStepNotFound, got %v", err)
+	}
+}
+
+func TestVacuumReducesDatabaseSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vacuum.db")
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("new store failed: %v", err)
+	}
+
+	ctx := NewContext("wf-vacuum", store)
+	payload := strings.Repeat("x", 2048)
+	refs := make([]StepRef, 0, 40)
+	outputs := make(map[string]string, 40)
+	for i := 0; i < 40; i++ {
+		ref := ctx.nextStepRef("blob")
+		refs = append(refs, ref)
+		outputs[ref.StepKey] = `"` + payload + `"`
+	}
+	if err := store.BatchUpsertRunning(ctx.WorkflowID, refs, ctx.RunID); err != nil {
+		t.Fatalf("seed running rows failed: %v", err)
+	}
+	if err := store.BatchMarkCompleted(ctx.WorkflowID, ctx.RunID, outputs); err != nil {
+		t.Fatalf("seed completed rows failed: %v", err)
+	}
+	if err := store.execWrite("DELETE FROM steps WHERE workflow_id='wf-vacuum';\nPRAGMA wal_checkpoint(TRUNCATE);"); err != nil {
+		t.Fatalf("delete rows failed: %v", err)
+	}
+
+	before := fileSize(t, path)
+	if err := store.Vacuum(); err != nil {
+		t.Fatalf("vacuum failed: %v", err)
+	}
+	after := fileSize(t, path)
+	if after >= before {
+		t.Fatalf("expected vacuum to shrink the file: before=%d after=%d", before, after)
+	}
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat %s failed: %v", path, err)
+	}
+	return info.Size()
+}
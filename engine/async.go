@@ -0,0 +1,55 @@
+package engine
+
+import (
+	"context"
+	"sync"
+)
+
+// WorkflowFuture is the result of a workflow started by RunWorkflowAsync.
+type WorkflowFuture struct {
+	done   chan struct{}
+	cancel context.CancelFunc
+
+	mu  sync.Mutex
+	err error
+}
+
+// RunWorkflowAsync starts RunWorkflowWithContext in a new goroutine and
+// returns immediately.
+func RunWorkflowAsync(store StoreBackend, workflowID string, fn WorkflowFunc) *WorkflowFuture {
+	goCtx, cancel := context.WithCancel(context.Background())
+	f := &WorkflowFuture{done: make(chan struct{}), cancel: cancel}
+	go func() {
+		defer close(f.done)
+		defer cancel()
+		err := RunWorkflowWithContext(goCtx, store, workflowID, fn)
+		f.mu.Lock()
+		f.err = err
+		f.mu.Unlock()
+	}()
+	return f
+}
+
+// Wait blocks until the workflow returns and reports its error.
+func (f *WorkflowFuture) Wait() error {
+	<-f.done
+	return f.Err()
+}
+
+// Done is closed when the workflow returns.
+func (f *WorkflowFuture) Done() <-chan struct{} {
+	return f.done
+}
+
+// Err returns the workflow's error, or nil while it is still running.
+func (f *WorkflowFuture) Err() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
+
+// Cancel asks the workflow to stop: steps not yet started fail with
+// context.Canceled, and a step already running finishes first.
+func (f *WorkflowFuture) Cancel() {
+	f.cancel()
+}
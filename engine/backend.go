@@ -0,0 +1,60 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrUnsupportedBackend is returned by features that need more of the store
+// than StoreBackend provides.
+var ErrUnsupportedBackend = errors.New("not supported by this store backend")
+
+// StoreBackend is everything Step, NewContext and RunWorkflow need to persist
+// checkpoints. *Store is the SQL implementation; other backends only have to
+// keep these five operations consistent for a single workflow.
+type StoreBackend interface {
+	InitSchema() error
+	GetStep(workflowID, stepKey string) (StepRecord, bool, error)
+	UpsertRunning(workflowID string, ref StepRef, runID string) error
+	MarkCompleted(workflowID, stepKey, runID, outputJSON string) error
+	MarkFailed(workflowID, stepKey, runID, errText string) error
+	ListSteps(workflowID string) ([]StepRecord, error)
+}
+
+var _ StoreBackend = (*Store)(nil)
+
+// stepMetadataStore is implemented by backends that can persist step
+// metadata, which input hashing, schema versions and schedules rely on.
+type stepMetadataStore interface {
+	SetStepMetadata(workflowID, stepKey, metadataJSON string) error
+}
+
+// workflowStatusStore is implemented by backends that track workflow rows.
+// RunWorkflow records status through it when available.
+type workflowStatusStore interface {
+	MarkWorkflowRunning(workflowID, runID string) error
+	MarkWorkflowStatus(workflowID, runID, status string) error
+}
+
+// InitSchema creates any missing tables and columns. NewStore already does
+// this; it is safe to call again.
+func (s *Store) InitSchema() error {
+	return s.initSchema()
+}
+
+func isNilBackend(b StoreBackend) bool {
+	if b == nil {
+		return true
+	}
+	s, ok := b.(*Store)
+	return ok && s == nil
+}
+
+// sqlStore returns the *Store behind c for features that query the steps
+// table directly.
+func (c *Context) sqlStore(feature string) (*Store, error) {
+	if c.store == nil {
+		return nil, fmt.Errorf("%s: %w", feature, ErrUnsupportedBackend)
+	}
+	return c.store, nil
+}
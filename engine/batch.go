@@ -0,0 +1,156 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"durableexec/internal/errgroup"
+)
+
+var errBatchNotExecuted = errors.New("batch has not been executed")
+
+// Batch collects steps that are claimed together, run concurrently and
+// checkpointed in a single write by Execute.
+type Batch struct {
+	ctx      *Context
+	entries  []batchEntry
+	executed bool
+	err      error
+}
+
+type batchEntry struct {
+	ref      StepRef
+	run      func() (string, error)
+	onCached func(cached StepRecord) error
+}
+
+// BatchFuture holds the result of one batched step once Execute has run.
+type BatchFuture[T any] struct {
+	batch *Batch
+	value T
+	err   error
+}
+
+func NewBatch(ctx *Context) *Batch {
+	return &Batch{ctx: ctx}
+}
+
+// BatchAdd schedules fn as the step id in b. Go methods cannot take type
+// parameters, so this is a function rather than a method on Batch.
+func BatchAdd[T any](b *Batch, id string, fn func() (T, error)) *BatchFuture[T] {
+	f := &BatchFuture[T]{batch: b}
+	if b.executed {
+		f.err = errors.New("cannot add steps to an executed batch")
+		return f
+	}
+	if err := checkStepArgs(b.ctx, fn == nil); err != nil {
+		f.err = err
+		b.err = errors.Join(b.err, err)
+		return f
+	}
+
+	ref := b.ctx.nextStepRef(id)
+	b.entries = append(b.entries, batchEntry{
+		ref: ref,
+		run: func() (string, error) {
+			out, err := fn()
+			if err != nil {
+				f.err = fmt.Errorf("step %s failed: %w", ref.StepKey, err)
+				return "", err
+			}
+			payload, err := b.ctx.encodeOutput(out)
+			if err != nil {
+				f.err = fmt.Errorf("marshal step result for %s: %w", ref.StepKey, err)
+				return "", err
+			}
+			if err := b.ctx.checkOutputSize(ref, payload); err != nil {
+				f.err = err
+				return "", err
+			}
+			f.value = out
+			return string(payload), nil
+		},
+		onCached: func(cached StepRecord) error {
+			f.value, f.err = decodeCached[T](b.ctx, ref, cached)
+			return f.err
+		},
+	})
+	return f
+}
+
+// Execute claims every scheduled step, runs the uncached ones concurrently
+// and commits their outputs in one write. Failed steps are marked
+// individually; the first error is returned after the commit.
+func (b *Batch) Execute() error {
+	if b.executed {
+		return errors.New("batch already executed")
+	}
+	b.executed = true
+	if b.err != nil {
+		return b.err
+	}
+	if len(b.entries) == 0 {
+		return nil
+	}
+	ctx := b.ctx
+
+	refs := make([]StepRef, len(b.entries))
+	for i, e := range b.entries {
+		refs[i] = e.ref
+	}
+	pending, err := ctx.claimBulk(refs, func(i int, cached StepRecord) error {
+		return b.entries[i].onCached(cached)
+	})
+	if err != nil {
+		b.err = err
+		return err
+	}
+
+	var (
+		mu      sync.Mutex
+		outputs = make(map[string]string, len(pending))
+		g       errgroup.Group
+	)
+	for _, i := range pending {
+		e := b.entries[i]
+		g.Go(func() error {
+			payload, err := e.run()
+			if err != nil {
+				_ = ctx.backend.MarkFailed(ctx.WorkflowID, e.ref.StepKey, ctx.RunID, ctx.formatError(e.ref.StepKey, err))
+				return fmt.Errorf("step %s failed: %w", e.ref.StepKey, err)
+			}
+			mu.Lock()
+			outputs[e.ref.StepKey] = payload
+			mu.Unlock()
+			return nil
+		})
+	}
+	runErr := g.Wait()
+
+	if err := ctx.store.BatchMarkCompleted(ctx.WorkflowID, ctx.RunID, outputs); err != nil {
+		b.err = fmt.Errorf("batch executed but completion checkpoint failed (possible zombie steps): %w", err)
+		return b.err
+	}
+	for _, i := range pending {
+		if payload, ok := outputs[refs[i].StepKey]; ok {
+			ctx.cacheCompleted(refs[i], payload)
+		}
+	}
+	return runErr
+}
+
+// Result returns the step's output. It fails until the batch has executed,
+// and for every step if the batch could not be claimed or committed.
+func (f *BatchFuture[T]) Result() (T, error) {
+	var zero T
+	switch {
+	case f.err != nil:
+		return zero, f.err
+	case !f.batch.executed:
+		return zero, errBatchNotExecuted
+	case f.batch.err != nil:
+		return zero, f.batch.err
+	}
+	return f.value, nil
+}
@@ -58,6 +58,27 @@ func BenchmarkStepCachedRead(b *testing.B) {
 	}
 }
 
+// BenchmarkStoreGetStepReadCache measures a completed-step read served by
+// WithReadCache after the first hit.
+func BenchmarkStoreGetStepReadCache(b *testing.B) {
+	store := mustStore(b, filepath.Join(b.TempDir(), "bench_read_cache.db"), engine.WithReadCache(1024))
+	const workflowID = "wf-read-cache"
+
+	seedCtx := engine.NewContext(workflowID, store)
+	if _, err := engine.Step(seedCtx, "cached_step", func() (int, error) { return 7, nil }); err != nil {
+		b.Fatalf("seed step failed: %v", err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		record, found, err := store.GetStep(workflowID, "cached_step#000001")
+		if err != nil || !found || record.OutputJSON != "7" {
+			b.Fatalf("cached read failed at i=%d: found=%v err=%v", i, found, err)
+		}
+	}
+}
+
 func BenchmarkStepParallelWrites(b *testing.B) {
 	store := mustStore(b, filepath.Join(b.TempDir(), "bench_parallel.db"))
 	ctx := engine.NewContext("wf-parallel-bench", store)
@@ -100,9 +121,9 @@ func BenchmarkOnboardingWorkflowE2E(b *testing.B) {
 	}
 }
 
-func mustStore(b *testing.B, path string) *engine.Store {
+func mustStore(b *testing.B, path string, opts ...engine.StoreOption) *engine.Store {
 	b.Helper()
-	store, err := engine.NewStore(path)
+	store, err := engine.NewStore(path, opts...)
 	if err != nil {
 		b.Fatalf("new store failed: %v", err)
 	}
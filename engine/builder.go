@@ -0,0 +1,229 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"durableexec/internal/errgroup"
+)
+
+// StepDef names a step for the Workflow builder. Fn must be a func() error or
+// a func() (T, error); it is validated when registered and invoked through
+// reflection.
+type StepDef struct {
+	ID string
+	Fn any
+}
+
+// Workflow is a declarative alternative to writing a WorkflowFunc by hand.
+// Registration errors are collected and reported by Build and Run.
+type Workflow struct {
+	id            string
+	nodes         []workflowNode
+	compensations map[string]StepDef
+	err           error
+}
+
+type workflowNode struct {
+	step     *StepDef
+	parallel []StepDef
+	subID    string
+	sub      *Workflow
+}
+
+func NewWorkflow(id string) *Workflow {
+	return &Workflow{id: id, compensations: make(map[string]StepDef)}
+}
+
+func (w *Workflow) Step(id string, fn any) *Workflow {
+	def := StepDef{ID: id, Fn: fn}
+	if err := validateStepFn(def); err != nil {
+		w.fail(err)
+		return w
+	}
+	w.nodes = append(w.nodes, workflowNode{step: &def})
+	return w
+}
+
+func (w *Workflow) ParallelGroup(steps ...StepDef) *Workflow {
+	if len(steps) == 0 {
+		w.fail(errors.New("parallel group needs at least one step"))
+		return w
+	}
+	for _, def := range steps {
+		if err := validateStepFn(def); err != nil {
+			w.fail(err)
+			return w
+		}
+	}
+	w.nodes = append(w.nodes, workflowNode{parallel: append([]StepDef(nil), steps...)})
+	return w
+}
+
+// SubWorkflow inlines wf, prefixing its step ids with id + "." so they cannot
+// collide with the parent's steps.
+func (w *Workflow) SubWorkflow(id string, wf *Workflow) *Workflow {
+	if wf == nil {
+		w.fail(fmt.Errorf("sub-workflow %q is nil", id))
+		return w
+	}
+	w.nodes = append(w.nodes, workflowNode{subID: id, sub: wf})
+	return w
+}
+
+// Compensate registers fn to undo the step named id. If a later step fails,
+// compensations of completed steps run in reverse order, each as a durable
+// step named id + "_compensate".
+func (w *Workflow) Compensate(id string, fn func() error) *Workflow {
+	if fn == nil {
+		w.fail(fmt.Errorf("compensation for %q is nil", id))
+		return w
+	}
+	w.compensations[id] = StepDef{ID: id + "_compensate", Fn: fn}
+	return w
+}
+
+// AddSaga registers forward as the next step and compensate as the durable
+// step that undoes it if a later step fails.
+func (w *Workflow) AddSaga(forward StepDef, compensate StepDef) *Workflow {
+	if err := validateStepFn(compensate); err != nil {
+		w.fail(err)
+		return w
+	}
+	w.Step(forward.ID, forward.Fn)
+	w.compensations[forward.ID] = compensate
+	return w
+}
+
+func (w *Workflow) Build() WorkflowFunc {
+	return func(ctx *Context) error {
+		if err := w.validate(); err != nil {
+			return err
+		}
+		if err := w.exec(ctx, ""); err != nil {
+			if cerr := ctx.RunCompensations(); cerr != nil {
+				return errors.Join(err, cerr)
+			}
+			return err
+		}
+		return nil
+	}
+}
+
+func (w *Workflow) Run(store StoreBackend) error {
+	return RunWorkflow(store, w.id, w.Build())
+}
+
+func (w *Workflow) fail(err error) {
+	if w.err == nil {
+		w.err = fmt.Errorf("workflow %s: %w", w.id, err)
+	}
+}
+
+func (w *Workflow) validate() error {
+	if w.err != nil {
+		return w.err
+	}
+	for _, node := range w.nodes {
+		if node.sub != nil {
+			if err := node.sub.validate(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// exec runs the nodes in order, registering the compensating actions of
+// completed steps on ctx so a failure can unwind them, sub-workflows included.
+func (w *Workflow) exec(ctx *Context, prefix string) error {
+	for _, node := range w.nodes {
+		switch {
+		case node.step != nil:
+			if err := runStepDef(ctx, prefix+node.step.ID, node.step.Fn); err != nil {
+				return err
+			}
+			w.recordCompensation(ctx, prefix, node.step.ID)
+		case node.parallel != nil:
+			refs := make([]StepRef, len(node.parallel))
+			for i, def := range node.parallel {
+				refs[i] = ctx.nextStepRef(prefix + def.ID)
+			}
+			var g errgroup.Group
+			for i, def := range node.parallel {
+				ref, fn := refs[i], def.Fn
+				g.Go(func() error {
+					_, err := stepWithRef(ctx, ref, func() (json.RawMessage, error) { return callStepFn(fn) })
+					return err
+				})
+			}
+			err := g.Wait()
+			// Parallel siblings may have completed even if one failed.
+			for i, def := range node.parallel {
+				if record, found, _ := ctx.backend.GetStep(ctx.WorkflowID, refs[i].StepKey); found && record.Status == statusCompleted {
+					w.recordCompensation(ctx, prefix, def.ID)
+				}
+			}
+			if err != nil {
+				return err
+			}
+		case node.sub != nil:
+			if err := node.sub.exec(ctx, prefix+node.subID+"."); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (w *Workflow) recordCompensation(ctx *Context, prefix, id string) {
+	def, ok := w.compensations[id]
+	if !ok {
+		return
+	}
+	stepID := prefix + def.ID
+	ctx.pushCompensation(func() error {
+		return runStepDef(ctx, stepID, def.Fn)
+	})
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+func validateStepFn(def StepDef) error {
+	if def.Fn == nil {
+		return fmt.Errorf("step %q: function is nil", def.ID)
+	}
+	t := reflect.TypeOf(def.Fn)
+	if t.Kind() != reflect.Func || t.NumIn() != 0 {
+		return fmt.Errorf("step %q: fn must be func() error or func() (T, error), got %s", def.ID, t)
+	}
+	switch t.NumOut() {
+	case 1, 2:
+		if t.Out(t.NumOut()-1) != errorType {
+			return fmt.Errorf("step %q: last return value must be error, got %s", def.ID, t)
+		}
+	default:
+		return fmt.Errorf("step %q: fn must be func() error or func() (T, error), got %s", def.ID, t)
+	}
+	return nil
+}
+
+func runStepDef(ctx *Context, id string, fn any) error {
+	_, err := Step(ctx, id, func() (json.RawMessage, error) { return callStepFn(fn) })
+	return err
+}
+
+// callStepFn invokes a validated step function. Builder steps only need the
+// result checkpointed, so it is returned already encoded.
+func callStepFn(fn any) (json.RawMessage, error) {
+	out := reflect.ValueOf(fn).Call(nil)
+	if errVal := out[len(out)-1]; !errVal.IsNil() {
+		return nil, errVal.Interface().(error)
+	}
+	if len(out) == 1 {
+		return json.RawMessage("null"), nil
+	}
+	return json.Marshal(out[0].Interface())
+}
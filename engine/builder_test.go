@@ -0,0 +1,165 @@
+package engine
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestWorkflowBuilderRunsStepsInOrder(t *testing.T) {
+	store := newTestStore(t)
+
+	var (
+		mu    sync.Mutex
+		order []string
+	)
+	record := func(name string) func() (string, error) {
+		return func() (string, error) {
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			return name, nil
+		}
+	}
+
+	audit := NewWorkflow("audit").Step("write_audit", func() error {
+		mu.Lock()
+		order = append(order, "write_audit")
+		mu.Unlock()
+		return nil
+	})
+	wf := NewWorkflow("wf-builder").
+		Step("create_record", record("create_record")).
+		ParallelGroup(
+			StepDef{ID: "provision_laptop", Fn: record("provision_laptop")},
+			StepDef{ID: "provision_access", Fn: record("provision_access")},
+		).
+		SubWorkflow("audit", audit).
+		Step("send_welcome_email", record("send_welcome_email"))
+
+	if err := wf.Run(store); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if len(order) != 5 || order[0] != "create_record" || order[3] != "write_audit" || order[4] != "send_welcome_email" {
+		t.Fatalf("unexpected execution order: %v", order)
+	}
+
+	rows, err := store.ListSteps("wf-builder")
+	if err != nil {
+		t.Fatalf("list steps failed: %v", err)
+	}
+	var keys []string
+	for _, row := range rows {
+		keys = append(keys, row.StepKey)
+	}
+	want := []string{"audit.write_audit#000001", "create_record#000001", "provision_access#000001", "provision_laptop#000001", "send_welcome_email#000001"}
+	if !reflect.DeepEqual(keys, want) {
+		t.Fatalf("unexpected step keys: %v", keys)
+	}
+
+	order = nil
+	if err := wf.Run(store); err != nil {
+		t.Fatalf("rerun failed: %v", err)
+	}
+	if len(order) != 0 {
+		t.Fatalf("expected all steps cached on rerun, ran %v", order)
+	}
+}
+
+func TestWorkflowBuilderCompensatesOnFailure(t *testing.T) {
+	store := newTestStore(t)
+
+	var undone []string
+	boom := errors.New("email service down")
+	wf := NewWorkflow("wf-builder-saga").
+		Step("reserve", func() (int, error) { return 1, nil }).
+		Compensate("reserve", func() error {
+			undone = append(undone, "reserve")
+			return nil
+		}).
+		Step("charge", func() error { return nil }).
+		Compensate("charge", func() error {
+			undone = append(undone, "charge")
+			return nil
+		}).
+		Step("notify", func() error { return boom })
+
+	err := wf.Run(store)
+	if !errors.Is(err, boom) {
+		t.Fatalf("expected notify failure, got %v", err)
+	}
+	if !reflect.DeepEqual(undone, []string{"charge", "reserve"}) {
+		t.Fatalf("expected reverse-order compensation, got %v", undone)
+	}
+}
+
+func TestWorkflowAddSagaCompensatesInReverse(t *testing.T) {
+	store := newTestStore(t)
+
+	var undone []string
+	boom := errors.New("shipping unavailable")
+	wf := NewWorkflow("wf-builder-add-saga").
+		AddSaga(
+			StepDef{ID: "reserve_stock", Fn: func() (int, error) { return 3, nil }},
+			StepDef{ID: "release_stock", Fn: func() error {
+				undone = append(undone, "release_stock")
+				return nil
+			}},
+		).
+		AddSaga(
+			StepDef{ID: "charge_card", Fn: func() (string, error) { return "txn-1", nil }},
+			StepDef{ID: "refund_card", Fn: func() (string, error) {
+				undone = append(undone, "refund_card")
+				return "refund-1", nil
+			}},
+		).
+		Step("ship", func() error { return boom })
+
+	if err := wf.Run(store); !errors.Is(err, boom) {
+		t.Fatalf("expected ship failure, got %v", err)
+	}
+	if !reflect.DeepEqual(undone, []string{"refund_card", "release_stock"}) {
+		t.Fatalf("expected reverse-order compensation, got %v", undone)
+	}
+	row, found, err := store.GetStep("wf-builder-add-saga", "refund_card#000001")
+	if err != nil || !found || row.Status != statusCompleted {
+		t.Fatalf("expected compensation to be checkpointed: %+v found=%v err=%v", row, found, err)
+	}
+
+	bad := NewWorkflow("wf-builder-add-saga-bad").AddSaga(
+		StepDef{ID: "a", Fn: func() error { return nil }},
+		StepDef{ID: "undo_a", Fn: "not a func"},
+	)
+	if err := bad.Build()(nil); err == nil {
+		t.Fatalf("expected invalid compensation to be rejected")
+	}
+}
+
+func TestWorkflowBuilderRejectsBadStepFunc(t *testing.T) {
+	wf := NewWorkflow("wf-builder-bad").Step("bad", func(int) error { return nil })
+	if err := wf.Build()(nil); err == nil {
+		t.Fatalf("expected validation error for step with arguments")
+	}
+}
+
+func TestWorkflowBuilderReplaysTypedResults(t *testing.T) {
+	store := newTestStore(t)
+
+	calls := 0
+	wf := NewWorkflow("wf-builder-typed").
+		Step("count", func() (int, error) {
+			calls++
+			return 3, nil
+		}).
+		ParallelGroup(StepDef{ID: "receipt", Fn: func() (struct{ ID string }, error) { return struct{ ID string }{"r-1"}, nil }})
+
+	for i := 0; i < 2; i++ {
+		if err := wf.Run(store); err != nil {
+			t.Fatalf("run %d failed: %v", i, err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("expected typed step to replay from checkpoint, ran %d times", calls)
+	}
+}
@@ -0,0 +1,148 @@
+package engine
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+func (c *Context) WithBulkConcurrency(n int) *Context {
+	c.bulkConcurrency = n
+	return c
+}
+
+// BulkStep runs fn once per id as the step prefix_id. Uncached steps are
+// claimed in one write, executed in parallel (bounded by WithBulkConcurrency,
+// default runtime.NumCPU), and their outputs checkpointed in one more write.
+// Failed ids are marked individually; successful outputs are still committed
+// before the first error is returned. Results follow ids order.
+func BulkStep[T any](ctx *Context, prefix string, ids []string, fn func(string) (T, error)) ([]T, error) {
+	if err := checkStepArgs(ctx, fn == nil); err != nil {
+		return nil, err
+	}
+
+	refs := make([]StepRef, len(ids))
+	for i, id := range ids {
+		refs[i] = ctx.nextStepRef(prefix + "_" + id)
+	}
+
+	results := make([]T, len(ids))
+	pending, err := ctx.claimBulk(refs, func(i int, cached StepRecord) error {
+		out, err := decodeCached[T](ctx, refs[i], cached)
+		results[i] = out
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	limit := ctx.bulkConcurrency
+	if limit <= 0 {
+		limit = runtime.NumCPU()
+	}
+	sem := make(chan struct{}, limit)
+
+	var (
+		mu      sync.Mutex
+		outputs = make(map[string]string, len(pending))
+		errs    = make([]error, len(ids))
+		wg      sync.WaitGroup
+	)
+	for _, i := range pending {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			ref := refs[i]
+			out, err := fn(ids[i])
+			if err != nil {
+				_ = ctx.backend.MarkFailed(ctx.WorkflowID, ref.StepKey, ctx.RunID, ctx.formatError(ref.StepKey, err))
+				errs[i] = fmt.Errorf("step %s failed: %w", ref.StepKey, err)
+				return
+			}
+			payload, err := ctx.encodeOutput(out)
+			if err != nil {
+				_ = ctx.backend.MarkFailed(ctx.WorkflowID, ref.StepKey, ctx.RunID, "marshal error: "+ctx.formatError(ref.StepKey, err))
+				errs[i] = fmt.Errorf("marshal step result for %s: %w", ref.StepKey, err)
+				return
+			}
+			if err := ctx.checkOutputSize(ref, payload); err != nil {
+				_ = ctx.backend.MarkFailed(ctx.WorkflowID, ref.StepKey, ctx.RunID, ctx.formatError(ref.StepKey, err))
+				errs[i] = err
+				return
+			}
+			results[i] = out
+			mu.Lock()
+			outputs[ref.StepKey] = string(payload)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if err := ctx.store.BatchMarkCompleted(ctx.WorkflowID, ctx.RunID, outputs); err != nil {
+		return nil, fmt.Errorf("bulk step %s executed but completion checkpoint failed (possible zombie steps): %w", prefix, err)
+	}
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// claimBulk resolves every ref under one claim lock, hands cached records to
+// onCached, and claims the rest in a single write. It returns the indexes of
+// refs that must be executed.
+// claimBulk needs the batched writes of *Store, so callers can rely on
+// c.store once it succeeds.
+func (c *Context) claimBulk(refs []StepRef, onCached func(i int, cached StepRecord) error) ([]int, error) {
+	if _, err := c.sqlStore("bulk step claim"); err != nil {
+		return nil, err
+	}
+	c.claimMu.Lock()
+	defer c.claimMu.Unlock()
+
+	var (
+		pending []int
+		claims  []StepRef
+	)
+	for i, ref := range refs {
+		record, found, err := c.loadStep(ref.StepKey)
+		if err != nil {
+			return nil, fmt.Errorf("load step state for %s: %w", ref.StepKey, err)
+		}
+		claim, _, err := c.resolveClaim(ref, record, found)
+		if err != nil {
+			return nil, err
+		}
+		if claim == claimCached {
+			if err := c.verifyOutput(record); err != nil {
+				return nil, err
+			}
+			if err := onCached(i, record); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := c.countClaim(ref); err != nil {
+			return nil, err
+		}
+		pending = append(pending, i)
+		claims = append(claims, ref)
+	}
+
+	var wb WriteBatch
+	meta := c.codecMetadataJSON()
+	for _, ref := range claims {
+		wb.claim(c.WorkflowID, ref, c.RunID, c.ZombieTimeout)
+		if meta != "" {
+			wb.SetStepMetadata(c.WorkflowID, ref.StepKey, meta)
+		}
+	}
+	if err := c.store.ApplyWriteBatch(&wb); err != nil {
+		return nil, fmt.Errorf("claim bulk steps: %w", err)
+	}
+	return pending, nil
+}
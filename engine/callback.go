@@ -0,0 +1,39 @@
+package engine
+
+// StepWithCallback claims the step and runs fn in the background, passing its
+// result to cb from that goroutine. The step is checkpointed only after cb
+// returns, and the returned channel is closed once that has happened. A step
+// already completed by an earlier run calls neither fn nor cb; its channel is
+// closed immediately. Claim errors are returned synchronously.
+func StepWithCallback[T any](ctx *Context, id string, fn func() (T, error), cb func(T, error)) (<-chan struct{}, error) {
+	if err := checkStepArgs(ctx, fn == nil || cb == nil); err != nil {
+		return nil, err
+	}
+
+	ref := ctx.nextStepRef(id)
+	end := ctx.notifyBeforeStep(ref)
+
+	claim, _, err := ctx.claimStep(ref)
+	if err != nil {
+		end(err)
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	if claim == claimCached {
+		end(nil)
+		close(done)
+		return done, nil
+	}
+
+	go func() {
+		defer close(done)
+		_, err := runClaimed(ctx, ref, func() (T, error) {
+			out, err := fn()
+			cb(out, err)
+			return out, err
+		})
+		end(err)
+	}()
+	return done, nil
+}
@@ -0,0 +1,48 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+)
+
+// NewContextWithCancel returns a Context that stops starting steps once
+// cancel is called: later steps fail with context.Canceled without running
+// fn or touching the store. A step already running finishes and still
+// checkpoints its result.
+func NewContextWithCancel(workflowID string, store StoreBackend) (*Context, context.CancelFunc) {
+	return newContextWithParent(context.Background(), workflowID, store)
+}
+
+// newContextWithParent is NewContextWithCancel whose Context is also
+// cancelled when parent is done.
+func newContextWithParent(parent context.Context, workflowID string, store StoreBackend) (*Context, context.CancelFunc) {
+	c := NewContext(workflowID, store)
+	goCtx, cancel := context.WithCancel(parent)
+	c.goCtx = goCtx
+	return c, cancel
+}
+
+// GoContext returns the context.Context that is cancelled with the Context,
+// for passing to step code. It is context.Background() for a Context made
+// without NewContextWithCancel.
+func (c *Context) GoContext() context.Context {
+	if c.goCtx == nil {
+		return context.Background()
+	}
+	return c.goCtx
+}
+
+// Done is GoContext().Done(), for use in select statements inside steps.
+func (c *Context) Done() <-chan struct{} {
+	return c.GoContext().Done()
+}
+
+func (c *Context) checkCancelled(ref StepRef) error {
+	if c.goCtx == nil {
+		return nil
+	}
+	if err := c.goCtx.Err(); err != nil {
+		return fmt.Errorf("step %s not started: %w", ref.StepKey, err)
+	}
+	return nil
+}
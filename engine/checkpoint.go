@@ -0,0 +1,78 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// checkpointStore is implemented by backends that can overwrite a completed
+// step, which Checkpoint needs to update a value in place.
+type checkpointStore interface {
+	PutCheckpoint(workflowID string, ref StepRef, runID, outputJSON, metadataJSON string) error
+}
+
+// checkpointRef names the row holding a checkpoint. Like nextStepRef it
+// prefixes the id with the Context's fork prefix. Sequence 0 is never handed
+// out by an IDCounter, so it cannot collide with a step.
+func (c *Context) checkpointRef(key string) StepRef {
+	stepID := c.keyPrefix + resolveStepID(key) + "_checkpoint"
+	return StepRef{StepID: stepID, Sequence: 0, StepKey: fmt.Sprintf(StepKeyFormat, stepID, 0)}
+}
+
+func isCheckpointStepID(stepID string) bool {
+	return strings.HasSuffix(stepID, "_checkpoint")
+}
+
+// Checkpoint stores value under key as a completed step without running
+// anything, replacing any earlier value for the same key.
+func Checkpoint[T any](ctx *Context, key string, value T) error {
+	if err := checkStepArgs(ctx, false); err != nil {
+		return err
+	}
+	if strings.TrimSpace(key) == "" {
+		return errors.New("checkpoint key is required")
+	}
+	store, ok := ctx.backend.(checkpointStore)
+	if !ok {
+		return fmt.Errorf("checkpoint %s: %w", key, ErrUnsupportedBackend)
+	}
+
+	ref := ctx.checkpointRef(key)
+	payload, err := ctx.encodeOutput(value)
+	if err != nil {
+		return fmt.Errorf("marshal checkpoint %s: %w", key, err)
+	}
+	if err := ctx.checkOutputSize(ref, payload); err != nil {
+		return err
+	}
+	if err := store.PutCheckpoint(ctx.WorkflowID, ref, ctx.RunID, string(payload), ctx.codecMetadataJSON()); err != nil {
+		return fmt.Errorf("write checkpoint %s: %w", key, err)
+	}
+	ctx.cacheCompleted(ref, string(payload))
+	return nil
+}
+
+// ReadCheckpoint returns the value last stored under key by Checkpoint.
+func ReadCheckpoint[T any](ctx *Context, key string) (T, bool, error) {
+	var out, zero T
+	if err := checkStepArgs(ctx, false); err != nil {
+		return zero, false, err
+	}
+
+	ref := ctx.checkpointRef(key)
+	record, found, err := ctx.loadStep(ref.StepKey)
+	if err != nil {
+		return zero, false, fmt.Errorf("read checkpoint %s: %w", key, err)
+	}
+	if !found || record.Status != statusCompleted {
+		return zero, false, nil
+	}
+	if err := ctx.verifyOutput(record); err != nil {
+		return zero, true, err
+	}
+	if err := ctx.decodeOutput(record, &out); err != nil {
+		return zero, true, fmt.Errorf("decode checkpoint %s: %w", key, err)
+	}
+	return out, true, nil
+}
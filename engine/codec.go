@@ -0,0 +1,139 @@
+package engine
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrCodecMismatch is returned when a cached output was written with a
+// different codec than the replaying Context uses.
+var ErrCodecMismatch = errors.New("cached output was written with a different codec")
+
+// Codec encodes step outputs for checkpointing. A codec can implement
+// Name() string to set the name recorded with each step; otherwise its Go
+// type name is used.
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// JSONCodec is the default codec. Its output is stored as is, so checkpoints
+// stay readable by the JSON-based query helpers.
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (JSONCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+func (JSONCodec) Name() string                       { return jsonCodecName }
+
+const jsonCodecName = "json"
+
+// WithCodec sets the codec for step outputs. Outputs of any other codec are
+// stored base64-encoded and their steps are tagged with the codec name in
+// their metadata, so replaying them under a different codec fails with
+// ErrCodecMismatch. Untagged steps are treated as JSON.
+func (c *Context) WithCodec(codec Codec) *Context {
+	c.codec = codec
+	return c
+}
+
+func codecName(codec Codec) string {
+	if named, ok := codec.(interface{ Name() string }); ok {
+		return named.Name()
+	}
+	return fmt.Sprintf("%T", codec)
+}
+
+func (c *Context) codecName() string {
+	if c.codec == nil {
+		return jsonCodecName
+	}
+	return codecName(c.codec)
+}
+
+func (c *Context) usesJSON() bool {
+	return c.codecName() == jsonCodecName
+}
+
+// encodeOutput renders a step result into the text stored as output_json.
+func (c *Context) encodeOutput(v any) ([]byte, error) {
+	if c.usesJSON() {
+		return json.Marshal(v)
+	}
+	data, err := c.codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, base64.StdEncoding.EncodedLen(len(data)))
+	base64.StdEncoding.Encode(out, data)
+	return out, nil
+}
+
+// decodeOutput decodes a cached step output after checking that it was
+// written with this Context's codec.
+func (c *Context) decodeOutput(record StepRecord, v any) error {
+	if _, ok := c.backend.(stepMetadataStore); ok {
+		meta, err := decodeStepMetadata(record)
+		if err != nil {
+			return err
+		}
+		stored, _ := meta["codec"].(string)
+		if stored == "" {
+			stored = jsonCodecName
+		}
+		if stored != c.codecName() {
+			return fmt.Errorf("%w: stored %s, context uses %s", ErrCodecMismatch, stored, c.codecName())
+		}
+	}
+	if c.usesJSON() {
+		return json.Unmarshal([]byte(record.OutputJSON), v)
+	}
+	data, err := base64.StdEncoding.DecodeString(record.OutputJSON)
+	if err != nil {
+		return err
+	}
+	return c.codec.Unmarshal(data, v)
+}
+
+// codecMetadataJSON is the metadata a freshly claimed step starts with: the
+// codec tag for a non-default codec, otherwise none.
+func (c *Context) codecMetadataJSON() string {
+	if c.usesJSON() {
+		return ""
+	}
+	return fmt.Sprintf(`{"codec":%q}`, c.codecName())
+}
+
+// claimRunning marks ref running for this run. On *Store the codec tag is
+// written in the same transaction as the claim.
+func (c *Context) claimRunning(ref StepRef) error {
+	meta := c.codecMetadataJSON()
+	if c.store != nil {
+		return c.store.claimStep(c.WorkflowID, ref, c.RunID, c.ZombieTimeout, meta)
+	}
+	if err := c.backend.UpsertRunning(c.WorkflowID, ref, c.RunID); err != nil {
+		return err
+	}
+	if meta == "" {
+		return nil
+	}
+	// Backends without step metadata skip the tag and the mismatch check with it.
+	if store, ok := c.backend.(stepMetadataStore); ok {
+		return store.SetStepMetadata(c.WorkflowID, ref.StepKey, meta)
+	}
+	return nil
+}
+
+// jsonOutput rejects outputs tagged with a non-default codec, for readers
+// that hand out output_json without a Context to decode it.
+func jsonOutput(record StepRecord) error {
+	meta, err := decodeStepMetadata(record)
+	if err != nil {
+		return err
+	}
+	if stored, _ := meta["codec"].(string); stored != "" && stored != jsonCodecName {
+		return fmt.Errorf("step %s: %w: stored %s, reader needs %s", record.StepKey, ErrCodecMismatch, stored, jsonCodecName)
+	}
+	return nil
+}
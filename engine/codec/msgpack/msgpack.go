@@ -0,0 +1,46 @@
+// Package msgpack provides a MessagePack engine.Codec. It lives apart from
+// package engine so the engine itself does not depend on a MessagePack
+// library.
+package msgpack
+
+import (
+	"bytes"
+
+	"github.com/vmihailenco/msgpack/v5"
+
+	"durableexec/engine"
+)
+
+// Name is recorded with every step checkpointed by MsgpackCodec.
+const Name = "msgpack"
+
+// MsgpackCodec encodes step outputs as MessagePack. Struct fields use their
+// msgpack tags, falling back to json tags, so types already tagged for JSON
+// keep their field names.
+type MsgpackCodec struct{}
+
+var _ engine.Codec = MsgpackCodec{}
+
+func (MsgpackCodec) Marshal(v any) ([]byte, error) {
+	enc := msgpack.GetEncoder()
+	defer msgpack.PutEncoder(enc)
+
+	var buf bytes.Buffer
+	enc.Reset(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (MsgpackCodec) Unmarshal(data []byte, v any) error {
+	dec := msgpack.GetDecoder()
+	defer msgpack.PutDecoder(dec)
+
+	dec.Reset(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+func (MsgpackCodec) Name() string { return Name }
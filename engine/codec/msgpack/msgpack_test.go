@@ -0,0 +1,107 @@
+package msgpack
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"durableexec/engine"
+)
+
+type order struct {
+	ID    string   `json:"id"`
+	Cents int64    `json:"cents"`
+	Tags  []string `json:"tags"`
+}
+
+func TestStepReplaysMsgpackOutputAndRejectsJSONContext(t *testing.T) {
+	store, err := engine.NewStore(filepath.Join(t.TempDir(), "msgpack.db"))
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	want := order{ID: "o-1", Cents: 4200, Tags: []string{"gift"}}
+	calls := 0
+	run := func(ctx *engine.Context) (order, error) {
+		return engine.Step(ctx, "load_order", func() (order, error) {
+			calls++
+			return want, nil
+		})
+	}
+
+	for i := 0; i < 2; i++ {
+		got, err := run(engine.NewContext("wf-msgpack", store).WithCodec(MsgpackCodec{}))
+		if err != nil {
+			t.Fatalf("run %d: %v", i, err)
+		}
+		if got.ID != want.ID || got.Cents != want.Cents || len(got.Tags) != 1 {
+			t.Fatalf("run %d: unexpected output %+v", i, got)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("expected the second run to replay, ran %d times", calls)
+	}
+
+	if _, err := run(engine.NewContext("wf-msgpack", store)); !errors.Is(err, engine.ErrCodecMismatch) {
+		t.Fatalf("expected ErrCodecMismatch for a JSON context, got %v", err)
+	}
+
+	if _, err := run(engine.NewContext("wf-json", store)); err != nil {
+		t.Fatalf("json run: %v", err)
+	}
+	if _, err := run(engine.NewContext("wf-json", store).WithCodec(MsgpackCodec{})); !errors.Is(err, engine.ErrCodecMismatch) {
+		t.Fatalf("expected ErrCodecMismatch for JSON output under msgpack, got %v", err)
+	}
+}
+
+func TestMsgpackCheckpointsReduceAndBulkSteps(t *testing.T) {
+	store, err := engine.NewStore(filepath.Join(t.TempDir(), "msgpack-helpers.db"))
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	const workflowID = "wf-msgpack-helpers"
+
+	for run := 0; run < 2; run++ {
+		ctx := engine.NewContext(workflowID, store).WithCodec(MsgpackCodec{})
+		if run == 0 {
+			if err := engine.Checkpoint(ctx, "cursor", order{ID: "o-9"}); err != nil {
+				t.Fatalf("checkpoint: %v", err)
+			}
+		}
+		got, found, err := engine.ReadCheckpoint[order](ctx, "cursor")
+		if err != nil || !found || got.ID != "o-9" {
+			t.Fatalf("run %d: read checkpoint got %+v found=%v err=%v", run, got, found, err)
+		}
+
+		for i := 1; i <= 3; i++ {
+			if _, err := engine.Step(ctx, "item", func() (int64, error) { return int64(i), nil }); err != nil {
+				t.Fatalf("run %d: item %d: %v", run, i, err)
+			}
+		}
+		sum, err := engine.Reduce(ctx, "item", int64(0), func(acc, v int64) (int64, error) { return acc + v, nil })
+		if err != nil || sum != 6 {
+			t.Fatalf("run %d: reduce got %d err=%v", run, sum, err)
+		}
+
+		out, err := engine.BulkStep(ctx, "fetch", []string{"a", "b"}, func(id string) (order, error) {
+			if run > 0 {
+				t.Fatalf("bulk step %s re-executed on replay", id)
+			}
+			return order{ID: id}, nil
+		})
+		if err != nil || len(out) != 2 || out[1].ID != "b" {
+			t.Fatalf("run %d: bulk got %+v err=%v", run, out, err)
+		}
+	}
+
+	err = store.StreamStepOutputs(workflowID, "item", func(int, json.RawMessage) error { return nil })
+	if !errors.Is(err, engine.ErrCodecMismatch) {
+		t.Fatalf("expected StreamStepOutputs to reject msgpack outputs, got %v", err)
+	}
+	if _, _, err := engine.ReadCheckpoint[order](engine.NewContext(workflowID, store), "cursor"); !errors.Is(err, engine.ErrCodecMismatch) {
+		t.Fatalf("expected ErrCodecMismatch reading a msgpack checkpoint as JSON, got %v", err)
+	}
+}
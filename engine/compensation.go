@@ -0,0 +1,27 @@
+package engine
+
+import "errors"
+
+func (c *Context) pushCompensation(fn func() error) {
+	c.compMu.Lock()
+	c.compensations = append(c.compensations, fn)
+	c.compMu.Unlock()
+}
+
+// RunCompensations runs the compensating actions registered on this Context
+// in reverse order of registration and clears them. Every action is attempted;
+// their errors are joined.
+func (c *Context) RunCompensations() error {
+	c.compMu.Lock()
+	pending := c.compensations
+	c.compensations = nil
+	c.compMu.Unlock()
+
+	var errs []error
+	for i := len(pending) - 1; i >= 0; i-- {
+		if err := pending[i](); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
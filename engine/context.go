@@ -1,7 +1,9 @@
 package engine
 
 import (
+	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"path/filepath"
@@ -9,6 +11,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -17,21 +20,75 @@ type Context struct {
 	RunID         string
 	ZombieTimeout time.Duration
 
-	store *Store
+	backend   StoreBackend
+	store     *Store
+	goCtx     context.Context
+	errFormat ErrorFormatter
+	logger    Logger
+	tracer    Tracer
+	listeners []StepListener
 
-	seqMu        sync.Mutex
-	stepCounters map[string]int
-	claimMu      sync.Mutex
+	bulkConcurrency int
+	maxSteps        int
+	claimedSteps    *atomic.Int64
+	maxOutputBytes  int
+	cache           *stepCache
+	codec           Codec
+
+	retryBackoff    time.Duration
+	retryMaxBackoff time.Duration
+	retryBackoffSet bool
+
+	seqMu      sync.Mutex
+	counter    IDCounter
+	keyPrefix  string
+	totalSteps int
+	claimMu    sync.Mutex
+	replayed   map[string]bool
+
+	compMu        sync.Mutex
+	compensations []func() error
+
+	metaMu   sync.Mutex
+	metadata map[string]string
+}
+
+func NewContext(workflowID string, store StoreBackend) *Context {
+	return NewContextWithIDCounter(workflowID, store, SequentialCounter())
 }
 
-func NewContext(workflowID string, store *Store) *Context {
-	return &Context{
+func NewContextWithIDCounter(workflowID string, store StoreBackend, counter IDCounter) *Context {
+	if counter == nil {
+		counter = SequentialCounter()
+	}
+	c := &Context{
 		WorkflowID:    workflowID,
 		RunID:         newRunID(),
 		ZombieTimeout: 0,
-		store:         store,
-		stepCounters:  make(map[string]int),
+		backend:       store,
+		errFormat:     defaultErrorFormatter{},
+		counter:       counter,
+		claimedSteps:  new(atomic.Int64),
 	}
+	c.store, _ = store.(*Store)
+	return c
+}
+
+// NewContextFromExisting rebuilds a Context for a hand-off: it keeps runID
+// and continues numbering each step id after stepCounters (typically from
+// Store.LoadStepCounters) instead of starting again at 1.
+func NewContextFromExisting(workflowID, runID string, stepCounters map[string]int, store StoreBackend) *Context {
+	c := NewContextWithIDCounter(workflowID, store, newSequentialCounter(stepCounters))
+	if runID != "" {
+		c.RunID = runID
+	}
+	return c
+}
+
+// NewContextFromRecord resumes an abandoned workflow under the run recorded in
+// the workflows table, continuing step numbering after counters.
+func NewContextFromRecord(record WorkflowRecord, counters map[string]int, store StoreBackend) *Context {
+	return NewContextFromExisting(record.WorkflowID, record.RunID, counters, store)
 }
 
 func (c *Context) WithZombieTimeout(d time.Duration) *Context {
@@ -39,27 +96,86 @@ func (c *Context) WithZombieTimeout(d time.Duration) *Context {
 	return c
 }
 
-type stepRef struct {
+// WithMaxSteps caps how many steps this Context will execute; steps replayed
+// from a checkpoint do not count. Past the limit Step returns
+// ErrMaxStepsExceeded without writing a row, so a later Context can resume.
+// The count lives in memory, starts at zero per Context and is shared with
+// every Context forked from it.
+func (c *Context) WithMaxSteps(n int) *Context {
+	c.maxSteps = n
+	return c
+}
+
+// StepKeyFormat renders a step id and its logical sequence into the
+// checkpoint key stored in the steps table.
+const StepKeyFormat = "%s#%06d"
+
+// ErrorFormatter renders a step error into the text persisted as error_text.
+type ErrorFormatter interface {
+	Format(workflowID, stepKey string, err error) string
+}
+
+type ErrorFormatterFunc func(workflowID, stepKey string, err error) string
+
+func (f ErrorFormatterFunc) Format(workflowID, stepKey string, err error) string {
+	return f(workflowID, stepKey, err)
+}
+
+type defaultErrorFormatter struct{}
+
+func (defaultErrorFormatter) Format(_, _ string, err error) string {
+	return err.Error()
+}
+
+func (c *Context) WithErrorFormatter(f ErrorFormatter) *Context {
+	if f == nil {
+		f = defaultErrorFormatter{}
+	}
+	c.errFormat = f
+	return c
+}
+
+func (c *Context) formatError(stepKey string, err error) string {
+	if c.errFormat == nil {
+		return err.Error()
+	}
+	return c.errFormat.Format(c.WorkflowID, stepKey, err)
+}
+
+type StepRef struct {
 	StepID   string
 	Sequence int
 	StepKey  string
 }
 
-func (c *Context) nextStepRef(id string) stepRef {
-	stepID := resolveStepID(id)
+func (c *Context) nextStepRef(id string) StepRef {
+	stepID := c.keyPrefix + resolveStepID(id)
 
 	c.seqMu.Lock()
-	c.stepCounters[stepID]++
-	seq := c.stepCounters[stepID]
+	seq := c.counter.Next(stepID)
+	c.totalSteps++
 	c.seqMu.Unlock()
 
-	return stepRef{
+	return StepRef{
 		StepID:   stepID,
 		Sequence: seq,
-		StepKey:  fmt.Sprintf("%s#%06d", stepID, seq),
+		StepKey:  fmt.Sprintf(StepKeyFormat, stepID, seq),
 	}
 }
 
+// SpanID returns a 16-hex-digit id: 8 digits hashed from the workflow id,
+// then the number of steps started so far as 8 zero-padded hex digits. It is
+// stable across replays and grows with every step, so external calls can tag
+// their own spans with it and sort them into causal order.
+func (c *Context) SpanID() string {
+	c.seqMu.Lock()
+	n := c.totalSteps
+	c.seqMu.Unlock()
+
+	sum := sha256.Sum256([]byte(c.WorkflowID))
+	return fmt.Sprintf("%s%08x", hex.EncodeToString(sum[:4]), uint32(n))
+}
+
 func resolveStepID(id string) string {
 	id = strings.TrimSpace(id)
 	if id == "" {
@@ -0,0 +1,92 @@
+package engine
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ContextStoreOptions tunes how one Context reads and writes checkpoints,
+// independently of how the Store itself is configured.
+type ContextStoreOptions struct {
+	// ReadFromCache preloads the workflow's steps once and serves completed
+	// checkpoints from memory instead of querying per step.
+	ReadFromCache bool
+	// WriteThroughCache adds outputs to the cache as steps complete. It only
+	// has an effect together with ReadFromCache.
+	WriteThroughCache bool
+	// MaxOutputBytes rejects step outputs whose JSON encoding is larger.
+	// Zero means no limit.
+	MaxOutputBytes int
+	// DisableHeartbeat is accepted for forward compatibility; the engine does
+	// not heartbeat running steps yet, so it currently has no effect.
+	DisableHeartbeat bool
+}
+
+type stepCache struct {
+	mu      sync.Mutex
+	records map[string]StepRecord
+	write   bool
+}
+
+func NewContextWithStoreOptions(workflowID string, store StoreBackend, opts ContextStoreOptions) *Context {
+	c := NewContext(workflowID, store)
+	c.maxOutputBytes = opts.MaxOutputBytes
+	if !opts.ReadFromCache || isNilBackend(store) {
+		return c
+	}
+
+	// A failed preload only costs the optimisation: reads fall back to the store.
+	rows, err := store.ListSteps(workflowID)
+	if err != nil {
+		return c
+	}
+	cache := &stepCache{records: make(map[string]StepRecord, len(rows)), write: opts.WriteThroughCache}
+	for _, row := range rows {
+		if row.Status == statusCompleted {
+			cache.records[row.StepKey] = row
+		}
+	}
+	c.cache = cache
+	return c
+}
+
+// loadStep returns a completed step from the context cache when possible and
+// otherwise reads it from the store.
+func (c *Context) loadStep(stepKey string) (StepRecord, bool, error) {
+	if c.cache != nil {
+		c.cache.mu.Lock()
+		record, ok := c.cache.records[stepKey]
+		c.cache.mu.Unlock()
+		if ok {
+			return record, true, nil
+		}
+	}
+	return c.backend.GetStep(c.WorkflowID, stepKey)
+}
+
+func (c *Context) cacheCompleted(ref StepRef, outputJSON string) {
+	if c.cache == nil || !c.cache.write {
+		return
+	}
+	metadataJSON := c.codecMetadataJSON()
+	c.cache.mu.Lock()
+	c.cache.records[ref.StepKey] = StepRecord{
+		WorkflowID:     c.WorkflowID,
+		StepKey:        ref.StepKey,
+		StepID:         ref.StepID,
+		Sequence:       ref.Sequence,
+		Status:         statusCompleted,
+		OutputJSON:     outputJSON,
+		OutputChecksum: outputChecksum(outputJSON),
+		MetadataJSON:   metadataJSON,
+		RunID:          c.RunID,
+	}
+	c.cache.mu.Unlock()
+}
+
+func (c *Context) checkOutputSize(ref StepRef, payload []byte) error {
+	if c.maxOutputBytes > 0 && len(payload) > c.maxOutputBytes {
+		return fmt.Errorf("step %s output is %d bytes, limit is %d", ref.StepKey, len(payload), c.maxOutputBytes)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package engine
+
+import "fmt"
+
+// IDCounter assigns the logical sequence for each occurrence of a step id.
+// Context serializes calls to Next, so implementations need no locking.
+type IDCounter interface {
+	Next(stepID string) int
+}
+
+type sequentialCounter struct {
+	counts map[string]int
+}
+
+// SequentialCounter numbers repeated step ids 1, 2, 3, ... per id. It is the
+// counter used by NewContext.
+func SequentialCounter() IDCounter {
+	return newSequentialCounter(nil)
+}
+
+func newSequentialCounter(seed map[string]int) *sequentialCounter {
+	counts := make(map[string]int, len(seed))
+	for id, seq := range seed {
+		counts[id] = seq
+	}
+	return &sequentialCounter{counts: counts}
+}
+
+func (c *sequentialCounter) Next(stepID string) int {
+	c.counts[stepID]++
+	return c.counts[stepID]
+}
+
+type deterministicCounter struct {
+	seed map[string]int
+}
+
+// DeterministicCounter always returns the sequence seeded for a step id and
+// panics for ids missing from seed. Replay tests use it to pin exact keys.
+func DeterministicCounter(seed map[string]int) IDCounter {
+	fixed := make(map[string]int, len(seed))
+	for id, seq := range seed {
+		fixed[id] = seq
+	}
+	return &deterministicCounter{seed: fixed}
+}
+
+func (c *deterministicCounter) Next(stepID string) int {
+	seq, ok := c.seed[stepID]
+	if !ok {
+		panic(fmt.Sprintf("deterministic counter: unexpected step id %q", stepID))
+	}
+	return seq
+}
@@ -0,0 +1,179 @@
+package engine
+
+// Dialect builds the SQL for the core step persistence operations. Values
+// are never written into the SQL; they are bound to $1, $2, ... placeholders,
+// which SQLite and Postgres both accept.
+type Dialect interface {
+	Name() string
+	InitSchemaDDL() string
+	GetStepSQL(workflowID, stepKey string) Statement
+	UpsertRunningSQL(workflowID string, ref StepRef, runID, now, staleBefore string) []Statement
+	MarkCompletedSQL(workflowID, stepKey, runID, outputJSON, now string) Statement
+	MarkFailedSQL(workflowID, stepKey, runID, errText, now string) Statement
+	SetStepMetadataSQL(workflowID, stepKey, metadataJSON string) Statement
+	ListStepsSQL(workflowID string) Statement
+}
+
+// Statement is a single SQL statement and the arguments bound to its
+// placeholders.
+type Statement struct {
+	Query string
+	Args  []any
+	// Claim marks the statement that takes ownership of a step. If it changes
+	// no row, another run holds the step and the transaction is rolled back
+	// with ErrStepStillRunning.
+	Claim bool
+}
+
+type SQLiteDialect struct{}
+
+func (SQLiteDialect) Name() string { return "sqlite" }
+
+func (SQLiteDialect) InitSchemaDDL() string {
+	return `
+CREATE TABLE IF NOT EXISTS steps (
+  workflow_id TEXT NOT NULL,
+  step_key TEXT NOT NULL,
+  step_id TEXT NOT NULL,
+  sequence INTEGER NOT NULL,
+  status TEXT NOT NULL,
+  output_json TEXT,
+  error_text TEXT,
+  run_id TEXT NOT NULL,
+  started_at TEXT NOT NULL,
+  updated_at TEXT NOT NULL,
+  completed_at TEXT,
+  metadata_json TEXT,
+  output_checksum TEXT,
+  PRIMARY KEY (workflow_id, step_key)
+);
+CREATE INDEX IF NOT EXISTS idx_steps_workflow_status ON steps(workflow_id, status);
+CREATE TABLE IF NOT EXISTS workflow_logs (
+  workflow_id TEXT NOT NULL,
+  seq INTEGER NOT NULL,
+  logged_at TEXT NOT NULL,
+  level TEXT NOT NULL,
+  message TEXT NOT NULL,
+  fields_json TEXT,
+  PRIMARY KEY (workflow_id, seq)
+);
+CREATE TABLE IF NOT EXISTS workflows (
+  workflow_id TEXT NOT NULL PRIMARY KEY,
+  run_id TEXT NOT NULL,
+  status TEXT NOT NULL,
+  input_json TEXT,
+  metadata_json TEXT,
+  priority INTEGER NOT NULL DEFAULT 0,
+  created_at TEXT NOT NULL,
+  updated_at TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_workflows_status ON workflows(status);
+CREATE TABLE IF NOT EXISTS step_attempts (
+  workflow_id TEXT NOT NULL,
+  step_key TEXT NOT NULL,
+  attempt INTEGER NOT NULL,
+  run_id TEXT NOT NULL,
+  started_at TEXT NOT NULL,
+  PRIMARY KEY (workflow_id, step_key, attempt)
+);
+CREATE TABLE IF NOT EXISTS schema_migrations (
+  version INTEGER NOT NULL PRIMARY KEY,
+  applied_at TEXT NOT NULL
+);
+`
+}
+
+func (SQLiteDialect) GetStepSQL(workflowID, stepKey string) Statement {
+	return Statement{Query: `
+SELECT ` + stepColumns + `
+FROM steps
+WHERE workflow_id=$1 AND step_key=$2
+LIMIT 1;`, Args: []any{workflowID, stepKey}}
+}
+
+// UpsertRunningSQL snapshots the step into step_history, claims it and
+// records the attempt. A step running under another run is only taken over
+// if it was last updated at or before staleBefore. The attempt insert relies
+// on changes() still counting the rows the claim touched, so the statements
+// must run in order on one connection.
+func (SQLiteDialect) UpsertRunningSQL(workflowID string, ref StepRef, runID, now, staleBefore string) []Statement {
+	return []Statement{
+		stepHistorySnapshotSQL(workflowID, ref.StepKey, now),
+		{Query: `
+INSERT INTO steps(workflow_id, step_key, step_id, sequence, status, output_json, error_text, run_id, started_at, updated_at, attempt_count, retry_base)
+VALUES($1, $2, $3, $4, $5, NULL, NULL, $6, $7, $7, 1, (` + stepAttemptCountSQL + `))
+ON CONFLICT(workflow_id, step_key) DO UPDATE SET
+  status=$5,
+  output_json=NULL,
+  error_text=NULL,
+  completed_at=NULL,
+  metadata_json=NULL,
+  output_checksum=NULL,
+  attempt_count=COALESCE(steps.attempt_count, 0) + 1,
+  retry_base=CASE WHEN steps.status=$9 THEN (` + stepAttemptCountSQL + `) ELSE steps.retry_base END,
+  run_id=excluded.run_id,
+  started_at=excluded.started_at,
+  updated_at=excluded.updated_at
+WHERE steps.status <> $8
+  AND (steps.status <> $5 OR steps.run_id = $6 OR julianday(steps.updated_at) <= julianday($10));`,
+			Args:  []any{workflowID, ref.StepKey, ref.StepID, ref.Sequence, statusRunning, runID, now, statusCompleted, statusFailed, staleBefore},
+			Claim: true,
+		},
+		{Query: `
+INSERT INTO step_attempts(workflow_id, step_key, attempt, run_id, started_at)
+SELECT $1, $2, next_attempt, $3, $4
+FROM (SELECT COALESCE(MAX(attempt), 0) + 1 AS next_attempt FROM step_attempts WHERE workflow_id=$1 AND step_key=$2)
+WHERE changes() > 0;`,
+			Args: []any{workflowID, ref.StepKey, runID, now},
+		},
+	}
+}
+
+// stepAttemptCountSQL counts the step's claims so far, taking the workflow id
+// and step key from $1 and $2. A claim that starts a fresh retry budget
+// stores it as the step's retry_base.
+const stepAttemptCountSQL = "SELECT COUNT(*) FROM step_attempts WHERE workflow_id=$1 AND step_key=$2"
+
+func (SQLiteDialect) MarkCompletedSQL(workflowID, stepKey, runID, outputJSON, now string) Statement {
+	return Statement{Query: `
+UPDATE steps
+SET status=$1,
+    output_json=$2,
+    output_checksum=$3,
+    error_text=NULL,
+    run_id=$4,
+    updated_at=$5,
+    completed_at=$5
+WHERE workflow_id=$6 AND step_key=$7;`,
+		Args: []any{statusCompleted, outputJSON, outputChecksum(outputJSON), runID, now, workflowID, stepKey},
+	}
+}
+
+func (SQLiteDialect) MarkFailedSQL(workflowID, stepKey, runID, errText, now string) Statement {
+	return Statement{Query: `
+UPDATE steps
+SET status=$1,
+    error_text=$2,
+    run_id=$3,
+    updated_at=$4
+WHERE workflow_id=$5 AND step_key=$6;`,
+		Args: []any{statusFailed, errText, runID, now, workflowID, stepKey},
+	}
+}
+
+func (SQLiteDialect) SetStepMetadataSQL(workflowID, stepKey, metadataJSON string) Statement {
+	return Statement{Query: `
+UPDATE steps
+SET metadata_json=$1
+WHERE workflow_id=$2 AND step_key=$3;`,
+		Args: []any{metadataJSON, workflowID, stepKey},
+	}
+}
+
+func (SQLiteDialect) ListStepsSQL(workflowID string) Statement {
+	return Statement{Query: `
+SELECT ` + stepColumns + `
+FROM steps
+WHERE workflow_id=$1
+ORDER BY sequence, step_key;`, Args: []any{workflowID}}
+}
@@ -0,0 +1,94 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ExportWorkflow writes the workflow's steps to w as a JSON array of
+// StepRecord, in ListSteps order.
+func (s *Store) ExportWorkflow(workflowID string, w io.Writer) error {
+	steps, err := s.ListSteps(workflowID)
+	if err != nil {
+		return fmt.Errorf("export workflow %s: %w", workflowID, err)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(steps); err != nil {
+		return fmt.Errorf("export workflow %s: %w", workflowID, err)
+	}
+	return nil
+}
+
+// ImportWorkflow reads an ExportWorkflow snapshot and upserts its steps in one
+// transaction. Steps already completed in this store are left alone, so
+// importing the same snapshot twice is harmless. Every record must belong to
+// the same workflow.
+func (s *Store) ImportWorkflow(r io.Reader) error {
+	var steps []StepRecord
+	if err := json.NewDecoder(r).Decode(&steps); err != nil {
+		return fmt.Errorf("import workflow: decode snapshot: %w", err)
+	}
+	if len(steps) == 0 {
+		return errors.New("import workflow: snapshot has no steps")
+	}
+	workflowID := steps[0].WorkflowID
+	if strings.TrimSpace(workflowID) == "" {
+		return errors.New("import workflow: workflow id is required")
+	}
+
+	stmts := make([]Statement, 0, len(steps))
+	for _, st := range steps {
+		if st.WorkflowID != workflowID {
+			return fmt.Errorf("import workflow: step %s belongs to %q, expected %q", st.StepKey, st.WorkflowID, workflowID)
+		}
+		if st.StepKey == "" {
+			return fmt.Errorf("import workflow %s: step key is required", workflowID)
+		}
+		stmts = append(stmts, Statement{Query: `
+INSERT INTO steps(` + stepColumns + `)
+VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+ON CONFLICT(workflow_id, step_key) DO UPDATE SET
+  step_id=excluded.step_id,
+  sequence=excluded.sequence,
+  status=excluded.status,
+  output_json=excluded.output_json,
+  error_text=excluded.error_text,
+  run_id=excluded.run_id,
+  started_at=excluded.started_at,
+  updated_at=excluded.updated_at,
+  completed_at=excluded.completed_at,
+  metadata_json=excluded.metadata_json,
+  output_checksum=excluded.output_checksum,
+  attempt_count=excluded.attempt_count,
+  retry_base=excluded.retry_base
+WHERE steps.status <> $16;`,
+			Args: []any{
+				st.WorkflowID,
+				st.StepKey,
+				st.StepID,
+				st.Sequence,
+				st.Status,
+				sqlNullString(st.OutputJSON),
+				sqlNullString(st.ErrorText),
+				st.RunID,
+				st.StartedAt,
+				st.UpdatedAt,
+				sqlNullString(st.CompletedAt),
+				sqlNullString(st.MetadataJSON),
+				sqlNullString(st.OutputChecksum),
+				st.AttemptCount,
+				st.RetryBase,
+				statusCompleted,
+			},
+		})
+	}
+	if err := s.execTx(context.Background(), stmts); err != nil {
+		return fmt.Errorf("import workflow %s: %w", workflowID, err)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package engine
+
+// Fork returns a child Context for a sub-workflow. It shares the parent's
+// store, workflow id, run id, zombie timeout and options, but numbers its
+// steps with its own counter and stores them under "prefix:id#seq", so two
+// sub-workflows can reuse step ids without colliding. Compensations are the
+// child's own, but steps it executes count towards the parent's WithMaxSteps
+// limit.
+func (c *Context) Fork(prefix string) *Context {
+	child := &Context{
+		WorkflowID:    c.WorkflowID,
+		RunID:         c.RunID,
+		ZombieTimeout: c.ZombieTimeout,
+
+		backend:   c.backend,
+		store:     c.store,
+		goCtx:     c.goCtx,
+		errFormat: c.errFormat,
+		logger:    c.logger,
+		tracer:    c.tracer,
+		listeners: append([]StepListener(nil), c.listeners...),
+
+		bulkConcurrency: c.bulkConcurrency,
+		maxSteps:        c.maxSteps,
+		claimedSteps:    c.claimedSteps,
+		maxOutputBytes:  c.maxOutputBytes,
+		cache:           c.cache,
+		codec:           c.codec,
+
+		retryBackoff:    c.retryBackoff,
+		retryMaxBackoff: c.retryMaxBackoff,
+		retryBackoffSet: c.retryBackoffSet,
+
+		counter:   SequentialCounter(),
+		keyPrefix: c.keyPrefix + prefix + ":",
+		metadata:  c.Metadata(),
+	}
+	return child
+}
@@ -0,0 +1,62 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var ErrInputChanged = errors.New("step input changed since it was checkpointed")
+
+// StepWithInputHash behaves like Step but records a hash of input so a replay
+// with different input fails instead of silently returning the stale output.
+func StepWithInputHash[T, I any](ctx *Context, id string, input I, fn func(I) (T, error)) (_ T, err error) {
+	var zero T
+
+	if err := checkStepArgs(ctx, fn == nil); err != nil {
+		return zero, err
+	}
+	hash, err := hashInput(input)
+	if err != nil {
+		return zero, err
+	}
+
+	ref := ctx.nextStepRef(id)
+	end := ctx.notifyBeforeStep(ref)
+	defer func() { end(err) }()
+
+	claim, cached, err := ctx.claimStep(ref)
+	if err != nil {
+		return zero, err
+	}
+
+	if claim == claimCached {
+		meta, err := decodeStepMetadata(cached)
+		if err != nil {
+			return zero, err
+		}
+		// Checkpoints written without a hash cannot be verified and are trusted.
+		if stored, ok := meta["input_hash"].(string); ok && stored != hash {
+			return zero, fmt.Errorf("step %s: %w", ref.StepKey, ErrInputChanged)
+		}
+		return decodeCached[T](ctx, ref, cached)
+	}
+
+	if err := ctx.writeStepMetadata(ref, map[string]any{"input_hash": hash}); err != nil {
+		return zero, err
+	}
+	return runClaimed(ctx, ref, func() (T, error) {
+		return fn(input)
+	})
+}
+
+func hashInput(input any) (string, error) {
+	payload, err := json.Marshal(input)
+	if err != nil {
+		return "", fmt.Errorf("marshal step input: %w", err)
+	}
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,75 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	IntegrityDuplicateStepKey = "duplicate_step_key"
+	IntegrityMissingOutput    = "missing_output"
+	IntegrityMissingError     = "missing_error"
+	IntegrityInvalidSequence  = "invalid_sequence"
+	IntegrityMalformedStepKey = "malformed_step_key"
+	IntegrityInvalidTimestamp = "invalid_timestamp"
+)
+
+type IntegrityError struct {
+	Kind    string
+	StepKey string
+	Detail  string
+}
+
+func (e IntegrityError) Error() string {
+	return fmt.Sprintf("%s: step %s: %s", e.Kind, e.StepKey, e.Detail)
+}
+
+func (s *Store) ValidateIntegrity(workflowID string) ([]IntegrityError, error) {
+	rows, err := s.ListSteps(workflowID)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []IntegrityError
+	report := func(kind, stepKey, format string, args ...any) {
+		problems = append(problems, IntegrityError{
+			Kind:    kind,
+			StepKey: stepKey,
+			Detail:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	seen := make(map[string]struct{}, len(rows))
+	for _, row := range rows {
+		if _, ok := seen[row.StepKey]; ok {
+			report(IntegrityDuplicateStepKey, row.StepKey, "step key appears more than once")
+		}
+		seen[row.StepKey] = struct{}{}
+
+		switch row.Status {
+		case statusCompleted:
+			if strings.TrimSpace(row.OutputJSON) == "" {
+				report(IntegrityMissingOutput, row.StepKey, "completed step has empty output_json")
+			}
+		case statusFailed:
+			if strings.TrimSpace(row.ErrorText) == "" {
+				report(IntegrityMissingError, row.StepKey, "failed step has empty error_text")
+			}
+		}
+
+		if row.Sequence < 0 || row.Sequence == 0 && !isCheckpointStepID(row.StepID) {
+			report(IntegrityInvalidSequence, row.StepKey, "sequence must be positive, got %d", row.Sequence)
+		}
+		if want := fmt.Sprintf(StepKeyFormat, row.StepID, row.Sequence); row.StepKey != want {
+			report(IntegrityMalformedStepKey, row.StepKey, "expected step key %q", want)
+		}
+		if _, err := time.Parse(time.RFC3339Nano, row.StartedAt); err != nil {
+			report(IntegrityInvalidTimestamp, row.StepKey, "started_at %q is not RFC3339Nano", row.StartedAt)
+		}
+		if _, err := time.Parse(time.RFC3339Nano, row.UpdatedAt); err != nil {
+			report(IntegrityInvalidTimestamp, row.StepKey, "updated_at %q is not RFC3339Nano", row.UpdatedAt)
+		}
+	}
+	return problems, nil
+}
@@ -0,0 +1,67 @@
+package engine
+
+import (
+	"fmt"
+	"time"
+)
+
+// StepListener observes every step a Context runs. status is "completed" or
+// "failed" for executed steps and "cached" for steps replayed from their
+// checkpoint.
+type StepListener interface {
+	BeforeStep(workflowID, stepKey, runID string)
+	AfterStep(workflowID, stepKey, runID, status string, durationMs int64)
+}
+
+// WithListener adds l to the listeners notified around each step. Listeners
+// run in registration order; a panicking listener is logged and skipped.
+func (c *Context) WithListener(l StepListener) *Context {
+	if l != nil {
+		c.listeners = append(c.listeners, l)
+	}
+	return c
+}
+
+// notifyBeforeStep calls BeforeStep on every listener and returns the function
+// that reports the step's outcome to AfterStep.
+func (c *Context) notifyBeforeStep(ref StepRef) func(error) {
+	if len(c.listeners) == 0 {
+		return func(error) {}
+	}
+	for _, l := range c.listeners {
+		c.callListener(ref, func() { l.BeforeStep(c.WorkflowID, ref.StepKey, c.RunID) })
+	}
+	start := time.Now()
+	return func(err error) {
+		status := statusCompleted
+		if err != nil {
+			status = statusFailed
+		} else if c.takeReplayed(ref) {
+			status = statusCachedStep
+		}
+		elapsed := time.Since(start).Milliseconds()
+		for _, l := range c.listeners {
+			c.callListener(ref, func() { l.AfterStep(c.WorkflowID, ref.StepKey, c.RunID, status, elapsed) })
+		}
+	}
+}
+
+// statusCachedStep is only reported to listeners; it is never stored.
+const statusCachedStep = "cached"
+
+func (c *Context) takeReplayed(ref StepRef) bool {
+	c.claimMu.Lock()
+	defer c.claimMu.Unlock()
+	replayed := c.replayed[ref.StepKey]
+	delete(c.replayed, ref.StepKey)
+	return replayed
+}
+
+func (c *Context) callListener(ref StepRef, fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.Log(LogLevelError, "step listener panicked", map[string]any{"step_key": ref.StepKey, "panic": fmt.Sprint(r)})
+		}
+	}()
+	fn()
+}
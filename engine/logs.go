@@ -0,0 +1,118 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+const (
+	LogLevelDebug = "debug"
+	LogLevelInfo  = "info"
+	LogLevelWarn  = "warn"
+	LogLevelError = "error"
+)
+
+type LogEntry struct {
+	WorkflowID string
+	Seq        int
+	LoggedAt   string
+	Level      string
+	Message    string
+	FieldsJSON string
+}
+
+type Logger interface {
+	Log(level, message string, fields map[string]any)
+}
+
+// AppendWorkflowLog appends entries to the workflow's audit log in one
+// transaction. Sequence numbers are assigned by the store.
+func (s *Store) AppendWorkflowLog(workflowID string, entries []LogEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	stmts := make([]Statement, 0, len(entries))
+	for _, e := range entries {
+		loggedAt := e.LoggedAt
+		if loggedAt == "" {
+			loggedAt = time.Now().UTC().Format(time.RFC3339Nano)
+		}
+		level := e.Level
+		if level == "" {
+			level = LogLevelInfo
+		}
+		stmts = append(stmts, Statement{Query: `
+INSERT INTO workflow_logs(workflow_id, seq, logged_at, level, message, fields_json)
+SELECT $1, COALESCE(MAX(seq), 0) + 1, $2, $3, $4, $5
+FROM workflow_logs
+WHERE workflow_id=$1;`,
+			Args: []any{workflowID, loggedAt, level, e.Message, sqlNullString(e.FieldsJSON)},
+		})
+	}
+	return s.execTx(context.Background(), stmts)
+}
+
+func (s *Store) GetWorkflowLog(workflowID string, afterSeq int) ([]LogEntry, error) {
+	rows, err := s.queryRows(`
+SELECT workflow_id, seq, logged_at, level, message, fields_json
+FROM workflow_logs
+WHERE workflow_id=$1 AND seq>$2
+ORDER BY seq;`, workflowID, afterSeq)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]LogEntry, 0, len(rows))
+	for _, row := range rows {
+		out = append(out, LogEntry{
+			WorkflowID: asString(row["workflow_id"]),
+			Seq:        asInt(row["seq"]),
+			LoggedAt:   asString(row["logged_at"]),
+			Level:      asString(row["level"]),
+			Message:    asString(row["message"]),
+			FieldsJSON: asString(row["fields_json"]),
+		})
+	}
+	return out, nil
+}
+
+type storeLogger struct {
+	store      *Store
+	workflowID string
+}
+
+// NewStoreLogger returns a Logger that persists every entry to the
+// workflow_logs table. Write failures are dropped so logging never fails a step.
+func NewStoreLogger(store *Store, workflowID string) Logger {
+	return &storeLogger{store: store, workflowID: workflowID}
+}
+
+func (l *storeLogger) Log(level, message string, fields map[string]any) {
+	entry := LogEntry{Level: level, Message: message}
+	if len(fields) > 0 {
+		if payload, err := json.Marshal(fields); err == nil {
+			entry.FieldsJSON = string(payload)
+		}
+	}
+	_ = l.store.AppendWorkflowLog(l.workflowID, []LogEntry{entry})
+}
+
+// WithCustomLogger sends the Context's step events to l instead of the
+// store's slog.Logger. Use WithLogger for a *slog.Logger.
+func (c *Context) WithCustomLogger(l Logger) *Context {
+	c.logger = l
+	return c
+}
+
+// Log sends an event to the Context's Logger, or else to the slog.Logger of
+// its *Store. Without either the event is dropped.
+func (c *Context) Log(level, message string, fields map[string]any) {
+	if c.logger != nil {
+		c.logger.Log(level, message, fields)
+		return
+	}
+	if c.store != nil && c.store.logger != nil {
+		SlogLogger(c.store.logger).Log(level, message, fields)
+	}
+}
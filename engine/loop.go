@@ -0,0 +1,74 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// LoopProgress reports how many iterations of the loop calling Step with id
+// loopID have completed, out of all that have been claimed so far. Each
+// iteration is a sequence of the same step id. It is safe to call from a
+// goroutine other than the one running the loop.
+func LoopProgress(ctx *Context, loopID string) (completed, total int, err error) {
+	if ctx == nil {
+		return 0, 0, errors.New("nil durable context")
+	}
+	store, err := ctx.sqlStore("loop progress")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	rows, err := store.queryRows(`
+SELECT COUNT(*) AS total,
+       COALESCE(SUM(CASE WHEN status=$1 THEN 1 ELSE 0 END), 0) AS completed
+FROM steps
+WHERE workflow_id=$2 AND step_id=$3;`,
+		statusCompleted,
+		ctx.WorkflowID,
+		ctx.keyPrefix+resolveStepID(loopID),
+	)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(rows) == 0 {
+		return 0, 0, nil
+	}
+	return asInt(rows[0]["completed"]), asInt(rows[0]["total"]), nil
+}
+
+// Reduce folds the outputs of every completed loopID checkpoint, in sequence
+// order, into initial. The result is checkpointed as loopID + "_reduced", so a
+// replay returns it without re-reading the loop's outputs.
+func Reduce[T, A any](ctx *Context, loopID string, initial A, fn func(A, T) (A, error)) (A, error) {
+	var zero A
+	if ctx == nil {
+		return zero, errors.New("nil durable context")
+	}
+	if strings.TrimSpace(loopID) == "" {
+		return zero, errors.New("loop id is required")
+	}
+	if fn == nil {
+		return zero, errors.New("reduce function is nil")
+	}
+
+	store, err := ctx.sqlStore("reduce")
+	if err != nil {
+		return zero, err
+	}
+
+	stepID := resolveStepID(loopID)
+	return Step(ctx, stepID+"_reduced", func() (A, error) {
+		acc := initial
+		err := store.forEachStepOutput(ctx.WorkflowID, ctx.keyPrefix+stepID, func(record StepRecord) error {
+			var item T
+			if err := ctx.decodeOutput(record, &item); err != nil {
+				return fmt.Errorf("decode %s output %d: %w", stepID, record.Sequence, err)
+			}
+			var err error
+			acc, err = fn(acc, item)
+			return err
+		})
+		return acc, err
+	})
+}
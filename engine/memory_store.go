@@ -0,0 +1,174 @@
+package engine
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a StoreBackend that keeps steps in a map. Nothing survives
+// the process, so it is meant for unit tests of workflow code rather than for
+// durability.
+type MemoryStore struct {
+	mu    sync.RWMutex
+	steps map[string]StepRecord
+}
+
+var _ StoreBackend = (*MemoryStore)(nil)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{steps: make(map[string]StepRecord)}
+}
+
+func memoryStepKey(workflowID, stepKey string) string {
+	return workflowID + "\x00" + stepKey
+}
+
+func (m *MemoryStore) InitSchema() error {
+	return nil
+}
+
+func (m *MemoryStore) GetStep(workflowID, stepKey string) (StepRecord, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	record, ok := m.steps[memoryStepKey(workflowID, stepKey)]
+	return record, ok, nil
+}
+
+// UpsertRunning mirrors the SQLite upsert: a new step is inserted as running,
+// any other step is reset to running under runID, and a completed step is
+// left untouched.
+func (m *MemoryStore) UpsertRunning(workflowID string, ref StepRef, runID string) error {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	key := memoryStepKey(workflowID, ref.StepKey)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	attempts, retryBase := 0, 0
+	if record, ok := m.steps[key]; ok {
+		if record.Status == statusCompleted {
+			return nil
+		}
+		attempts, retryBase = record.AttemptCount, record.RetryBase
+		if record.Status == statusFailed {
+			retryBase = record.AttemptCount
+		}
+	}
+	m.steps[key] = StepRecord{
+		WorkflowID:   workflowID,
+		StepKey:      ref.StepKey,
+		StepID:       ref.StepID,
+		Sequence:     ref.Sequence,
+		Status:       statusRunning,
+		RunID:        runID,
+		StartedAt:    now,
+		UpdatedAt:    now,
+		AttemptCount: attempts + 1,
+		RetryBase:    retryBase,
+	}
+	return nil
+}
+
+func (m *MemoryStore) MarkCompleted(workflowID, stepKey, runID, outputJSON string) error {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	m.update(workflowID, stepKey, func(record *StepRecord) {
+		record.Status = statusCompleted
+		record.OutputJSON = outputJSON
+		record.OutputChecksum = outputChecksum(outputJSON)
+		record.ErrorText = ""
+		record.RunID = runID
+		record.UpdatedAt = now
+		record.CompletedAt = now
+	})
+	return nil
+}
+
+func (m *MemoryStore) MarkFailed(workflowID, stepKey, runID, errText string) error {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	m.update(workflowID, stepKey, func(record *StepRecord) {
+		record.Status = statusFailed
+		record.ErrorText = errText
+		record.RunID = runID
+		record.UpdatedAt = now
+	})
+	return nil
+}
+
+func (m *MemoryStore) SetStepMetadata(workflowID, stepKey, metadataJSON string) error {
+	m.update(workflowID, stepKey, func(record *StepRecord) {
+		record.MetadataJSON = metadataJSON
+	})
+	return nil
+}
+
+func (m *MemoryStore) PutCheckpoint(workflowID string, ref StepRef, runID, outputJSON, metadataJSON string) error {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	key := memoryStepKey(workflowID, ref.StepKey)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	record, ok := m.steps[key]
+	if !ok {
+		record = StepRecord{WorkflowID: workflowID, StepKey: ref.StepKey, StepID: ref.StepID, Sequence: ref.Sequence, StartedAt: now}
+	}
+	record.Status = statusCompleted
+	record.OutputJSON = outputJSON
+	record.OutputChecksum = outputChecksum(outputJSON)
+	record.MetadataJSON = metadataJSON
+	record.ErrorText = ""
+	record.RunID = runID
+	record.UpdatedAt = now
+	record.CompletedAt = now
+	m.steps[key] = record
+	return nil
+}
+
+func (m *MemoryStore) RecordStepAttempt(workflowID, stepKey, runID, errText string) error {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	m.update(workflowID, stepKey, func(record *StepRecord) {
+		if record.Status != statusRunning {
+			return
+		}
+		record.ErrorText = errText
+		record.RunID = runID
+		record.UpdatedAt = now
+	})
+	return nil
+}
+
+func (m *MemoryStore) GetStepAttemptCount(workflowID, stepKey string) (int, error) {
+	record, _, err := m.GetStep(workflowID, stepKey)
+	return record.AttemptCount, err
+}
+
+// update applies fn to an existing step. Like an UPDATE matching no rows, a
+// missing step is not an error.
+func (m *MemoryStore) update(workflowID, stepKey string, fn func(*StepRecord)) {
+	key := memoryStepKey(workflowID, stepKey)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	record, ok := m.steps[key]
+	if !ok {
+		return
+	}
+	fn(&record)
+	m.steps[key] = record
+}
+
+func (m *MemoryStore) ListSteps(workflowID string) ([]StepRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make([]StepRecord, 0)
+	for _, record := range m.steps {
+		if record.WorkflowID == workflowID {
+			out = append(out, record)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Sequence != out[j].Sequence {
+			return out[i].Sequence < out[j].Sequence
+		}
+		return out[i].StepKey < out[j].StepKey
+	})
+	return out, nil
+}
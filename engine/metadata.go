@@ -0,0 +1,68 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+func decodeStepMetadata(record StepRecord) (map[string]any, error) {
+	meta := make(map[string]any)
+	if record.MetadataJSON == "" {
+		return meta, nil
+	}
+	if err := json.Unmarshal([]byte(record.MetadataJSON), &meta); err != nil {
+		return nil, fmt.Errorf("decode step metadata for %s: %w", record.StepKey, err)
+	}
+	return meta, nil
+}
+
+// writeStepMetadata replaces the step's metadata with meta, keeping the codec
+// tag that claimRunning set.
+func (c *Context) writeStepMetadata(ref StepRef, meta map[string]any) error {
+	if !c.usesJSON() {
+		meta["codec"] = c.codecName()
+	}
+	payload, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("encode step metadata for %s: %w", ref.StepKey, err)
+	}
+	store, ok := c.backend.(stepMetadataStore)
+	if !ok {
+		return fmt.Errorf("write step metadata for %s: %w", ref.StepKey, ErrUnsupportedBackend)
+	}
+	if err := store.SetStepMetadata(c.WorkflowID, ref.StepKey, string(payload)); err != nil {
+		return fmt.Errorf("write step metadata for %s: %w", ref.StepKey, err)
+	}
+	return nil
+}
+
+// outputTypeMetadata merges the dynamic type of an interface-typed step
+// result into the step's metadata so decodeCached can detect lossy replays.
+// It returns the metadata to store with the completion checkpoint, or "" for
+// backends without step metadata.
+func (c *Context) outputTypeMetadata(ref StepRef, typeName string) (string, error) {
+	if _, ok := c.backend.(stepMetadataStore); !ok {
+		// Without metadata the mismatch check is skipped, not the step.
+		return "", nil
+	}
+	record, found, err := c.backend.GetStep(c.WorkflowID, ref.StepKey)
+	if err != nil {
+		return "", fmt.Errorf("load step %s to record output type: %w", ref.StepKey, err)
+	}
+	if !found {
+		return "", fmt.Errorf("record output type for %s: %w", ref.StepKey, ErrStepNotFound)
+	}
+	meta, err := decodeStepMetadata(record)
+	if err != nil {
+		return "", err
+	}
+	meta["output_type"] = typeName
+	if !c.usesJSON() {
+		meta["codec"] = c.codecName()
+	}
+	payload, err := json.Marshal(meta)
+	if err != nil {
+		return "", fmt.Errorf("encode step metadata for %s: %w", ref.StepKey, err)
+	}
+	return string(payload), nil
+}
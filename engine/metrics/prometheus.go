@@ -0,0 +1,77 @@
+// Package metrics exports durable step metrics to Prometheus. It observes
+// steps through engine.StepListener, so the engine does not depend on the
+// Prometheus client.
+package metrics
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"durableexec/engine"
+)
+
+// Collector is both an engine.StepListener and a prometheus.Collector. Attach
+// it with Context.WithListener and register it yourself, for example with
+// prometheus.MustRegister.
+type Collector struct {
+	executions *prometheus.CounterVec
+	duration   *prometheus.HistogramVec
+	cacheHits  *prometheus.CounterVec
+}
+
+var (
+	_ engine.StepListener  = (*Collector)(nil)
+	_ prometheus.Collector = (*Collector)(nil)
+)
+
+func NewPrometheusCollector() *Collector {
+	return &Collector{
+		executions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "durableexec_step_executions_total",
+			Help: "Steps executed, by outcome. Replays from a checkpoint are not counted.",
+		}, []string{"workflow_id", "step_id", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "durableexec_step_duration_seconds",
+			Help:    "Duration of executed steps, including the checkpoint writes.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"workflow_id", "step_id"}),
+		cacheHits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "durableexec_step_cache_hits_total",
+			Help: "Steps replayed from their checkpoint instead of executed.",
+		}, []string{"workflow_id"}),
+	}
+}
+
+func (c *Collector) BeforeStep(workflowID, stepKey, runID string) {}
+
+func (c *Collector) AfterStep(workflowID, stepKey, runID, status string, durationMs int64) {
+	if status == "cached" {
+		c.cacheHits.WithLabelValues(workflowID).Inc()
+		return
+	}
+	stepID := stepIDFromKey(stepKey)
+	c.executions.WithLabelValues(workflowID, stepID, status).Inc()
+	c.duration.WithLabelValues(workflowID, stepID).Observe(float64(durationMs) / 1000)
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.executions.Describe(ch)
+	c.duration.Describe(ch)
+	c.cacheHits.Describe(ch)
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.executions.Collect(ch)
+	c.duration.Collect(ch)
+	c.cacheHits.Collect(ch)
+}
+
+// stepIDFromKey drops the "#000001" sequence suffix so each loop iteration
+// does not become its own label value.
+func stepIDFromKey(stepKey string) string {
+	if i := strings.LastIndexByte(stepKey, '#'); i >= 0 {
+		return stepKey[:i]
+	}
+	return stepKey
+}
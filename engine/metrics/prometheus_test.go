@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"durableexec/engine"
+)
+
+func TestCacheHitsMatchStepsCompletedByFirstRun(t *testing.T) {
+	store, err := engine.NewStore(filepath.Join(t.TempDir(), "metrics.db"))
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	collector := NewPrometheusCollector()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+
+	const workflowID = "wf-metrics"
+	run := func() {
+		err := engine.RunWorkflow(store, workflowID, func(ctx *engine.Context) error {
+			ctx.WithListener(collector)
+			for i := 0; i < 2; i++ {
+				if _, err := engine.Step(ctx, "page", func() (int, error) { return i, nil }); err != nil {
+					return err
+				}
+			}
+			_, err := engine.Step(ctx, "summarize", func() (string, error) { return "done", nil })
+			return err
+		})
+		if err != nil {
+			t.Fatalf("run workflow: %v", err)
+		}
+	}
+	run()
+
+	steps, err := store.ListSteps(workflowID)
+	if err != nil {
+		t.Fatalf("list steps: %v", err)
+	}
+	if got := testutil.ToFloat64(collector.executions.WithLabelValues(workflowID, "page", "completed")); got != 2 {
+		t.Fatalf("expected 2 page executions, got %v", got)
+	}
+
+	run()
+	if got := testutil.ToFloat64(collector.cacheHits.WithLabelValues(workflowID)); got != float64(len(steps)) {
+		t.Fatalf("expected %d cache hits, got %v", len(steps), got)
+	}
+	if got := testutil.CollectAndCount(collector, "durableexec_step_duration_seconds"); got != 2 {
+		t.Fatalf("expected one duration series per step id, got %d", got)
+	}
+}
+
+func TestTableSizeCollectorReportsDataBytes(t *testing.T) {
+	store, err := engine.NewStore(filepath.Join(t.TempDir(), "metrics.db"))
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	err = engine.RunWorkflow(store, "wf-table-size", func(ctx *engine.Context) error {
+		_, err := engine.Step(ctx, "load", func() (string, error) { return "payload", nil })
+		return err
+	})
+	if err != nil {
+		t.Fatalf("run workflow: %v", err)
+	}
+
+	collector := NewTableSizeCollector(store)
+	if got := testutil.CollectAndCount(collector, "durable_table_data_bytes"); got != 2 {
+		t.Fatalf("expected a series per table, got %d", got)
+	}
+	report, err := store.TableSize()
+	if err != nil {
+		t.Fatalf("table size: %v", err)
+	}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+	for _, m := range families[0].GetMetric() {
+		if table := m.GetLabel()[0].GetValue(); table == "steps" && m.GetGauge().GetValue() != float64(report.StepsDataBytes) {
+			t.Fatalf("expected steps gauge %d, got %v", report.StepsDataBytes, m.GetGauge().GetValue())
+		}
+	}
+}
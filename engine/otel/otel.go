@@ -0,0 +1,52 @@
+// Package otel reports durable steps as OpenTelemetry spans. It lives apart
+// from package engine so the engine itself does not depend on OpenTelemetry.
+package otel
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"durableexec/engine"
+)
+
+// SpanName is the name of the span recorded for each executed step.
+const SpanName = "durableexec.step"
+
+const instrumentationName = "durableexec/engine"
+
+// WithTracer makes ctx record its steps with tp.
+func WithTracer(ctx *engine.Context, tp trace.TracerProvider) *engine.Context {
+	return ctx.WithTracer(NewTracer(tp))
+}
+
+// NewTracer adapts tp to engine.Tracer. Spans cover only the step function of
+// executed steps; replays from a checkpoint are not recorded.
+func NewTracer(tp trace.TracerProvider) engine.Tracer {
+	return tracer{t: tp.Tracer(instrumentationName)}
+}
+
+type tracer struct {
+	t trace.Tracer
+}
+
+func (t tracer) StartSpan(goCtx context.Context, _ string, attrs map[string]string) (context.Context, func(error)) {
+	if attrs["step.cached"] == "true" {
+		return goCtx, func(error) {}
+	}
+	goCtx, span := t.t.Start(goCtx, SpanName, trace.WithAttributes(
+		attribute.String("workflow.id", attrs["workflow.id"]),
+		attribute.String("step.key", attrs["step.key"]),
+		attribute.String("step.run_id", attrs["step.run_id"]),
+		attribute.Bool("step.cached", false),
+	))
+	return goCtx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
@@ -0,0 +1,67 @@
+package otel
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"durableexec/engine"
+)
+
+func TestTracerRecordsOnlyExecutedSteps(t *testing.T) {
+	store, err := engine.NewStore(filepath.Join(t.TempDir(), "otel.db"))
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	run := func() {
+		ctx := WithTracer(engine.NewContext("wf-otel", store), tp)
+		if _, err := engine.Step(ctx, "fetch", func() (int, error) { return 1, nil }); err != nil {
+			t.Fatalf("fetch: %v", err)
+		}
+		if _, err := engine.Step(ctx, "publish", func() (int, error) { return 0, errors.New("broker down") }); err == nil {
+			t.Fatalf("expected publish to fail")
+		}
+	}
+	run()
+	run()
+
+	spans := recorder.Ended()
+	// fetch once, publish on both runs; the replayed fetch is not recorded.
+	if len(spans) != 3 {
+		t.Fatalf("expected 3 spans, got %d", len(spans))
+	}
+	first := spans[0]
+	if first.Name() != SpanName {
+		t.Fatalf("unexpected span name %q", first.Name())
+	}
+	want := map[attribute.Key]attribute.Value{
+		"workflow.id": attribute.StringValue("wf-otel"),
+		"step.key":    attribute.StringValue("fetch#000001"),
+		"step.cached": attribute.BoolValue(false),
+	}
+	got := map[attribute.Key]attribute.Value{}
+	for _, kv := range first.Attributes() {
+		got[kv.Key] = kv.Value
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Fatalf("attribute %s: expected %v, got %v", k, v.Emit(), got[k].Emit())
+		}
+	}
+	if got["step.run_id"].AsString() == "" {
+		t.Fatalf("expected step.run_id attribute")
+	}
+	if spans[1].Status().Code != codes.Error {
+		t.Fatalf("expected failed step span to carry an error status")
+	}
+}
@@ -0,0 +1,317 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+
+	"durableexec/internal/errgroup"
+)
+
+// WhenAll2 runs two independently checkpointed steps concurrently. Step keys
+// are allocated before fan-out so replays map to the same rows. The first
+// failure cancels the other step if it has not been claimed yet; a step
+// function that is already running cannot be interrupted and is checkpointed
+// as usual. The first failure is returned once both steps have settled.
+func WhenAll2[A, B any](ctx *Context, idA, idB string, fnA func() (A, error), fnB func() (B, error)) (A, B, error) {
+	var (
+		a A
+		b B
+	)
+	if err := checkStepArgs(ctx, fnA == nil || fnB == nil); err != nil {
+		return a, b, err
+	}
+
+	refA := ctx.nextStepRef(idA)
+	refB := ctx.nextStepRef(idB)
+
+	f := newFanOut(ctx)
+	goFanOut(f, refA, fnA, &a)
+	goFanOut(f, refB, fnB, &b)
+	if err := f.wait(); err != nil {
+		var (
+			zeroA A
+			zeroB B
+		)
+		return zeroA, zeroB, err
+	}
+	return a, b, nil
+}
+
+// WhenAll3 is WhenAll2 for three steps.
+func WhenAll3[A, B, C any](ctx *Context, idA, idB, idC string, fnA func() (A, error), fnB func() (B, error), fnC func() (C, error)) (A, B, C, error) {
+	var (
+		a A
+		b B
+		c C
+	)
+	if err := checkStepArgs(ctx, fnA == nil || fnB == nil || fnC == nil); err != nil {
+		return a, b, c, err
+	}
+
+	refA := ctx.nextStepRef(idA)
+	refB := ctx.nextStepRef(idB)
+	refC := ctx.nextStepRef(idC)
+
+	f := newFanOut(ctx)
+	goFanOut(f, refA, fnA, &a)
+	goFanOut(f, refB, fnB, &b)
+	goFanOut(f, refC, fnC, &c)
+	if err := f.wait(); err != nil {
+		var (
+			zeroA A
+			zeroB B
+			zeroC C
+		)
+		return zeroA, zeroB, zeroC, err
+	}
+	return a, b, c, nil
+}
+
+// fanOut runs steps concurrently under a context that the first failing step
+// function cancels, so siblings that have not been claimed yet are skipped.
+type fanOut struct {
+	ctx    *Context
+	goCtx  context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	err     error
+	skipped error
+}
+
+func newFanOut(ctx *Context) *fanOut {
+	goCtx, cancel := context.WithCancel(ctx.GoContext())
+	return &fanOut{ctx: ctx, goCtx: goCtx, cancel: cancel}
+}
+
+// goFanOut starts ref as a step of f and stores its result in out.
+func goFanOut[T any](f *fanOut, ref StepRef, fn func() (T, error), out *T) {
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		skipped := false
+		v, err := fanOutStep(f, ref, fn, &skipped)
+		if err != nil {
+			f.record(err, skipped)
+			return
+		}
+		*out = v
+	}()
+}
+
+func fanOutStep[T any](f *fanOut, ref StepRef, fn func() (T, error), skipped *bool) (_ T, err error) {
+	var zero T
+	ctx := f.ctx
+
+	end := ctx.notifyBeforeStep(ref)
+	defer func() { end(err) }()
+
+	if cause := f.goCtx.Err(); cause != nil {
+		*skipped = true
+		return zero, fmt.Errorf("step %s not started: %w", ref.StepKey, cause)
+	}
+	claim, cached, err := ctx.claimStep(ref)
+	if err != nil {
+		return zero, err
+	}
+	if claim == claimCached {
+		return decodeCached[T](ctx, ref, cached)
+	}
+	return runClaimed(ctx, ref, func() (T, error) {
+		v, err := fn()
+		if err != nil {
+			// Cancel before the failure is checkpointed, so any sibling
+			// that sees this step finished also sees the cancellation.
+			f.cancel()
+		}
+		return v, err
+	})
+}
+
+// record keeps the first failure, preferring a real one over the skips it
+// caused.
+func (f *fanOut) record(err error, skipped bool) {
+	f.cancel()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case skipped && f.skipped == nil:
+		f.skipped = err
+	case !skipped && f.err == nil:
+		f.err = err
+	}
+}
+
+func (f *fanOut) wait() error {
+	f.wg.Wait()
+	f.cancel()
+	if f.err != nil {
+		return f.err
+	}
+	return f.skipped
+}
+
+// StepMap runs fn concurrently for each distinct key, checkpointing every
+// result as its own step under groupID#key (sanitised like any step id), and
+// returns the results keyed by input key. Keys already completed by an
+// earlier run are replayed without calling fn. Steps are numbered in order of
+// their sanitised ids, so the order of keys does not matter, and two keys
+// that sanitise to the same id are rejected. Each fn gets its own
+// ctx.Fork(groupID#key), so steps it runs are numbered independently of how
+// the goroutines are scheduled.
+func StepMap[K comparable, V any](ctx *Context, groupID string, keys []K, fn func(*Context, K) (V, error)) (map[K]V, error) {
+	if err := checkStepArgs(ctx, fn == nil); err != nil {
+		return nil, err
+	}
+
+	type mapKey struct {
+		key    K
+		name   string
+		stepID string
+	}
+	seen := make(map[K]struct{}, len(keys))
+	byStepID := make(map[string]K, len(keys))
+	unique := make([]mapKey, 0, len(keys))
+	for _, k := range keys {
+		if _, dup := seen[k]; dup {
+			continue
+		}
+		seen[k] = struct{}{}
+		name := groupID + "#" + fmt.Sprint(k)
+		stepID := resolveStepID(name)
+		if other, clash := byStepID[stepID]; clash {
+			return nil, fmt.Errorf("step map %s: keys %v and %v both map to step id %q", groupID, other, k, stepID)
+		}
+		byStepID[stepID] = k
+		unique = append(unique, mapKey{key: k, name: name, stepID: stepID})
+	}
+	sort.Slice(unique, func(i, j int) bool { return unique[i].stepID < unique[j].stepID })
+
+	refs := make([]StepRef, len(unique))
+	children := make([]*Context, len(unique))
+	for i, k := range unique {
+		refs[i] = ctx.nextStepRef(k.name)
+		children[i] = ctx.Fork(k.name)
+	}
+
+	values := make([]V, len(unique))
+	var g errgroup.Group
+	for i, k := range unique {
+		g.Go(func() error {
+			v, err := stepWithRef(ctx, refs[i], func() (V, error) { return fn(children[i], k.key) })
+			values[i] = v
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	out := make(map[K]V, len(unique))
+	for i, k := range unique {
+		out[k.key] = values[i]
+	}
+	return out, nil
+}
+
+// StepGroupWithCancel runs fn for every input concurrently, each as a durable
+// step named groupID, and returns the outputs in input order. The context
+// handed to fn is cancelled as soon as any step fails, so in-flight work can
+// stop early; steps that had not started yet are skipped. The first real
+// failure is returned rather than the cancellations it caused.
+func StepGroupWithCancel[I, O any](ctx *Context, goCtx context.Context, groupID string, inputs []I, fn func(context.Context, I) (O, error)) ([]O, error) {
+	if err := checkStepArgs(ctx, fn == nil); err != nil {
+		return nil, err
+	}
+	if goCtx == nil {
+		goCtx = context.Background()
+	}
+
+	refs := make([]StepRef, len(inputs))
+	for i := range inputs {
+		refs[i] = ctx.nextStepRef(groupID)
+	}
+
+	groupCtx, cancel := context.WithCancel(goCtx)
+	defer cancel()
+
+	var (
+		outputs  = make([]O, len(inputs))
+		firstErr error
+		errOnce  sync.Once
+		wg       sync.WaitGroup
+	)
+	for i, input := range inputs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if groupCtx.Err() != nil {
+				return
+			}
+			out, err := stepWithRef(ctx, refs[i], func() (O, error) { return fn(groupCtx, input) })
+			if err != nil {
+				errOnce.Do(func() {
+					firstErr = err
+					cancel()
+				})
+				return
+			}
+			outputs[i] = out
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := goCtx.Err(); err != nil {
+		return nil, err
+	}
+	return outputs, nil
+}
+
+// ParallelMap runs fn for every input as its own durable step named
+// id_<index>, on min(runtime.NumCPU(), len(inputs)) workers, and returns the
+// outputs in input order. Every element runs even if others fail, so successful ones
+// are checkpointed and replayed on the next run; the failures are joined.
+func ParallelMap[In, Out any](ctx *Context, id string, inputs []In, fn func(*Context, In) (Out, error)) ([]Out, error) {
+	if err := checkStepArgs(ctx, fn == nil); err != nil {
+		return nil, err
+	}
+
+	refs := make([]StepRef, len(inputs))
+	for i := range inputs {
+		refs[i] = ctx.nextStepRef(fmt.Sprintf("%s_%d", id, i))
+	}
+
+	var (
+		outputs = make([]Out, len(inputs))
+		errs    = make([]error, len(inputs))
+		next    = make(chan int)
+		wg      sync.WaitGroup
+	)
+	for range min(runtime.NumCPU(), len(inputs)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				outputs[i], errs[i] = stepWithRef(ctx, refs[i], func() (Out, error) { return fn(ctx, inputs[i]) })
+			}
+		}()
+	}
+	for i := range inputs {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return outputs, nil
+}
@@ -0,0 +1,90 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Pipeline2 runs stepAB on inputA as one durable step.
+func Pipeline2[A, B any](ctx *Context, idAB string, inputA A, stepAB func(A) (B, error)) (B, error) {
+	if err := checkStepArgs(ctx, stepAB == nil); err != nil {
+		var zero B
+		return zero, err
+	}
+	return stepWithRef(ctx, ctx.nextStepRef(idAB), func() (B, error) { return stepAB(inputA) })
+}
+
+// Pipeline3 chains two durable steps, feeding the checkpointed output of
+// stepAB into stepBC. On replay completed stages are read back instead of
+// re-run, so a failure in stepBC resumes from the stored B.
+func Pipeline3[A, B, C any](ctx *Context, idAB, idBC string, inputA A, stepAB func(A) (B, error), stepBC func(B) (C, error)) (B, C, error) {
+	var (
+		b B
+		c C
+	)
+	if err := checkStepArgs(ctx, stepAB == nil || stepBC == nil); err != nil {
+		return b, c, err
+	}
+
+	b, err := stepWithRef(ctx, ctx.nextStepRef(idAB), func() (B, error) { return stepAB(inputA) })
+	if err != nil {
+		return b, c, err
+	}
+	c, err = stepWithRef(ctx, ctx.nextStepRef(idBC), func() (C, error) { return stepBC(b) })
+	if err != nil {
+		return b, c, err
+	}
+	return b, c, nil
+}
+
+// Pipeline is a linear chain of durable stages turning an In into an Out.
+// Start one with NewPipeline and add stages with Then; Go methods cannot
+// introduce type parameters, so Then is a function rather than a method.
+type Pipeline[In, Out any] struct {
+	ctx *Context
+	run func(In) (Out, error)
+	err error
+}
+
+func NewPipeline[In any](ctx *Context) *Pipeline[In, In] {
+	return &Pipeline[In, In]{ctx: ctx, run: func(in In) (In, error) { return in, nil }}
+}
+
+// Then appends a stage that runs fn as a durable step named id on the
+// previous stage's output. The compiler checks that fn accepts that output.
+func Then[In, Mid, Out any](p *Pipeline[In, Mid], id string, fn func(Mid) (Out, error)) *Pipeline[In, Out] {
+	next := &Pipeline[In, Out]{ctx: p.ctx, err: p.err}
+	switch {
+	case next.err != nil:
+	case strings.TrimSpace(id) == "":
+		next.err = errors.New("pipeline stage id is required")
+	case fn == nil:
+		next.err = fmt.Errorf("pipeline stage %q: function is nil", id)
+	}
+
+	prev := p.run
+	next.run = func(in In) (Out, error) {
+		mid, err := prev(in)
+		if err != nil {
+			var zero Out
+			return zero, err
+		}
+		return Step(p.ctx, id, func() (Out, error) { return fn(mid) })
+	}
+	return next
+}
+
+// Run feeds input through every stage. Stages completed by an earlier run
+// are replayed from their checkpoints, so a crashed pipeline resumes at the
+// first stage that had not finished.
+func (p *Pipeline[In, Out]) Run(input In) (Out, error) {
+	var zero Out
+	if err := checkStepArgs(p.ctx, false); err != nil {
+		return zero, err
+	}
+	if p.err != nil {
+		return zero, p.err
+	}
+	return p.run(input)
+}
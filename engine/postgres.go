@@ -0,0 +1,155 @@
+package engine
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	_ "github.com/lib/pq"
+)
+
+// NewPostgresStore opens dsn with lib/pq and prepares the schema. Only the
+// StoreBackend operations and workflow status rows are portable; the SQLite
+// specific admin and reporting queries on *Store are not exposed.
+func NewPostgresStore(dsn string) (StoreBackend, error) {
+	if strings.TrimSpace(dsn) == "" {
+		return nil, errors.New("postgres dsn is required")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open postgres db: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("connect to postgres: %w", err)
+	}
+
+	s, err := NewStoreWithSQLDriver(db, PostgresDialect{})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	s.ownsDB = true
+	return s, nil
+}
+
+type PostgresDialect struct{}
+
+func (PostgresDialect) Name() string { return "postgres" }
+
+func (PostgresDialect) InitSchemaDDL() string {
+	return `
+CREATE TABLE IF NOT EXISTS steps (
+  workflow_id TEXT NOT NULL,
+  step_key TEXT NOT NULL,
+  step_id TEXT NOT NULL,
+  sequence INTEGER NOT NULL,
+  status TEXT NOT NULL,
+  output_json TEXT,
+  error_text TEXT,
+  run_id TEXT NOT NULL,
+  started_at TEXT NOT NULL,
+  updated_at TEXT NOT NULL,
+  completed_at TEXT,
+  metadata_json TEXT,
+  output_checksum TEXT,
+  PRIMARY KEY (workflow_id, step_key)
+);
+CREATE INDEX IF NOT EXISTS idx_steps_workflow_status ON steps(workflow_id, status);
+CREATE TABLE IF NOT EXISTS workflow_logs (
+  workflow_id TEXT NOT NULL,
+  seq INTEGER NOT NULL,
+  logged_at TEXT NOT NULL,
+  level TEXT NOT NULL,
+  message TEXT NOT NULL,
+  fields_json TEXT,
+  PRIMARY KEY (workflow_id, seq)
+);
+CREATE TABLE IF NOT EXISTS workflows (
+  workflow_id TEXT NOT NULL PRIMARY KEY,
+  run_id TEXT NOT NULL,
+  status TEXT NOT NULL,
+  input_json TEXT,
+  metadata_json TEXT,
+  priority INTEGER NOT NULL DEFAULT 0,
+  created_at TEXT NOT NULL,
+  updated_at TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_workflows_status ON workflows(status);
+CREATE TABLE IF NOT EXISTS step_attempts (
+  workflow_id TEXT NOT NULL,
+  step_key TEXT NOT NULL,
+  attempt INTEGER NOT NULL,
+  run_id TEXT NOT NULL,
+  started_at TEXT NOT NULL,
+  PRIMARY KEY (workflow_id, step_key, attempt)
+);
+CREATE TABLE IF NOT EXISTS schema_migrations (
+  version INTEGER NOT NULL PRIMARY KEY,
+  applied_at TEXT NOT NULL
+);
+`
+}
+
+func (PostgresDialect) GetStepSQL(workflowID, stepKey string) Statement {
+	return SQLiteDialect{}.GetStepSQL(workflowID, stepKey)
+}
+
+// UpsertRunningSQL locks the existing row before claiming it, so pods racing
+// for the same step take turns and each sees the other's committed claim.
+// The lock only holds inside a transaction, which the caller opens. The
+// claim is conditional: a completed step is left untouched, and a step
+// running under another run is only taken over once it was last updated at
+// or before staleBefore, so of two pods racing for a live step only the
+// first wins. The attempt is recorded only if this run now owns the step;
+// Postgres has no changes().
+func (PostgresDialect) UpsertRunningSQL(workflowID string, ref StepRef, runID, now, staleBefore string) []Statement {
+	return []Statement{
+		{Query: "SELECT step_key FROM steps WHERE workflow_id=$1 AND step_key=$2 FOR UPDATE;", Args: []any{workflowID, ref.StepKey}},
+		stepHistorySnapshotSQL(workflowID, ref.StepKey, now),
+		{Query: `
+INSERT INTO steps(workflow_id, step_key, step_id, sequence, status, output_json, error_text, run_id, started_at, updated_at, attempt_count, retry_base)
+VALUES($1, $2, $3, $4, $5, NULL, NULL, $6, $7, $7, 1, (` + stepAttemptCountSQL + `))
+ON CONFLICT(workflow_id, step_key) DO UPDATE SET
+  status=$5,
+  output_json=NULL,
+  error_text=NULL,
+  completed_at=NULL,
+  metadata_json=NULL,
+  output_checksum=NULL,
+  attempt_count=COALESCE(steps.attempt_count, 0) + 1,
+  retry_base=CASE WHEN steps.status=$9 THEN (` + stepAttemptCountSQL + `) ELSE steps.retry_base END,
+  run_id=excluded.run_id,
+  started_at=excluded.started_at,
+  updated_at=excluded.updated_at
+WHERE steps.status <> $8
+  AND (steps.status <> $5 OR steps.run_id = $6 OR steps.updated_at::timestamptz <= $10::timestamptz);`,
+			Args:  []any{workflowID, ref.StepKey, ref.StepID, ref.Sequence, statusRunning, runID, now, statusCompleted, statusFailed, staleBefore},
+			Claim: true,
+		},
+		{Query: `
+INSERT INTO step_attempts(workflow_id, step_key, attempt, run_id, started_at)
+SELECT $1, $2, next_attempt, $3, $4
+FROM (SELECT COALESCE(MAX(attempt), 0) + 1 AS next_attempt FROM step_attempts WHERE workflow_id=$1 AND step_key=$2) a
+WHERE EXISTS (SELECT 1 FROM steps WHERE workflow_id=$1 AND step_key=$2 AND run_id=$3 AND status=$5 AND started_at=$4);`,
+			Args: []any{workflowID, ref.StepKey, runID, now, statusRunning},
+		},
+	}
+}
+
+func (PostgresDialect) MarkCompletedSQL(workflowID, stepKey, runID, outputJSON, now string) Statement {
+	return SQLiteDialect{}.MarkCompletedSQL(workflowID, stepKey, runID, outputJSON, now)
+}
+
+func (PostgresDialect) MarkFailedSQL(workflowID, stepKey, runID, errText, now string) Statement {
+	return SQLiteDialect{}.MarkFailedSQL(workflowID, stepKey, runID, errText, now)
+}
+
+func (PostgresDialect) SetStepMetadataSQL(workflowID, stepKey, metadataJSON string) Statement {
+	return SQLiteDialect{}.SetStepMetadataSQL(workflowID, stepKey, metadataJSON)
+}
+
+func (PostgresDialect) ListStepsSQL(workflowID string) Statement {
+	return SQLiteDialect{}.ListStepsSQL(workflowID)
+}
@@ -0,0 +1,124 @@
+//go:build integration
+
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newPostgresTestStore(t *testing.T) StoreBackend {
+	t.Helper()
+	dsn := os.Getenv("POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_DSN not set")
+	}
+	store, err := NewPostgresStore(dsn)
+	if err != nil {
+		t.Fatalf("new postgres store failed: %v", err)
+	}
+	return store
+}
+
+func TestPostgresStepReplaysFromCheckpoint(t *testing.T) {
+	store := newPostgresTestStore(t)
+	workflowID := fmt.Sprintf("wf-pg-replay-%d", time.Now().UnixNano())
+
+	calls := 0
+	for i := 0; i < 2; i++ {
+		err := RunWorkflow(store, workflowID, func(ctx *Context) error {
+			v, err := Step(ctx, "charge", func() (int, error) { calls++; return 42, nil })
+			if err == nil && v != 42 {
+				err = fmt.Errorf("unexpected value %d", v)
+			}
+			return err
+		})
+		if err != nil {
+			t.Fatalf("run %d failed: %v", i, err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("expected one execution, got %d", calls)
+	}
+}
+
+func TestPostgresConcurrentClaimsKeepCompletedStep(t *testing.T) {
+	store := newPostgresTestStore(t)
+	workflowID := fmt.Sprintf("wf-pg-race-%d", time.Now().UnixNano())
+
+	var completed int64
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx := NewContext(workflowID, store)
+			if _, err := Step(ctx, "ship", func() (string, error) { return "parcel", nil }); err == nil {
+				atomic.AddInt64(&completed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if completed == 0 {
+		t.Fatalf("expected at least one pod to complete the step")
+	}
+
+	record, found, err := store.GetStep(workflowID, "ship#000001")
+	if err != nil || !found {
+		t.Fatalf("get failed: found=%v err=%v", found, err)
+	}
+	if record.Status != statusCompleted || record.OutputJSON != `"parcel"` {
+		t.Fatalf("unexpected final state: %+v", record)
+	}
+}
+
+func TestPostgresClaimHasOneWinner(t *testing.T) {
+	workflowID := fmt.Sprintf("wf-pg-claim-%d", time.Now().UnixNano())
+	// Two stores, so the claims race on separate connections.
+	stores := []StoreBackend{newPostgresTestStore(t), newPostgresTestStore(t)}
+	ref := StepRef{StepID: "charge", Sequence: 1, StepKey: "charge#000001"}
+
+	errs := make([]error, len(stores))
+	runIDs := make([]string, len(stores))
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i, store := range stores {
+		ctx := NewContext(workflowID, store).WithZombieTimeout(time.Hour)
+		runIDs[i] = ctx.RunID
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			errs[i] = ctx.claimRunning(ref)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	winner := -1
+	for i, err := range errs {
+		switch {
+		case err == nil && winner < 0:
+			winner = i
+		case err == nil:
+			t.Fatalf("both claims succeeded")
+		case !errors.Is(err, ErrStepStillRunning):
+			t.Fatalf("claim %d failed unexpectedly: %v", i, err)
+		}
+	}
+	if winner < 0 {
+		t.Fatalf("expected one claim to win, got %v", errs)
+	}
+	record, found, err := stores[0].GetStep(workflowID, ref.StepKey)
+	if err != nil || !found {
+		t.Fatalf("get failed: found=%v err=%v", found, err)
+	}
+	if record.RunID != runIDs[winner] || record.AttemptCount != 1 {
+		t.Fatalf("expected the winner to own the step with one attempt, got %+v", record)
+	}
+}
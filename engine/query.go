@@ -0,0 +1,180 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// StepFilter narrows GetStepsMatching. Zero-valued fields are ignored.
+// CreatedAfter and CreatedBefore compare against started_at.
+type StepFilter struct {
+	Status        string
+	StepIDPrefix  string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	RunID         string
+	Limit         int
+	Offset        int
+}
+
+func (s *Store) GetStepsMatching(workflowID string, f StepFilter) ([]StepRecord, error) {
+	var args sqlArgs
+	conds := []string{"workflow_id=" + args.add(workflowID)}
+	if f.Status != "" {
+		conds = append(conds, "status="+args.add(f.Status))
+	}
+	if f.StepIDPrefix != "" {
+		conds = append(conds, fmt.Sprintf("substr(step_id, 1, %d)=%s", utf8.RuneCountInString(f.StepIDPrefix), args.add(f.StepIDPrefix)))
+	}
+	if !f.CreatedAfter.IsZero() {
+		conds = append(conds, "julianday(started_at) > julianday("+args.add(sqlTime(f.CreatedAfter))+")")
+	}
+	if !f.CreatedBefore.IsZero() {
+		conds = append(conds, "julianday(started_at) < julianday("+args.add(sqlTime(f.CreatedBefore))+")")
+	}
+	if f.RunID != "" {
+		conds = append(conds, "run_id="+args.add(f.RunID))
+	}
+
+	var b strings.Builder
+	b.WriteString("\nSELECT " + stepColumns + "\nFROM steps\nWHERE " + strings.Join(conds, " AND ") + "\nORDER BY step_key")
+	switch {
+	case f.Limit > 0:
+		b.WriteString("\nLIMIT " + args.add(f.Limit) + " OFFSET " + args.add(max(f.Offset, 0)))
+	case f.Offset > 0:
+		b.WriteString("\nLIMIT -1 OFFSET " + args.add(f.Offset))
+	}
+	b.WriteString(";")
+
+	return s.queryStepRecords(b.String(), args...)
+}
+
+const outputPageSize = 200
+
+// AggregateStepOutputs folds the output_json of every completed stepID
+// checkpoint into seed, in sequence order. Outputs are read a page at a time
+// so long loops do not have to fit in memory.
+func (s *Store) AggregateStepOutputs(workflowID, stepID string, seed []byte, reducer func(acc, item []byte) ([]byte, error)) ([]byte, error) {
+	if reducer == nil {
+		return nil, fmt.Errorf("reducer is nil")
+	}
+
+	acc := seed
+	err := s.forEachStepOutput(workflowID, stepID, func(record StepRecord) error {
+		if err := jsonOutput(record); err != nil {
+			return err
+		}
+		var err error
+		acc, err = reducer(acc, []byte(record.OutputJSON))
+		if err != nil {
+			return fmt.Errorf("reduce output of %s: %w", record.StepKey, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return acc, nil
+}
+
+// StreamStepOutputs calls fn with the raw output of every completed stepID
+// checkpoint in sequence order, stopping at the first error fn returns.
+// Outputs written with a non-JSON codec fail with ErrCodecMismatch; use
+// Reduce to read those through a Context.
+func (s *Store) StreamStepOutputs(workflowID, stepID string, fn func(seq int, raw json.RawMessage) error) error {
+	if fn == nil {
+		return fmt.Errorf("stream callback is nil")
+	}
+	return s.forEachStepOutput(workflowID, stepID, func(record StepRecord) error {
+		if err := jsonOutput(record); err != nil {
+			return err
+		}
+		return fn(record.Sequence, json.RawMessage(record.OutputJSON))
+	})
+}
+
+// forEachStepOutput pages through completed stepID checkpoints by sequence.
+// Records carry only the sequence, key, output and metadata.
+func (s *Store) forEachStepOutput(workflowID, stepID string, fn func(StepRecord) error) error {
+	after := 0
+	for {
+		rows, err := s.queryRows(`
+SELECT sequence, step_key, output_json, metadata_json
+FROM steps
+WHERE workflow_id=$1 AND step_id=$2 AND status=$3 AND sequence > $4
+ORDER BY sequence
+LIMIT $5;`,
+			workflowID,
+			stepID,
+			statusCompleted,
+			after,
+			outputPageSize,
+		)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			record := StepRecord{
+				Sequence:     asInt(row["sequence"]),
+				StepKey:      asString(row["step_key"]),
+				OutputJSON:   asString(row["output_json"]),
+				MetadataJSON: asString(row["metadata_json"]),
+			}
+			if err := fn(record); err != nil {
+				return err
+			}
+		}
+		if len(rows) < outputPageSize {
+			return nil
+		}
+		after = asInt(rows[len(rows)-1]["sequence"])
+	}
+}
+
+// GetStepTiming returns when a step started and, if it has, when it completed.
+// completedAt is the zero time for steps that have not completed.
+func (s *Store) GetStepTiming(workflowID, stepKey string) (startedAt, completedAt time.Time, found bool, err error) {
+	rows, err := s.queryRows(`
+SELECT started_at, completed_at
+FROM steps
+WHERE workflow_id=$1 AND step_key=$2
+LIMIT 1;`, workflowID, stepKey)
+	if err != nil {
+		return time.Time{}, time.Time{}, false, err
+	}
+	if len(rows) == 0 {
+		return time.Time{}, time.Time{}, false, nil
+	}
+
+	startedAt, err = time.Parse(time.RFC3339Nano, asString(rows[0]["started_at"]))
+	if err != nil {
+		return time.Time{}, time.Time{}, true, fmt.Errorf("parse started_at for %s: %w", stepKey, err)
+	}
+	if raw := asString(rows[0]["completed_at"]); raw != "" {
+		completedAt, err = time.Parse(time.RFC3339Nano, raw)
+		if err != nil {
+			return time.Time{}, time.Time{}, true, fmt.Errorf("parse completed_at for %s: %w", stepKey, err)
+		}
+	}
+	return startedAt, completedAt, true, nil
+}
+
+// ListDistinctStepIDs returns the step ids used by the workflow, sorted.
+func (s *Store) ListDistinctStepIDs(workflowID string) ([]string, error) {
+	rows, err := s.queryRows(`
+SELECT DISTINCT step_id
+FROM steps
+WHERE workflow_id=$1
+ORDER BY step_id;`, workflowID)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(rows))
+	for _, row := range rows {
+		ids = append(ids, asString(row["step_id"]))
+	}
+	return ids, nil
+}
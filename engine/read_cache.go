@@ -0,0 +1,97 @@
+package engine
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+)
+
+// WithReadCache keeps up to maxEntries completed steps in memory so replaying
+// them does not query the database. Completed outputs only change through
+// this Store's repair methods, which invalidate the cache; other processes
+// that reset steps in the same database are not seen.
+func WithReadCache(maxEntries int) StoreOption {
+	return func(o *storeOptions) error {
+		if maxEntries < 1 {
+			return fmt.Errorf("read cache size must be at least 1, got %d", maxEntries)
+		}
+		o.readCacheSize = maxEntries
+		return nil
+	}
+}
+
+// readCache is an LRU of completed step records. A nil *readCache caches
+// nothing, so callers need not check whether the option is set.
+type readCache struct {
+	mu      sync.Mutex
+	max     int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+func newReadCache(max int) *readCache {
+	return &readCache{max: max, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func readCacheKey(workflowID, stepKey string) string {
+	return workflowID + "/" + stepKey
+}
+
+func (c *readCache) get(workflowID, stepKey string) (StepRecord, bool) {
+	if c == nil {
+		return StepRecord{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[readCacheKey(workflowID, stepKey)]
+	if !ok {
+		return StepRecord{}, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(StepRecord), true
+}
+
+func (c *readCache) put(record StepRecord) {
+	if c == nil || record.Status != statusCompleted {
+		return
+	}
+	key := readCacheKey(record.WorkflowID, record.StepKey)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		el.Value = record
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.order.PushFront(record)
+	if c.order.Len() > c.max {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		r := oldest.Value.(StepRecord)
+		delete(c.entries, readCacheKey(r.WorkflowID, r.StepKey))
+	}
+}
+
+func (c *readCache) remove(workflowID, stepKey string) {
+	if c == nil {
+		return
+	}
+	key := readCacheKey(workflowID, stepKey)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.order.Remove(el)
+		delete(c.entries, key)
+	}
+}
+
+// clear drops everything, for writes that touch an unknown set of steps.
+func (c *readCache) clear() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+}
@@ -0,0 +1,68 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// RetryPolicy controls Retry. The delay before attempt n+1 is
+// InitialBackoff*Multiplier^(n-1), capped at MaxBackoff when it is set.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+}
+
+// RetryableError marks an error as transient. Retry only retries errors that
+// wrap one; anything else is returned immediately.
+type RetryableError struct {
+	Err error
+}
+
+func (e *RetryableError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryableError) Unwrap() error {
+	return e.Err
+}
+
+// Retry calls fn until it succeeds, returns a non-retryable error, or the
+// policy runs out of attempts. It writes nothing to the store, so it is meant
+// for idempotent calls made inside a single step.
+func Retry(policy RetryPolicy, fn func() error) error {
+	if fn == nil {
+		return errors.New("retry function is nil")
+	}
+	attempts := policy.MaxAttempts
+	if attempts <= 0 {
+		attempts = 1
+	}
+	multiplier := policy.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+
+	backoff := policy.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		var retryable *RetryableError
+		if !errors.As(err, &retryable) {
+			return err
+		}
+		if attempt >= attempts {
+			return fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+
+		time.Sleep(backoff)
+		backoff = time.Duration(float64(backoff) * multiplier)
+		if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+}
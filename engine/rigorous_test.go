@@ -78,7 +78,7 @@ func TestRandomizedResumeProducesDeterministicOutputs(t *testing.T) {
 }
 
 func TestHighContentionManyWorkflowsParallel(t *testing.T) {
-	store := newTestStore(t)
+	store := newSQLiteTestStore(t)
 	const (
 		workflowCount = 20
 		stepsPerWF    = 18
@@ -145,7 +145,7 @@ func TestHighContentionManyWorkflowsParallel(t *testing.T) {
 }
 
 func TestCorruptedCachedOutputFailsFast(t *testing.T) {
-	store := newTestStore(t)
+	store := newSQLiteTestStore(t)
 	workflowID := "wf-corrupt-cache"
 
 	ctx1 := NewContext(workflowID, store)
@@ -153,9 +153,10 @@ func TestCorruptedCachedOutputFailsFast(t *testing.T) {
 		t.Fatalf("seed step failed: %v", err)
 	}
 
+	// Clear the checksum as on a legacy row so decoding is what fails.
 	if err := store.execWrite(`
 UPDATE steps
-SET output_json='not-json'
+SET output_json='not-json', output_checksum=NULL
 WHERE workflow_id='wf-corrupt-cache' AND step_key='create_record#000001';`); err != nil {
 		t.Fatalf("failed to corrupt row: %v", err)
 	}
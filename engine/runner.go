@@ -1,11 +1,34 @@
 package engine
 
-import "fmt"
+import (
+	"context"
+	"errors"
+	"fmt"
+)
 
 type WorkflowFunc func(ctx *Context) error
 
-func RunWorkflow(store *Store, workflowID string, fn WorkflowFunc) error {
-	if store == nil {
+// RunWorkflow runs fn under a fresh Context. Backends that track workflow
+// rows, such as *Store, also get the workflow's running and final status,
+// and the Context starts with the metadata earlier runs attached.
+func RunWorkflow(store StoreBackend, workflowID string, fn WorkflowFunc) error {
+	return runWorkflow(nil, store, workflowID, fn)
+}
+
+// RunWorkflowWithContext is RunWorkflow with cancellation: once goCtx is done
+// the Context passed to fn is cancelled, so later steps fail with goCtx's
+// error, context.Canceled or context.DeadlineExceeded. fn's error is returned
+// as is, and a workflow that fails after cancellation is recorded as
+// cancelled rather than failed.
+func RunWorkflowWithContext(goCtx context.Context, store StoreBackend, workflowID string, fn WorkflowFunc) error {
+	if goCtx == nil {
+		return fmt.Errorf("nil context")
+	}
+	return runWorkflow(goCtx, store, workflowID, fn)
+}
+
+func runWorkflow(goCtx context.Context, store StoreBackend, workflowID string, fn WorkflowFunc) error {
+	if isNilBackend(store) {
 		return fmt.Errorf("nil store")
 	}
 	if workflowID == "" {
@@ -15,6 +38,37 @@ func RunWorkflow(store *Store, workflowID string, fn WorkflowFunc) error {
 		return fmt.Errorf("workflow function is nil")
 	}
 
-	ctx := NewContext(workflowID, store)
-	return fn(ctx)
+	var ctx *Context
+	if goCtx == nil {
+		ctx = NewContext(workflowID, store)
+	} else {
+		var cancel context.CancelFunc
+		ctx, cancel = newContextWithParent(goCtx, workflowID, store)
+		defer cancel()
+	}
+
+	statuses, tracked := store.(workflowStatusStore)
+	if tracked {
+		if err := statuses.MarkWorkflowRunning(workflowID, ctx.RunID); err != nil {
+			return fmt.Errorf("record workflow start: %w", err)
+		}
+	}
+	if err := ctx.loadMetadata(); err != nil {
+		return fmt.Errorf("load workflow metadata: %w", err)
+	}
+
+	runErr := fn(ctx)
+	status := statusCompleted
+	switch {
+	case runErr != nil && ctx.GoContext().Err() != nil && errors.Is(runErr, ctx.GoContext().Err()):
+		status = statusCancelled
+	case runErr != nil:
+		status = statusFailed
+	}
+	if tracked {
+		if err := statuses.MarkWorkflowStatus(workflowID, ctx.RunID, status); err != nil && runErr == nil {
+			return fmt.Errorf("record workflow completion: %w", err)
+		}
+	}
+	return runErr
 }
@@ -0,0 +1,137 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Saga runs a sequence of forward steps and, if one fails, undoes the ones
+// that completed. Build it with NewSaga and AddSagaStep, then call Execute.
+type Saga struct {
+	ctx   *Context
+	steps []sagaStep
+	err   error
+}
+
+type sagaStep struct {
+	id  string
+	run func() (undo func() error, err error)
+}
+
+// sagaRollback is checkpointed before the first compensation runs. Failed is
+// the index of the forward step that failed and Error its error text.
+type sagaRollback struct {
+	Failed int    `json:"failed"`
+	Error  string `json:"error"`
+}
+
+func NewSaga(ctx *Context) *Saga {
+	return &Saga{ctx: ctx}
+}
+
+// AddSagaStep appends forward as a durable step named id. If a later step
+// fails, compensate runs as the durable step id + "_compensate" and receives
+// forward's checkpointed output, so a rollback interrupted by a crash resumes
+// with the same value. AddSagaStep is a function rather than a method because
+// Go methods cannot introduce type parameters.
+func AddSagaStep[T any](s *Saga, id string, forward func() (T, error), compensate func(T) error) *Saga {
+	switch {
+	case s.err != nil:
+		return s
+	case strings.TrimSpace(id) == "":
+		s.err = errors.New("saga step id is required")
+		return s
+	case forward == nil:
+		s.err = fmt.Errorf("saga step %q: forward function is nil", id)
+		return s
+	case compensate == nil:
+		s.err = fmt.Errorf("saga step %q: compensation is nil", id)
+		return s
+	}
+
+	s.steps = append(s.steps, sagaStep{id: id, run: func() (func() error, error) {
+		out, err := Step(s.ctx, id, forward)
+		if err != nil {
+			return nil, err
+		}
+		return func() error {
+			return SideEffect(s.ctx, id+"_compensate", func() error { return compensate(out) })
+		}, nil
+	}})
+	return s
+}
+
+// Execute runs the steps in order. On the first failure the compensations of
+// the completed steps run in reverse order; every one is attempted and the
+// returned error joins the step failure with any compensation errors.
+//
+// Before compensating, Execute checkpoints that the saga is rolling back. A
+// replay that finds that checkpoint does not retry the failed step: it
+// replays the completed steps for their outputs and resumes compensating,
+// returning the original failure as text.
+func (s *Saga) Execute() error {
+	if err := checkStepArgs(s.ctx, false); err != nil {
+		return err
+	}
+	if s.err != nil {
+		return s.err
+	}
+
+	markerRef := s.ctx.nextStepRef("saga_rollback")
+	rollback, found, err := s.loadRollback(markerRef)
+	if err != nil {
+		return err
+	}
+
+	undos := make([]func() error, 0, len(s.steps))
+	for i, step := range s.steps {
+		if found && i == rollback.Failed {
+			// Keep the step's sequence where the original run left it.
+			s.ctx.nextStepRef(step.id)
+			return s.compensate(undos, errors.New(rollback.Error))
+		}
+		undo, err := step.run()
+		if err == nil {
+			undos = append(undos, undo)
+			continue
+		}
+		marker := sagaRollback{Failed: i, Error: err.Error()}
+		if _, merr := stepWithRef(s.ctx, markerRef, func() (sagaRollback, error) { return marker, nil }); merr != nil {
+			return errors.Join(err, fmt.Errorf("record saga rollback: %w", merr))
+		}
+		return s.compensate(undos, err)
+	}
+	if found {
+		return fmt.Errorf("saga rollback marker names step %d of %d", rollback.Failed, len(s.steps))
+	}
+	return nil
+}
+
+// loadRollback reports whether an earlier run recorded that the saga is
+// rolling back.
+func (s *Saga) loadRollback(ref StepRef) (sagaRollback, bool, error) {
+	record, found, err := s.ctx.loadStep(ref.StepKey)
+	if err != nil {
+		return sagaRollback{}, false, fmt.Errorf("load saga rollback %s: %w", ref.StepKey, err)
+	}
+	if !found || record.Status != statusCompleted {
+		return sagaRollback{}, false, nil
+	}
+	var rollback sagaRollback
+	if err := s.ctx.decodeOutput(record, &rollback); err != nil {
+		return sagaRollback{}, false, fmt.Errorf("decode saga rollback %s: %w", ref.StepKey, err)
+	}
+	return rollback, true, nil
+}
+
+// compensate runs undos in reverse and joins cause with their errors.
+func (s *Saga) compensate(undos []func() error, cause error) error {
+	errs := []error{cause}
+	for i := len(undos) - 1; i >= 0; i-- {
+		if cerr := undos[i](); cerr != nil {
+			errs = append(errs, cerr)
+		}
+	}
+	return errors.Join(errs...)
+}
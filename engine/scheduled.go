@@ -0,0 +1,74 @@
+package engine
+
+import (
+	"fmt"
+	"time"
+)
+
+// ScheduledStep runs fn at the next time cronExpr fires, in local time. The
+// expression uses the same syntax as Scheduler.AddCron. The fire time is
+// checkpointed before waiting, as DurableSleep does with its wake-up time, so
+// a run that resumes after a crash keeps the original schedule and runs at
+// once if it is already past due. The step is only claimed once the wait is
+// over, and cancelling the Context ends the wait. The claimed step records
+// the fire time in its metadata as next_fire_at. Completed steps replay from
+// the checkpoint.
+func ScheduledStep[T any](ctx *Context, id string, cronExpr string, fn func() (T, error)) (_ T, err error) {
+	var zero T
+
+	if err := checkStepArgs(ctx, fn == nil); err != nil {
+		return zero, err
+	}
+	schedule, err := parseCron(cronExpr)
+	if err != nil {
+		return zero, fmt.Errorf("parse cron expression %q: %w", cronExpr, err)
+	}
+
+	ref := ctx.nextStepRef(id)
+	end := ctx.notifyBeforeStep(ref)
+	defer func() { end(err) }()
+
+	prior, found, err := ctx.backend.GetStep(ctx.WorkflowID, ref.StepKey)
+	if err != nil {
+		return zero, fmt.Errorf("load step %s: %w", ref.StepKey, err)
+	}
+	var fireAt time.Time
+	if !found || prior.Status != statusCompleted {
+		key := fmt.Sprintf("%s_fire_%d", ref.StepID, ref.Sequence)
+		raw, found, err := ReadCheckpoint[string](ctx, key)
+		if err != nil {
+			return zero, err
+		}
+		if !found {
+			next := schedule.Next(time.Now())
+			if next.IsZero() {
+				return zero, fmt.Errorf("cron expression %q never fires", cronExpr)
+			}
+			raw = next.UTC().Format(time.RFC3339Nano)
+			if err := Checkpoint(ctx, key, raw); err != nil {
+				return zero, err
+			}
+		}
+		fireAt, err = time.Parse(time.RFC3339Nano, raw)
+		if err != nil {
+			return zero, fmt.Errorf("parse fire time for %s: %w", ref.StepKey, err)
+		}
+		if err := ctx.sleepUntil(ref, fireAt); err != nil {
+			return zero, err
+		}
+	}
+
+	claim, cached, err := ctx.claimStep(ref)
+	if err != nil {
+		return zero, err
+	}
+	if claim == claimCached {
+		return decodeCached[T](ctx, ref, cached)
+	}
+	if !fireAt.IsZero() {
+		if err := ctx.writeStepMetadata(ref, map[string]any{"next_fire_at": fireAt.UTC().Format(time.RFC3339Nano)}); err != nil {
+			return zero, err
+		}
+	}
+	return runClaimed(ctx, ref, fn)
+}
@@ -0,0 +1,155 @@
+package engine
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// scheduleIDFormat renders a trigger's fire time into its workflow id.
+const scheduleIDFormat = "20060102T150405.000Z"
+
+// Scheduler starts workflows from cron expressions and fixed intervals. Each
+// trigger runs RunWorkflow under "<id>-<fire time>". A trigger that fires
+// while its previous run is still running is skipped.
+type Scheduler struct {
+	store StoreBackend
+
+	mu      sync.Mutex
+	entries []*scheduleEntry
+	started bool
+	wg      sync.WaitGroup
+}
+
+type scheduleEntry struct {
+	idPrefix string
+	next     func(time.Time) time.Time
+	fn       WorkflowFunc
+
+	timer   *time.Timer
+	active  bool
+	lastID  string
+	stopped bool
+}
+
+func NewScheduler(store StoreBackend) *Scheduler {
+	return &Scheduler{store: store}
+}
+
+// AddCron triggers fn whenever the standard five-field cron spec fires, in
+// local time. See parseCron for the syntax supported.
+func (s *Scheduler) AddCron(spec string, workflowID string, fn WorkflowFunc) error {
+	if workflowID == "" {
+		return fmt.Errorf("workflow id is required")
+	}
+	if fn == nil {
+		return fmt.Errorf("workflow function is nil")
+	}
+	schedule, err := parseCron(spec)
+	if err != nil {
+		return fmt.Errorf("parse cron expression %q: %w", spec, err)
+	}
+	s.add(&scheduleEntry{idPrefix: workflowID, next: schedule.Next, fn: fn})
+	return nil
+}
+
+// AddInterval triggers fn every d, counting from Start.
+func (s *Scheduler) AddInterval(d time.Duration, workflowIDPrefix string, fn WorkflowFunc) {
+	if d <= 0 || workflowIDPrefix == "" || fn == nil {
+		s.logger().Warn("ignoring invalid interval schedule", "interval", d, "workflow_id_prefix", workflowIDPrefix)
+		return
+	}
+	s.add(&scheduleEntry{idPrefix: workflowIDPrefix, next: func(t time.Time) time.Time { return t.Add(d) }, fn: fn})
+}
+
+func (s *Scheduler) add(e *scheduleEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append(s.entries, e)
+	if s.started {
+		s.arm(e, time.Now())
+	}
+}
+
+// Start arms every schedule. Calling it again while started does nothing.
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return
+	}
+	s.started = true
+	now := time.Now()
+	for _, e := range s.entries {
+		e.stopped = false
+		s.arm(e, now)
+	}
+}
+
+// Stop disarms every schedule and waits for runs already started to return.
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	s.started = false
+	for _, e := range s.entries {
+		e.stopped = true
+		if e.timer != nil {
+			e.timer.Stop()
+			e.timer = nil
+		}
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// arm sets e's timer for its next fire time after from. Callers must hold mu.
+func (s *Scheduler) arm(e *scheduleEntry, from time.Time) {
+	fireAt := e.next(from)
+	if fireAt.IsZero() {
+		return
+	}
+	e.timer = time.AfterFunc(time.Until(fireAt), func() { s.fire(e, fireAt) })
+}
+
+func (s *Scheduler) fire(e *scheduleEntry, fireAt time.Time) {
+	s.mu.Lock()
+	if e.stopped {
+		s.mu.Unlock()
+		return
+	}
+	s.arm(e, fireAt)
+	if e.active || s.stillRunning(e.lastID) {
+		s.mu.Unlock()
+		s.logger().Info("skipping scheduled workflow, previous run still running", "previous_workflow_id", e.lastID)
+		return
+	}
+	workflowID := e.idPrefix + "-" + fireAt.UTC().Format(scheduleIDFormat)
+	e.active = true
+	e.lastID = workflowID
+	s.wg.Add(1)
+	s.mu.Unlock()
+
+	defer s.wg.Done()
+	if err := RunWorkflow(s.store, workflowID, e.fn); err != nil {
+		s.logger().Warn("scheduled workflow failed", "workflow_id", workflowID, "error", err)
+	}
+	s.mu.Lock()
+	e.active = false
+	s.mu.Unlock()
+}
+
+// stillRunning reports whether the workflow row of workflowID is still
+// running, as it stays when a run returns without recording its final status.
+func (s *Scheduler) stillRunning(workflowID string) bool {
+	store, ok := s.store.(*Store)
+	if workflowID == "" || !ok {
+		return false
+	}
+	record, found, err := store.GetWorkflowRecord(workflowID)
+	return err == nil && found && record.Status == statusRunning
+}
+
+func (s *Scheduler) logger() *slog.Logger {
+	store, _ := s.store.(*Store)
+	return store.slogger()
+}
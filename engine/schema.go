@@ -0,0 +1,163 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// currentSchemaVersion is the schema this build creates. Bump it together
+// with any change that older engines cannot read.
+const currentSchemaVersion = 5
+
+var ErrSchemaVersionTooOld = errors.New("database schema version is older than required")
+
+// schemaMigration upgrades the schema to version. statements is evaluated
+// just before the migration runs so it can adapt to the database; its result
+// is applied in one transaction together with the schema_migrations row.
+type schemaMigration struct {
+	version    int
+	statements func(s *Store) ([]string, error)
+}
+
+// schemaMigrations must stay in version order and must never be edited once
+// released; add a new version instead.
+var schemaMigrations = []schemaMigration{
+	{version: 1, statements: initialSchema},
+	{version: 2, statements: addColumn("steps", "attempt_count", "INTEGER NOT NULL DEFAULT 0")},
+	{version: 3, statements: createStepHistory},
+	{version: 4, statements: createWorkflowMetadata},
+	{version: 5, statements: addColumnToTables("retry_base", "INTEGER NOT NULL DEFAULT 0", "steps", "step_history")},
+}
+
+// initialSchema creates the tables. Databases from before schema_migrations
+// existed may have a steps table missing columns that were added later, so
+// those are added here as well.
+func initialSchema(s *Store) ([]string, error) {
+	stmts := []string{s.dialect.InitSchemaDDL()}
+	existing, err := s.columnNames("steps")
+	if err != nil || len(existing) == 0 {
+		// No steps table yet; the DDL creates it complete.
+		return stmts, nil
+	}
+	for _, col := range []string{"completed_at", "metadata_json", "output_checksum"} {
+		if !existing[col] {
+			stmts = append(stmts, fmt.Sprintf("ALTER TABLE steps ADD COLUMN %s TEXT;", col))
+		}
+	}
+	return stmts, nil
+}
+
+// addColumn adds a column unless it is already there, as it is in databases
+// that added it before it had a migration.
+func addColumn(table, column, decl string) func(*Store) ([]string, error) {
+	return func(s *Store) ([]string, error) {
+		existing, err := s.columnNames(table)
+		if err != nil {
+			return nil, err
+		}
+		if existing[column] {
+			return nil, nil
+		}
+		return []string{fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s;", table, column, decl)}, nil
+	}
+}
+
+// addColumnToTables adds column to each table that lacks it.
+func addColumnToTables(column, decl string, tables ...string) func(*Store) ([]string, error) {
+	return func(s *Store) ([]string, error) {
+		var stmts []string
+		for _, table := range tables {
+			add, err := addColumn(table, column, decl)(s)
+			if err != nil {
+				return nil, err
+			}
+			stmts = append(stmts, add...)
+		}
+		return stmts, nil
+	}
+}
+
+// migrate applies every migration newer than the recorded schema version.
+// If another process applies the same migration first, the losing
+// transaction rolls back and the migration is skipped.
+func (s *Store) migrate() error {
+	err := s.execWrite(`
+CREATE TABLE IF NOT EXISTS schema_migrations (
+  version INTEGER NOT NULL PRIMARY KEY,
+  applied_at TEXT NOT NULL
+);`)
+	if err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+	version, err := s.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range schemaMigrations {
+		if m.version <= version {
+			continue
+		}
+		stmts, err := m.statements(s)
+		if err != nil {
+			return fmt.Errorf("prepare schema migration %d: %w", m.version, err)
+		}
+		tx := make([]Statement, 0, len(stmts)+1)
+		for _, ddl := range stmts {
+			tx = append(tx, Statement{Query: ddl})
+		}
+		now := time.Now().UTC().Format(time.RFC3339Nano)
+		tx = append(tx, Statement{Query: "INSERT INTO schema_migrations(version, applied_at) VALUES($1, $2);", Args: []any{m.version, now}})
+		if err := s.execTx(context.Background(), tx); err != nil {
+			if applied, verr := s.SchemaVersion(); verr == nil && applied >= m.version {
+				version = applied
+				continue
+			}
+			return fmt.Errorf("apply schema migration %d: %w", m.version, err)
+		}
+		version = m.version
+	}
+	return nil
+}
+
+// columnNames returns the columns of table, or none if it does not exist.
+func (s *Store) columnNames(table string) (map[string]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rows, err := s.db.Query("SELECT * FROM " + table + " LIMIT 0;")
+	if err != nil {
+		if strings.Contains(err.Error(), "no such table") || strings.Contains(err.Error(), "does not exist") {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("inspect %s columns: %w", table, err)
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("inspect %s columns: %w", table, err)
+	}
+	out := make(map[string]bool, len(cols))
+	for _, col := range cols {
+		out[col] = true
+	}
+	return out, nil
+}
+
+// SchemaVersion returns the highest version recorded in schema_migrations, or
+// 0 for a database that predates the table.
+func (s *Store) SchemaVersion() (int, error) {
+	rows, err := s.queryRows("SELECT COALESCE(MAX(version), 0) AS version FROM schema_migrations;")
+	if err != nil {
+		if strings.Contains(err.Error(), "no such table") {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	return asInt(rows[0]["version"]), nil
+}
@@ -0,0 +1,131 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// AttachShard registers another engine database under name so cross-shard
+// queries can read it. ATTACH is per connection, so registered shards are
+// attached around each cross-shard query rather than once on a pooled
+// connection that may later be replaced.
+func (s *Store) AttachShard(name, path string) error {
+	if !isShardName(name) {
+		return fmt.Errorf("invalid shard name %q", name)
+	}
+	if strings.TrimSpace(path) == "" {
+		return errors.New("shard path is required")
+	}
+	if _, ok := s.dialect.(SQLiteDialect); !ok {
+		return errors.New("shards require the sqlite dialect")
+	}
+
+	s.mu.Lock()
+	_, exists := s.shards[name]
+	s.mu.Unlock()
+	if exists {
+		return fmt.Errorf("shard %q is already attached", name)
+	}
+
+	// Fail now rather than on the first cross-shard query if path is not an
+	// engine database.
+	probe := map[string]string{name: path}
+	if _, err := s.queryShards(probe, "SELECT count(*) AS n FROM "+name+".workflows;"); err != nil {
+		return fmt.Errorf("attach shard %s: %w", name, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shards == nil {
+		s.shards = make(map[string]string)
+	}
+	s.shards[name] = path
+	return nil
+}
+
+func (s *Store) DetachShard(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.shards[name]; !ok {
+		return fmt.Errorf("shard %q is not attached", name)
+	}
+	delete(s.shards, name)
+	return nil
+}
+
+// CrossShardListWorkflows returns the workflows of this store and every
+// attached shard, ordered by workflow id.
+func (s *Store) CrossShardListWorkflows() ([]WorkflowRecord, error) {
+	s.mu.Lock()
+	shards := make(map[string]string, len(s.shards))
+	for name, path := range s.shards {
+		shards[name] = path
+	}
+	s.mu.Unlock()
+
+	selects := []string{"SELECT " + workflowColumns + " FROM main.workflows"}
+	for _, name := range sortedShardNames(shards) {
+		selects = append(selects, "SELECT "+workflowColumns+" FROM "+name+".workflows")
+	}
+	rows, err := s.queryShards(shards, strings.Join(selects, "\nUNION ALL\n")+"\nORDER BY workflow_id;")
+	if err != nil {
+		return nil, err
+	}
+	out := make([]WorkflowRecord, 0, len(rows))
+	for _, row := range rows {
+		out = append(out, parseWorkflowRecord(row))
+	}
+	return out, nil
+}
+
+// queryShards runs query with shards attached for its duration only.
+func (s *Store) queryShards(shards map[string]string, query string) ([]map[string]any, error) {
+	names := sortedShardNames(shards)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conn, err := s.db.Conn(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	for _, name := range names {
+		if _, err := conn.ExecContext(context.Background(), "ATTACH DATABASE $1 AS "+name+";", shards[name]); err != nil {
+			return nil, err
+		}
+		defer conn.ExecContext(context.Background(), "DETACH DATABASE "+name+";")
+	}
+	rows, err := conn.QueryContext(context.Background(), query)
+	if err != nil {
+		return nil, err
+	}
+	return scanRows(rows)
+}
+
+func sortedShardNames(shards map[string]string) []string {
+	names := make([]string, 0, len(shards))
+	for name := range shards {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// isShardName reports whether name is a plain identifier that can be used as
+// a schema name without quoting.
+func isShardName(name string) bool {
+	if name == "" || strings.EqualFold(name, "main") || strings.EqualFold(name, "temp") {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case r == '_', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
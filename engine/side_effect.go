@@ -0,0 +1,30 @@
+package engine
+
+import "encoding/json"
+
+// SideEffect runs fn as a step that has no output. Once fn has succeeded the
+// step is recorded as completed and skipped on resume, without decoding
+// anything. A crash after fn returns but before the marker is written runs fn
+// again, so the caller must make fn idempotent.
+func SideEffect(ctx *Context, id string, fn func() error) (err error) {
+	if err := checkStepArgs(ctx, fn == nil); err != nil {
+		return err
+	}
+
+	ref := ctx.nextStepRef(id)
+	end := ctx.notifyBeforeStep(ref)
+	defer func() { end(err) }()
+
+	claim, _, err := ctx.claimStep(ref)
+	if err != nil {
+		return err
+	}
+	if claim == claimCached {
+		ctx.Log(LogLevelDebug, "side effect already performed", map[string]any{"step_key": ref.StepKey})
+		return nil
+	}
+	_, err = runClaimed(ctx, ref, func() (json.RawMessage, error) {
+		return json.RawMessage("null"), fn()
+	})
+	return err
+}
@@ -0,0 +1,56 @@
+package engine
+
+import (
+	"fmt"
+	"time"
+)
+
+// DurableSleep blocks until d has passed since the workflow first reached
+// this sleep. The wake-up time is checkpointed, so after a restart only the
+// remainder is waited, and nothing once it has passed. Each call takes the
+// next sequence for id, so sleeping in a loop checkpoints every iteration.
+// Cancelling the Context ends the sleep early with its error.
+func DurableSleep(ctx *Context, id string, d time.Duration) error {
+	if err := checkStepArgs(ctx, false); err != nil {
+		return err
+	}
+
+	ref := ctx.nextStepRef(id)
+	key := fmt.Sprintf("%s_sleep_%d", ref.StepID, ref.Sequence)
+
+	raw, found, err := ReadCheckpoint[string](ctx, key)
+	if err != nil {
+		return err
+	}
+	if !found {
+		raw = time.Now().Add(d).UTC().Format(time.RFC3339Nano)
+		if err := Checkpoint(ctx, key, raw); err != nil {
+			return err
+		}
+	}
+	wake, err := time.Parse(time.RFC3339Nano, raw)
+	if err != nil {
+		return fmt.Errorf("parse wake-up time for %s: %w", ref.StepKey, err)
+	}
+
+	if time.Until(wake) > 0 {
+		ctx.Log(LogLevelInfo, "durable sleep", map[string]any{"step_key": ref.StepKey, "wake_at": raw})
+	}
+	return ctx.sleepUntil(ref, wake)
+}
+
+// sleepUntil waits until wake, or until the Context is cancelled.
+func (c *Context) sleepUntil(ref StepRef, wake time.Time) error {
+	remaining := time.Until(wake)
+	if remaining <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(remaining)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-c.Done():
+		return fmt.Errorf("wait for %s: %w", ref.StepKey, c.GoContext().Err())
+	}
+}
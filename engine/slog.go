@@ -0,0 +1,69 @@
+package engine
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+)
+
+var discardLogger = slog.New(slog.DiscardHandler)
+
+// WithLogger makes the store report write retries to l. Contexts without a
+// Logger of their own also send their step events to l.
+func (s *Store) WithLogger(l *slog.Logger) *Store {
+	s.logger = l
+	return s
+}
+
+func (s *Store) slogger() *slog.Logger {
+	if s == nil || s.logger == nil {
+		return discardLogger
+	}
+	return s.logger
+}
+
+// WithLogger sends the Context's step events to l, overriding the logger of
+// its *Store.
+func (c *Context) WithLogger(l *slog.Logger) *Context {
+	return c.WithCustomLogger(SlogLogger(l))
+}
+
+// SlogLogger adapts l to Logger so it can be passed to
+// Context.WithCustomLogger.
+// Fields become attributes in key order.
+func SlogLogger(l *slog.Logger) Logger {
+	if l == nil {
+		l = discardLogger
+	}
+	return slogLogger{l: l}
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func (s slogLogger) Log(level, message string, fields map[string]any) {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	attrs := make([]slog.Attr, 0, len(keys))
+	for _, k := range keys {
+		attrs = append(attrs, slog.Any(k, fields[k]))
+	}
+	s.l.LogAttrs(context.Background(), slogLevel(level), message, attrs...)
+}
+
+func slogLevel(level string) slog.Level {
+	switch level {
+	case LogLevelDebug:
+		return slog.LevelDebug
+	case LogLevelWarn:
+		return slog.LevelWarn
+	case LogLevelError:
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
@@ -0,0 +1,254 @@
+package engine
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type GlobalStats struct {
+	TotalWorkflows           int64
+	TotalSteps               int64
+	CompletedSteps           int64
+	FailedSteps              int64
+	RunningSteps             int64
+	AvgStepsPerWorkflow      float64
+	OldestRunningWorkflowAge time.Duration
+}
+
+func (s *Store) GetGlobalStats() (GlobalStats, error) {
+	rows, err := s.queryRows(`
+SELECT
+  COUNT(DISTINCT st.workflow_id) AS total_workflows,
+  COUNT(st.step_key) AS total_steps,
+  COALESCE(SUM(CASE WHEN st.status=$1 THEN 1 ELSE 0 END), 0) AS completed_steps,
+  COALESCE(SUM(CASE WHEN st.status=$2 THEN 1 ELSE 0 END), 0) AS failed_steps,
+  COALESCE(SUM(CASE WHEN st.status=$3 THEN 1 ELSE 0 END), 0) AS running_steps,
+  (SELECT (julianday('now') - MIN(julianday(w.created_at))) * 86400.0
+     FROM workflows w
+    WHERE w.status=$3) AS oldest_running_seconds
+FROM steps st;`,
+		statusCompleted,
+		statusFailed,
+		statusRunning,
+	)
+	if err != nil {
+		return GlobalStats{}, err
+	}
+	if len(rows) == 0 {
+		return GlobalStats{}, nil
+	}
+	row := rows[0]
+	stats := GlobalStats{
+		TotalWorkflows: int64(asInt(row["total_workflows"])),
+		TotalSteps:     int64(asInt(row["total_steps"])),
+		CompletedSteps: int64(asInt(row["completed_steps"])),
+		FailedSteps:    int64(asInt(row["failed_steps"])),
+		RunningSteps:   int64(asInt(row["running_steps"])),
+	}
+	if stats.TotalWorkflows > 0 {
+		stats.AvgStepsPerWorkflow = float64(stats.TotalSteps) / float64(stats.TotalWorkflows)
+	}
+	if secs := asString(row["oldest_running_seconds"]); secs != "" {
+		// julianday('now') has millisecond resolution, so a workflow created
+		// moments ago can come out slightly negative.
+		if f, err := strconv.ParseFloat(secs, 64); err == nil && f > 0 {
+			stats.OldestRunningWorkflowAge = time.Duration(f * float64(time.Second))
+		}
+	}
+	return stats, nil
+}
+
+const errorPrefixLen = 80
+
+type ErrorTypeCount struct {
+	Prefix string
+	Count  int
+}
+
+// GetStepErrorsByType groups the workflow's failed steps by the first 80
+// characters of their error text.
+func (s *Store) GetStepErrorsByType(workflowID string) (map[string]int, error) {
+	rows, err := s.queryRows(`
+SELECT substr(COALESCE(error_text, ''), 1, $1) AS prefix, COUNT(*) AS n
+FROM steps
+WHERE workflow_id=$2 AND status=$3
+GROUP BY prefix;`, errorPrefixLen, workflowID, statusFailed)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]int, len(rows))
+	for _, row := range rows {
+		out[asString(row["prefix"])] = asInt(row["n"])
+	}
+	return out, nil
+}
+
+func (s *Store) GetGlobalErrorsByType(limit int) ([]ErrorTypeCount, error) {
+	args := []any{errorPrefixLen, statusFailed}
+	q := `
+SELECT substr(COALESCE(error_text, ''), 1, $1) AS prefix, COUNT(*) AS n
+FROM steps
+WHERE status=$2
+GROUP BY prefix
+ORDER BY n DESC, prefix`
+	if limit > 0 {
+		q += "\nLIMIT $3"
+		args = append(args, limit)
+	}
+
+	rows, err := s.queryRows(q+";", args...)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]ErrorTypeCount, 0, len(rows))
+	for _, row := range rows {
+		out = append(out, ErrorTypeCount{Prefix: asString(row["prefix"]), Count: asInt(row["n"])})
+	}
+	return out, nil
+}
+
+// GetTotalOutputSize returns the bytes of checkpointed output stored for the
+// workflow.
+func (s *Store) GetTotalOutputSize(workflowID string) (int64, error) {
+	return s.sumOutputSize("WHERE workflow_id=$1", workflowID)
+}
+
+func (s *Store) GetGlobalOutputSize() (int64, error) {
+	return s.sumOutputSize("")
+}
+
+func (s *Store) sumOutputSize(where string, args ...any) (int64, error) {
+	rows, err := s.queryRows("\nSELECT COALESCE(SUM(LENGTH(output_json)), 0) AS total\nFROM steps\n"+where+";", args...)
+	if err != nil {
+		return 0, err
+	}
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	return int64(asInt(rows[0]["total"])), nil
+}
+
+// GetWorkflowProgress counts the workflow's completed steps against all of
+// its running, completed and failed steps.
+func (s *Store) GetWorkflowProgress(workflowID string) (completed, total int, err error) {
+	rows, err := s.queryRows(`
+SELECT COUNT(*) AS total,
+       COALESCE(SUM(CASE WHEN status=$1 THEN 1 ELSE 0 END), 0) AS completed
+FROM steps
+WHERE workflow_id=$2 AND status IN ($1, $3, $4);`,
+		statusCompleted,
+		workflowID,
+		statusRunning,
+		statusFailed,
+	)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(rows) == 0 {
+		return 0, 0, nil
+	}
+	return asInt(rows[0]["completed"]), asInt(rows[0]["total"]), nil
+}
+
+// WorkflowSummary rolls up a workflow's steps. Status is running while any
+// step runs, failed if a step failed and none runs, and completed otherwise.
+type WorkflowSummary struct {
+	WorkflowID     string
+	TotalSteps     int
+	CompletedSteps int
+	FailedSteps    int
+	RunningSteps   int
+	StartedAt      string
+	LastUpdatedAt  string
+	Status         string
+}
+
+// GetWorkflowSummary aggregates the workflow's steps in one query. The
+// timestamps are the earliest step start and the latest step update, at
+// millisecond resolution.
+func (s *Store) GetWorkflowSummary(workflowID string) (WorkflowSummary, error) {
+	rows, err := s.queryRows(`
+SELECT workflow_id,
+       COUNT(*) AS total_steps,
+       SUM(CASE WHEN status=$2 THEN 1 ELSE 0 END) AS completed_steps,
+       SUM(CASE WHEN status=$3 THEN 1 ELSE 0 END) AS failed_steps,
+       SUM(CASE WHEN status=$4 THEN 1 ELSE 0 END) AS running_steps,
+       strftime('%Y-%m-%dT%H:%M:%fZ', MIN(julianday(started_at))) AS started_at,
+       strftime('%Y-%m-%dT%H:%M:%fZ', MAX(julianday(updated_at))) AS last_updated_at
+FROM steps
+WHERE workflow_id=$1
+GROUP BY workflow_id;`,
+		workflowID,
+		statusCompleted,
+		statusFailed,
+		statusRunning,
+	)
+	if err != nil {
+		return WorkflowSummary{}, err
+	}
+	if len(rows) == 0 {
+		return WorkflowSummary{}, fmt.Errorf("%s: %w", workflowID, ErrWorkflowNotFound)
+	}
+	row := rows[0]
+	summary := WorkflowSummary{
+		WorkflowID:     asString(row["workflow_id"]),
+		TotalSteps:     asInt(row["total_steps"]),
+		CompletedSteps: asInt(row["completed_steps"]),
+		FailedSteps:    asInt(row["failed_steps"]),
+		RunningSteps:   asInt(row["running_steps"]),
+		StartedAt:      asString(row["started_at"]),
+		LastUpdatedAt:  asString(row["last_updated_at"]),
+		Status:         statusCompleted,
+	}
+	switch {
+	case summary.RunningSteps > 0:
+		summary.Status = statusRunning
+	case summary.FailedSteps > 0:
+		summary.Status = statusFailed
+	}
+	return summary, nil
+}
+
+type TableSizeReport struct {
+	StepsRowCount      int64
+	StepsDataBytes     int64
+	StepsIndexBytes    int64
+	WorkflowsRowCount  int64
+	WorkflowsDataBytes int64
+}
+
+// TableSize reports row counts and on-disk page usage from SQLite's dbstat
+// virtual table. Other dialects, and SQLite builds without dbstat, get a zero
+// report.
+func (s *Store) TableSize() (TableSizeReport, error) {
+	if _, ok := s.dialect.(SQLiteDialect); !ok {
+		return TableSizeReport{}, nil
+	}
+	rows, err := s.queryRows(`
+SELECT
+  (SELECT COUNT(*) FROM steps) AS steps_rows,
+  (SELECT COALESCE(SUM(pgsize), 0) FROM dbstat WHERE name='steps') AS steps_data,
+  (SELECT COALESCE(SUM(pgsize), 0) FROM dbstat
+    WHERE name IN (SELECT name FROM sqlite_master WHERE type='index' AND tbl_name='steps')) AS steps_index,
+  (SELECT COUNT(*) FROM workflows) AS workflows_rows,
+  (SELECT COALESCE(SUM(pgsize), 0) FROM dbstat WHERE name='workflows') AS workflows_data;`)
+	if err != nil {
+		if strings.Contains(err.Error(), "no such table: dbstat") {
+			return TableSizeReport{}, nil
+		}
+		return TableSizeReport{}, err
+	}
+	if len(rows) == 0 {
+		return TableSizeReport{}, nil
+	}
+	row := rows[0]
+	return TableSizeReport{
+		StepsRowCount:      int64(asInt(row["steps_rows"])),
+		StepsDataBytes:     int64(asInt(row["steps_data"])),
+		StepsIndexBytes:    int64(asInt(row["steps_index"])),
+		WorkflowsRowCount:  int64(asInt(row["workflows_rows"])),
+		WorkflowsDataBytes: int64(asInt(row["workflows_data"])),
+	}, nil
+}
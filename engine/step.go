@@ -1,12 +1,22 @@
 package engine
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
+	"reflect"
 	"time"
 )
 
+var (
+	ErrMaxStepsExceeded = errors.New("workflow exceeded its maximum number of steps")
+	ErrOutputCorrupted  = errors.New("checkpointed output does not match its checksum")
+	// ErrOutputTypeMismatch is returned when a step typed as an interface
+	// replays a cached output that decodes to a different dynamic type than
+	// the one originally returned.
+	ErrOutputTypeMismatch = errors.New("cached output type does not match the step's original result")
+)
+
 type claimResult int
 
 const (
@@ -15,74 +25,226 @@ const (
 )
 
 func Step[T any](ctx *Context, id string, fn func() (T, error)) (T, error) {
+	if err := checkStepArgs(ctx, fn == nil); err != nil {
+		var zero T
+		return zero, err
+	}
+	return stepWithRef(ctx, ctx.nextStepRef(id), fn)
+}
+
+// stepWithRef runs a step under a key that was already allocated, so callers
+// that fan out can assign sequences deterministically before going parallel.
+func stepWithRef[T any](ctx *Context, ref StepRef, fn func() (T, error)) (_ T, err error) {
 	var zero T
 
-	if ctx == nil {
-		return zero, errors.New("nil durable context")
-	}
-	if ctx.store == nil {
-		return zero, errors.New("nil durable store")
-	}
-	if fn == nil {
-		return zero, errors.New("step function is nil")
-	}
+	end := ctx.notifyBeforeStep(ref)
+	defer func() { end(err) }()
 
-	ref := ctx.nextStepRef(id)
-	claim, cachedJSON, err := ctx.claimStep(ref)
+	claim, cached, err := ctx.claimStep(ref)
 	if err != nil {
 		return zero, err
 	}
 
 	if claim == claimCached {
-		var out T
-		if err := json.Unmarshal([]byte(cachedJSON), &out); err != nil {
-			return zero, fmt.Errorf("decode cached step result for %s: %w", ref.StepKey, err)
+		ctx.Log(LogLevelDebug, "step replayed from checkpoint", map[string]any{"step_key": ref.StepKey})
+		return decodeCached[T](ctx, ref, cached)
+	}
+	return runClaimed(ctx, ref, fn)
+}
+
+func checkStepArgs(ctx *Context, fnIsNil bool) error {
+	if ctx == nil {
+		return errors.New("nil durable context")
+	}
+	if isNilBackend(ctx.backend) {
+		return errors.New("nil durable store")
+	}
+	if fnIsNil {
+		return errors.New("step function is nil")
+	}
+	return nil
+}
+
+func decodeCached[T any](ctx *Context, ref StepRef, cached StepRecord) (T, error) {
+	var out, zero T
+	isInterface := reflect.TypeFor[T]().Kind() == reflect.Interface
+	if err := ctx.decodeOutput(cached, &out); err != nil {
+		if isInterface && !errors.Is(err, ErrCodecMismatch) {
+			return zero, fmt.Errorf("decode cached step result for %s: %w: %v", ref.StepKey, ErrOutputTypeMismatch, err)
 		}
-		return out, nil
+		return zero, fmt.Errorf("decode cached step result for %s: %w", ref.StepKey, err)
 	}
+	if isInterface {
+		// JSON cannot restore the dynamic type behind an interface, so compare
+		// what decoding produced with what fn originally returned.
+		meta, err := decodeStepMetadata(cached)
+		if err != nil {
+			return zero, err
+		}
+		if want, ok := meta["output_type"].(string); ok && want != dynamicTypeName(out) {
+			return zero, fmt.Errorf("decode cached step result for %s: %w: stored %s, decoded %s", ref.StepKey, ErrOutputTypeMismatch, want, dynamicTypeName(out))
+		}
+	}
+	return out, nil
+}
+
+func dynamicTypeName(v any) string {
+	if v == nil {
+		return "nil"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// runClaimed executes fn for a step this run has claimed and checkpoints the outcome.
+func runClaimed[T any](ctx *Context, ref StepRef, fn func() (T, error)) (T, error) {
+	return runClaimedContext(context.Background(), ctx, ref, func(context.Context) (T, error) { return fn() })
+}
+
+// runClaimedContext is runClaimed with goCtx passed to fn. If goCtx is done
+// before the completion checkpoint is written, the step is left running so
+// the next run re-executes it instead of trusting an unrecorded result.
+func runClaimedContext[T any](goCtx context.Context, ctx *Context, ref StepRef, fn func(context.Context) (T, error)) (T, error) {
+	var zero T
 
-	result, err := fn()
+	spanCtx, end := ctx.startSpan(goCtx, ref, false)
+	result, err := fn(spanCtx)
+	end(err)
 	if err != nil {
-		_ = ctx.store.MarkFailed(ctx.WorkflowID, ref.StepKey, ctx.RunID, err.Error())
+		errText := ctx.formatError(ref.StepKey, err)
+		_ = ctx.backend.MarkFailed(ctx.WorkflowID, ref.StepKey, ctx.RunID, errText)
+		ctx.Log(LogLevelError, "step failed", map[string]any{"step_key": ref.StepKey, "error": errText})
 		return zero, fmt.Errorf("step %s failed: %w", ref.StepKey, err)
 	}
 
-	payload, err := json.Marshal(result)
+	payload, err := ctx.encodeOutput(result)
 	if err != nil {
-		_ = ctx.store.MarkFailed(ctx.WorkflowID, ref.StepKey, ctx.RunID, "marshal error: "+err.Error())
+		_ = ctx.backend.MarkFailed(ctx.WorkflowID, ref.StepKey, ctx.RunID, "marshal error: "+ctx.formatError(ref.StepKey, err))
 		return zero, fmt.Errorf("marshal step result for %s: %w", ref.StepKey, err)
 	}
+	if err := ctx.checkOutputSize(ref, payload); err != nil {
+		_ = ctx.backend.MarkFailed(ctx.WorkflowID, ref.StepKey, ctx.RunID, ctx.formatError(ref.StepKey, err))
+		return zero, err
+	}
 
-	if err := ctx.store.MarkCompleted(ctx.WorkflowID, ref.StepKey, ctx.RunID, string(payload)); err != nil {
+	var metadataJSON string
+	if reflect.TypeFor[T]().Kind() == reflect.Interface {
+		if metadataJSON, err = ctx.outputTypeMetadata(ref, dynamicTypeName(result)); err != nil {
+			_ = ctx.backend.MarkFailed(ctx.WorkflowID, ref.StepKey, ctx.RunID, ctx.formatError(ref.StepKey, err))
+			return zero, err
+		}
+	}
+	if err := ctx.markCompleted(goCtx, ref, string(payload), metadataJSON); err != nil {
+		if goCtx.Err() != nil {
+			return zero, fmt.Errorf("step %s executed but left running, context done before checkpoint: %w", ref.StepKey, goCtx.Err())
+		}
 		return zero, fmt.Errorf("step %s executed but completion checkpoint failed (possible zombie step): %w", ref.StepKey, err)
 	}
+	ctx.cacheCompleted(ref, string(payload))
+	ctx.Log(LogLevelInfo, "step completed", map[string]any{"step_key": ref.StepKey})
 	return result, nil
 }
 
-func (c *Context) claimStep(ref stepRef) (claimResult, string, error) {
+// markCompleted writes the completion checkpoint unless goCtx is already
+// done. Only *Store can also abandon a write that is in progress, and only
+// *Store writes metadataJSON in the same transaction; other backends write it
+// afterwards and just log a failure, since the output is already safe.
+func (c *Context) markCompleted(goCtx context.Context, ref StepRef, outputJSON, metadataJSON string) error {
+	if err := goCtx.Err(); err != nil {
+		return err
+	}
+	if c.store != nil {
+		return c.store.markCompletedContext(goCtx, c.WorkflowID, ref.StepKey, c.RunID, outputJSON, metadataJSON)
+	}
+	if err := c.backend.MarkCompleted(c.WorkflowID, ref.StepKey, c.RunID, outputJSON); err != nil {
+		return err
+	}
+	if store, ok := c.backend.(stepMetadataStore); ok && metadataJSON != "" {
+		if err := store.SetStepMetadata(c.WorkflowID, ref.StepKey, metadataJSON); err != nil {
+			c.Log(LogLevelWarn, "write step metadata failed", map[string]any{"step_key": ref.StepKey, "error": err.Error()})
+		}
+	}
+	return nil
+}
+
+func (c *Context) claimStep(ref StepRef) (claimResult, StepRecord, error) {
+	if err := c.checkCancelled(ref); err != nil {
+		return claimExecute, StepRecord{}, err
+	}
+
 	c.claimMu.Lock()
 	defer c.claimMu.Unlock()
 
-	record, found, err := c.store.GetStep(c.WorkflowID, ref.StepKey)
+	record, found, err := c.loadStep(ref.StepKey)
 	if err != nil {
-		return claimExecute, "", fmt.Errorf("load step state for %s: %w", ref.StepKey, err)
+		return claimExecute, StepRecord{}, &storeError{fmt.Errorf("load step state for %s: %w", ref.StepKey, err)}
 	}
 
-	if !found {
-		if err := c.store.UpsertRunning(c.WorkflowID, ref, c.RunID); err != nil {
-			return claimExecute, "", fmt.Errorf("insert running step %s: %w", ref.StepKey, err)
+	claim, action, err := c.resolveClaim(ref, record, found)
+	if err != nil {
+		return claimExecute, StepRecord{}, err
+	}
+	if claim == claimCached {
+		if err := c.verifyOutput(record); err != nil {
+			return claimExecute, StepRecord{}, err
 		}
-		return claimExecute, "", nil
+		_, end := c.startSpan(context.Background(), ref, true)
+		end(nil)
+		if len(c.listeners) > 0 {
+			if c.replayed == nil {
+				c.replayed = make(map[string]bool)
+			}
+			c.replayed[ref.StepKey] = true
+		}
+		return claimCached, record, nil
+	}
+	if err := c.countClaim(ref); err != nil {
+		return claimExecute, StepRecord{}, err
+	}
+	if err := c.claimRunning(ref); err != nil {
+		if errors.Is(err, ErrStepStillRunning) {
+			return claimExecute, StepRecord{}, c.claimLost(ref)
+		}
+		return claimExecute, StepRecord{}, &storeError{fmt.Errorf("%s %s: %w", action, ref.StepKey, err)}
+	}
+	return claimExecute, StepRecord{}, nil
+}
+
+// claimLost reports a claim that another run won between loading the step
+// and claiming it, with the error resolveClaim gives when it sees that run.
+func (c *Context) claimLost(ref StepRef) error {
+	record, found, err := c.backend.GetStep(c.WorkflowID, ref.StepKey)
+	if err != nil || !found {
+		return fmt.Errorf("step %s: %w", ref.StepKey, ErrStepStillRunning)
+	}
+	if record.Status == statusCompleted {
+		return fmt.Errorf("step %s was completed by run_id=%s", ref.StepKey, record.RunID)
+	}
+	return fmt.Errorf("step %s is still running under run_id=%s", ref.StepKey, record.RunID)
+}
+
+// countClaim enforces WithMaxSteps for a step about to execute.
+func (c *Context) countClaim(ref StepRef) error {
+	if c.maxSteps <= 0 {
+		return nil
+	}
+	if c.claimedSteps.Add(1) > int64(c.maxSteps) {
+		return fmt.Errorf("step %s: %w (limit %d)", ref.StepKey, ErrMaxStepsExceeded, c.maxSteps)
+	}
+	return nil
+}
+
+// resolveClaim decides what to do with a step's persisted state. For
+// claimExecute it also names the action, used to annotate write failures.
+func (c *Context) resolveClaim(ref StepRef, record StepRecord, found bool) (claimResult, string, error) {
+	if !found {
+		return claimExecute, "insert running step", nil
 	}
 
 	switch record.Status {
 	case statusCompleted:
-		return claimCached, record.OutputJSON, nil
+		return claimCached, "", nil
 	case statusFailed:
-		if err := c.store.UpsertRunning(c.WorkflowID, ref, c.RunID); err != nil {
-			return claimExecute, "", fmt.Errorf("retry failed step %s: %w", ref.StepKey, err)
-		}
-		return claimExecute, "", nil
+		return claimExecute, "retry failed step", nil
 	case statusRunning:
 		if record.RunID == c.RunID {
 			return claimExecute, "", fmt.Errorf("step %s is already running in this execution", ref.StepKey)
@@ -90,16 +252,24 @@ func (c *Context) claimStep(ref stepRef) (claimResult, string, error) {
 		if !c.canTakeOverZombie(record) {
 			return claimExecute, "", fmt.Errorf("step %s is still running under run_id=%s", ref.StepKey, record.RunID)
 		}
-		if err := c.store.UpsertRunning(c.WorkflowID, ref, c.RunID); err != nil {
-			return claimExecute, "", fmt.Errorf("take over zombie step %s: %w", ref.StepKey, err)
-		}
-		return claimExecute, "", nil
+		c.Log(LogLevelWarn, "taking over zombie step", map[string]any{"step_key": ref.StepKey, "previous_run_id": record.RunID})
+		return claimExecute, "take over zombie step", nil
 	default:
-		if err := c.store.UpsertRunning(c.WorkflowID, ref, c.RunID); err != nil {
-			return claimExecute, "", fmt.Errorf("reset unknown state for step %s: %w", ref.StepKey, err)
-		}
-		return claimExecute, "", nil
+		return claimExecute, "reset unknown state for step", nil
+	}
+}
+
+// verifyOutput checks a cached output against its stored checksum. Rows
+// written before checksums existed have none and are trusted with a warning.
+func (c *Context) verifyOutput(record StepRecord) error {
+	if record.OutputChecksum == "" {
+		c.Log(LogLevelWarn, "cached step has no output checksum", map[string]any{"step_key": record.StepKey})
+		return nil
+	}
+	if outputChecksum(record.OutputJSON) != record.OutputChecksum {
+		return fmt.Errorf("step %s: %w", record.StepKey, ErrOutputCorrupted)
 	}
+	return nil
 }
 
 func (c *Context) canTakeOverZombie(record StepRecord) bool {
@@ -0,0 +1,36 @@
+package engine
+
+import (
+	"context"
+	"errors"
+)
+
+// StepWithContext is Step for functions that honour cancellation. goCtx is
+// passed to fn and bounds the completion checkpoint: if it is done before the
+// result is recorded, the step stays running and is re-executed on resume.
+func StepWithContext[T any](goCtx context.Context, ctx *Context, id string, fn func(context.Context) (T, error)) (_ T, err error) {
+	var zero T
+
+	if err := checkStepArgs(ctx, fn == nil); err != nil {
+		return zero, err
+	}
+	if goCtx == nil {
+		return zero, errors.New("nil go context")
+	}
+
+	ref := ctx.nextStepRef(id)
+	end := ctx.notifyBeforeStep(ref)
+	defer func() { end(err) }()
+
+	if err := goCtx.Err(); err != nil {
+		return zero, err
+	}
+	claim, cached, err := ctx.claimStep(ref)
+	if err != nil {
+		return zero, err
+	}
+	if claim == claimCached {
+		return decodeCached[T](ctx, ref, cached)
+	}
+	return runClaimedContext(goCtx, ctx, ref, fn)
+}
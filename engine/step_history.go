@@ -0,0 +1,63 @@
+package engine
+
+import "fmt"
+
+const stepHistoryDDL = `
+CREATE TABLE IF NOT EXISTS step_history (
+  workflow_id TEXT NOT NULL,
+  step_key TEXT NOT NULL,
+  attempt INTEGER NOT NULL,
+  recorded_at TEXT NOT NULL,
+  step_id TEXT NOT NULL,
+  sequence INTEGER NOT NULL,
+  status TEXT NOT NULL,
+  output_json TEXT,
+  error_text TEXT,
+  run_id TEXT NOT NULL,
+  started_at TEXT NOT NULL,
+  updated_at TEXT NOT NULL,
+  completed_at TEXT,
+  metadata_json TEXT,
+  output_checksum TEXT,
+  attempt_count INTEGER NOT NULL DEFAULT 0,
+  PRIMARY KEY (workflow_id, step_key, attempt)
+);`
+
+func createStepHistory(*Store) ([]string, error) {
+	return []string{stepHistoryDDL}, nil
+}
+
+// stepHistorySnapshotSQL copies the step's row into step_history before
+// UpsertRunning overwrites it. Completed rows are never overwritten, so they
+// are not copied.
+func stepHistorySnapshotSQL(workflowID, stepKey, now string) Statement {
+	return Statement{Query: `
+INSERT INTO step_history(attempt, recorded_at, ` + stepColumns + `)
+SELECT (SELECT COALESCE(MAX(attempt), 0) + 1 FROM step_history WHERE workflow_id=$1 AND step_key=$2), $3, ` + stepColumns + `
+FROM steps
+WHERE workflow_id=$1 AND step_key=$2 AND status <> $4;`,
+		Args: []any{workflowID, stepKey, now, statusCompleted},
+	}
+}
+
+// GetStepHistory returns every earlier state of a step that a later claim
+// overwrote, oldest first, followed by its current row. A step that ran
+// once has just the current row.
+func (s *Store) GetStepHistory(workflowID, stepKey string) ([]StepRecord, error) {
+	history, err := s.queryStepRecords(`
+SELECT `+stepColumns+`
+FROM step_history
+WHERE workflow_id=$1 AND step_key=$2
+ORDER BY attempt;`, workflowID, stepKey)
+	if err != nil {
+		return nil, fmt.Errorf("load history of %s: %w", stepKey, err)
+	}
+	current, found, err := s.GetStep(workflowID, stepKey)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		history = append(history, current)
+	}
+	return history, nil
+}
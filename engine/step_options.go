@@ -0,0 +1,49 @@
+package engine
+
+import "errors"
+
+type StepOptions struct {
+	// FallbackOnStoreError runs fn without checkpointing when the store cannot
+	// be read or the step cannot be claimed. The result is returned but not
+	// persisted, so a later run will execute the step again.
+	FallbackOnStoreError bool
+}
+
+// storeError marks claim failures caused by the store itself, as opposed to
+// the step's state (already running, step limit reached).
+type storeError struct {
+	err error
+}
+
+func (e *storeError) Error() string { return e.err.Error() }
+func (e *storeError) Unwrap() error { return e.err }
+
+func StepWithOptions[T any](ctx *Context, id string, opts StepOptions, fn func() (T, error)) (_ T, err error) {
+	var zero T
+
+	if err := checkStepArgs(ctx, fn == nil); err != nil {
+		return zero, err
+	}
+
+	ref := ctx.nextStepRef(id)
+	end := ctx.notifyBeforeStep(ref)
+	defer func() { end(err) }()
+
+	claim, cached, err := ctx.claimStep(ref)
+	if err != nil {
+		var se *storeError
+		if !opts.FallbackOnStoreError || !errors.As(err, &se) {
+			return zero, err
+		}
+		ctx.Log(LogLevelWarn, "store unavailable, running step without checkpoint", map[string]any{
+			"step_key": ref.StepKey,
+			"error":    err.Error(),
+		})
+		return fn()
+	}
+
+	if claim == claimCached {
+		return decodeCached[T](ctx, ref, cached)
+	}
+	return runClaimed(ctx, ref, fn)
+}
@@ -0,0 +1,111 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	defaultStepRetryBackoff    = 100 * time.Millisecond
+	defaultStepRetryMaxBackoff = 10 * time.Second
+)
+
+// stepAttemptStore is implemented by backends that count step claims and
+// keep the error of a retried attempt.
+type stepAttemptStore interface {
+	GetStepAttemptCount(workflowID, stepKey string) (int, error)
+	RecordStepAttempt(workflowID, stepKey, runID, errText string) error
+}
+
+// WithRetryBackoff sets the delay StepWithRetry waits after the first failed
+// attempt. It doubles after every further failure, up to max when max is set.
+func (c *Context) WithRetryBackoff(initial, max time.Duration) *Context {
+	c.retryBackoff = initial
+	c.retryMaxBackoff = max
+	c.retryBackoffSet = true
+	return c
+}
+
+// StepWithRetry runs fn up to maxAttempts times before the step is marked
+// failed. Every attempt is a claim of the step, counted by
+// GetStepAttemptCount from the step's RetryBase, so a resumed workflow
+// continues counting where the crashed run stopped.
+func StepWithRetry[T any](ctx *Context, id string, maxAttempts int, fn func() (T, error)) (_ T, err error) {
+	var zero T
+
+	if err := checkStepArgs(ctx, fn == nil); err != nil {
+		return zero, err
+	}
+	if maxAttempts < 1 {
+		return zero, fmt.Errorf("max attempts must be at least 1, got %d", maxAttempts)
+	}
+	attempts, ok := ctx.backend.(stepAttemptStore)
+	if !ok {
+		return zero, fmt.Errorf("step retries: %w", ErrUnsupportedBackend)
+	}
+
+	ref := ctx.nextStepRef(id)
+	end := ctx.notifyBeforeStep(ref)
+	defer func() { end(err) }()
+
+	claim, cached, err := ctx.claimStep(ref)
+	if err != nil {
+		return zero, err
+	}
+	if claim == claimCached {
+		return decodeCached[T](ctx, ref, cached)
+	}
+
+	record, _, err := ctx.backend.GetStep(ctx.WorkflowID, ref.StepKey)
+	if err != nil {
+		return zero, fmt.Errorf("load retry base for %s: %w", ref.StepKey, err)
+	}
+	return runClaimed(ctx, ref, func() (T, error) {
+		backoff, maxBackoff := ctx.stepRetryBackoff()
+		for {
+			claims, err := attempts.GetStepAttemptCount(ctx.WorkflowID, ref.StepKey)
+			if err != nil {
+				return zero, fmt.Errorf("load attempt count for %s: %w", ref.StepKey, err)
+			}
+			attempt := claims - record.RetryBase
+			if attempt > maxAttempts {
+				return zero, fmt.Errorf("all %d attempts already used", maxAttempts)
+			}
+			result, err := fn()
+			if err == nil {
+				return result, nil
+			}
+			err = fmt.Errorf("attempt %d/%d: %w", attempt, maxAttempts, err)
+			errText := ctx.formatError(ref.StepKey, err)
+			if rerr := attempts.RecordStepAttempt(ctx.WorkflowID, ref.StepKey, ctx.RunID, errText); rerr != nil {
+				return zero, errors.Join(err, fmt.Errorf("record attempt: %w", rerr))
+			}
+			if attempt >= maxAttempts {
+				return zero, err
+			}
+			ctx.Log(LogLevelWarn, "step attempt failed, retrying", map[string]any{"step_key": ref.StepKey, "attempt": attempt, "error": err.Error()})
+
+			time.Sleep(backoff)
+			backoff *= 2
+			if maxBackoff > 0 && backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			if err := ctx.claimRunning(ref); err != nil {
+				return zero, fmt.Errorf("claim attempt %d of %s: %w", attempt+1, ref.StepKey, err)
+			}
+			// The claim clears error_text; keep the last failure visible
+			// while the next attempt runs.
+			if err := attempts.RecordStepAttempt(ctx.WorkflowID, ref.StepKey, ctx.RunID, errText); err != nil {
+				return zero, fmt.Errorf("record attempt %d of %s: %w", attempt, ref.StepKey, err)
+			}
+		}
+	})
+}
+
+func (c *Context) stepRetryBackoff() (initial, max time.Duration) {
+	if !c.retryBackoffSet {
+		return defaultStepRetryBackoff, defaultStepRetryMaxBackoff
+	}
+	return c.retryBackoff, c.retryMaxBackoff
+}
@@ -0,0 +1,90 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// StepWithSchemaVersion behaves like Step but tags the checkpoint with
+// schemaVersion. A cached output stored under another version (or none, which
+// counts as 0) is passed to migrate and the result is checkpointed again at
+// schemaVersion. If migrate fails, fn is re-executed instead, and if that
+// fails too the step is marked failed.
+func StepWithSchemaVersion[T any](ctx *Context, id string, schemaVersion int, migrate func(int, json.RawMessage) (T, error), fn func() (T, error)) (_ T, err error) {
+	var zero T
+
+	if err := checkStepArgs(ctx, fn == nil || migrate == nil); err != nil {
+		return zero, err
+	}
+	if !ctx.usesJSON() {
+		// migrate is handed the stored output as JSON.
+		return zero, fmt.Errorf("step %s: schema versioned steps need the JSON codec, context uses %s", id, ctx.codecName())
+	}
+
+	ref := ctx.nextStepRef(id)
+	end := ctx.notifyBeforeStep(ref)
+	defer func() { end(err) }()
+
+	claim, cached, err := ctx.claimStep(ref)
+	if err != nil {
+		return zero, err
+	}
+
+	if claim == claimExecute {
+		if err := ctx.writeStepMetadata(ref, map[string]any{"schema_version": schemaVersion}); err != nil {
+			return zero, err
+		}
+		return runClaimed(ctx, ref, fn)
+	}
+
+	meta, err := decodeStepMetadata(cached)
+	if err != nil {
+		return zero, err
+	}
+	stored := 0
+	if v, ok := meta["schema_version"].(float64); ok {
+		stored = int(v)
+	}
+	if stored == schemaVersion {
+		return decodeCached[T](ctx, ref, cached)
+	}
+
+	out, err := migrate(stored, json.RawMessage(cached.OutputJSON))
+	if err != nil {
+		ctx.Log(LogLevelWarn, "step output migration failed, re-executing", map[string]any{
+			"step_key": ref.StepKey,
+			"from":     stored,
+			"to":       schemaVersion,
+			"error":    err.Error(),
+		})
+		out, err = fn()
+		if err != nil {
+			err = fmt.Errorf("step %s failed: %w", ref.StepKey, err)
+			if merr := ctx.backend.MarkFailed(ctx.WorkflowID, ref.StepKey, ctx.RunID, ctx.formatError(ref.StepKey, err)); merr != nil {
+				return zero, errors.Join(err, fmt.Errorf("mark step %s failed: %w", ref.StepKey, merr))
+			}
+			return zero, err
+		}
+	}
+
+	payload, err := json.Marshal(out)
+	if err != nil {
+		return zero, fmt.Errorf("marshal step result for %s: %w", ref.StepKey, err)
+	}
+	meta["schema_version"] = schemaVersion
+	metaJSON, err := json.Marshal(meta)
+	if err != nil {
+		return zero, fmt.Errorf("encode step metadata for %s: %w", ref.StepKey, err)
+	}
+	// Output and version tag are written together, so a crash cannot leave
+	// the new output under the old version.
+	store, ok := ctx.backend.(checkpointStore)
+	if !ok {
+		return zero, fmt.Errorf("re-checkpoint step %s: %w", ref.StepKey, ErrUnsupportedBackend)
+	}
+	if err := store.PutCheckpoint(ctx.WorkflowID, ref, ctx.RunID, string(payload), string(metaJSON)); err != nil {
+		return zero, fmt.Errorf("re-checkpoint step %s at schema version %d: %w", ref.StepKey, schemaVersion, err)
+	}
+	return out, nil
+}
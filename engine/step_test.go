@@ -1,8 +1,18 @@
 package engine
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"durableexec/internal/errgroup"
 )
@@ -176,7 +186,1755 @@ func TestAutomaticStepIDGeneration(t *testing.T) {
 	}
 }
 
-func newTestStore(t *testing.T) *Store {
+func TestCustomErrorFormatterIsUsed(t *testing.T) {
+	store := newTestStore(t)
+	const workflowID = "wf-error-format"
+
+	ctx := NewContext(workflowID, store).WithErrorFormatter(ErrorFormatterFunc(func(workflowID, stepKey string, err error) string {
+		return "corr-123 " + strings.ReplaceAll(err.Error(), "ada@example.com", "<redacted>")
+	}))
+	_, err := Step(ctx, "send_email", func() (string, error) {
+		return "", errors.New("smtp rejected ada@example.com")
+	})
+	if err == nil {
+		t.Fatalf("expected step failure")
+	}
+
+	row, found, err := store.GetStep(workflowID, "send_email#000001")
+	if err != nil {
+		t.Fatalf("load row failed: %v", err)
+	}
+	if !found {
+		t.Fatalf("expected failed row to exist")
+	}
+	if row.ErrorText != "corr-123 smtp rejected <redacted>" {
+		t.Fatalf("unexpected error_text: %q", row.ErrorText)
+	}
+}
+
+func TestStepWithInputHashDetectsChangedInput(t *testing.T) {
+	store := newTestStore(t)
+	const workflowID = "wf-input-hash"
+
+	double := func(n int) (int, error) { return n * 2, nil }
+
+	got, err := StepWithInputHash(NewContext(workflowID, store), "double", 21, double)
+	if err != nil {
+		t.Fatalf("first run failed: %v", err)
+	}
+	if got != 42 {
+		t.Fatalf("unexpected first result: %d", got)
+	}
+
+	got, err = StepWithInputHash(NewContext(workflowID, store), "double", 21, double)
+	if err != nil {
+		t.Fatalf("replay with same input failed: %v", err)
+	}
+	if got != 42 {
+		t.Fatalf("unexpected replay result: %d", got)
+	}
+
+	_, err = StepWithInputHash(NewContext(workflowID, store), "double", 22, double)
+	if !errors.Is(err, ErrInputChanged) {
+		t.Fatalf("expected ErrInputChanged, got %v", err)
+	}
+}
+
+func TestWhenAll2ReturnsBothResults(t *testing.T) {
+	store := newTestStore(t)
+	const workflowID = "wf-when-all"
+
+	calls := 0
+	run := func() (string, int, error) {
+		return WhenAll2(NewContext(workflowID, store), "laptop", "access",
+			func() (string, error) {
+				calls++
+				return "LAP-1", nil
+			},
+			func() (int, error) {
+				return 7, nil
+			},
+		)
+	}
+
+	laptop, access, err := run()
+	if err != nil {
+		t.Fatalf("first run failed: %v", err)
+	}
+	if laptop != "LAP-1" || access != 7 {
+		t.Fatalf("unexpected results: %q, %d", laptop, access)
+	}
+
+	laptop, access, err = run()
+	if err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	if laptop != "LAP-1" || access != 7 {
+		t.Fatalf("unexpected replay results: %q, %d", laptop, access)
+	}
+	if calls != 1 {
+		t.Fatalf("expected laptop fn to run once, ran %d times", calls)
+	}
+
+	rows, err := store.ListSteps(workflowID)
+	if err != nil {
+		t.Fatalf("list steps failed: %v", err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 rows, got %d", len(rows))
+	}
+}
+
+// gateListener holds BeforeStep of every step but first until first has
+// reported AfterStep.
+type gateListener struct {
+	first string
+	done  chan struct{}
+}
+
+func (g *gateListener) BeforeStep(_, stepKey, _ string) {
+	if stepKey != g.first {
+		<-g.done
+	}
+}
+
+func (g *gateListener) AfterStep(_, stepKey, _, _ string, _ int64) {
+	if stepKey == g.first {
+		close(g.done)
+	}
+}
+
+func TestWhenAll3FirstErrorSkipsUnclaimedSteps(t *testing.T) {
+	store := newTestStore(t)
+	const workflowID = "wf-when-all-cancel"
+
+	boom := errors.New("boom")
+	var others atomic.Int32
+	ctx := NewContext(workflowID, store).WithListener(&gateListener{first: "a#000001", done: make(chan struct{})})
+	_, _, _, err := WhenAll3(ctx, "a", "b", "c",
+		func() (int, error) { return 0, boom },
+		func() (int, error) { others.Add(1); return 2, nil },
+		func() (int, error) { others.Add(1); return 3, nil },
+	)
+	if !errors.Is(err, boom) {
+		t.Fatalf("expected the first failure, got %v", err)
+	}
+	if others.Load() != 0 {
+		t.Fatalf("expected unclaimed steps to be skipped, %d ran", others.Load())
+	}
+	rows, err := store.ListSteps(workflowID)
+	if err != nil || len(rows) != 1 || rows[0].Status != statusFailed {
+		t.Fatalf("expected only the failed step to be recorded, got %+v err=%v", rows, err)
+	}
+
+	a, b, c, err := WhenAll3(NewContext(workflowID, store), "a", "b", "c",
+		func() (int, error) { return 1, nil },
+		func() (int, error) { return 2, nil },
+		func() (int, error) { return 3, nil },
+	)
+	if err != nil || a != 1 || b != 2 || c != 3 {
+		t.Fatalf("resume got %d %d %d err=%v", a, b, c, err)
+	}
+}
+
+func TestDeterministicCounterPinsStepKeys(t *testing.T) {
+	store := newTestStore(t)
+	const workflowID = "wf-deterministic-counter"
+
+	ctx := NewContextWithIDCounter(workflowID, store, DeterministicCounter(map[string]int{"charge": 7}))
+	if _, err := Step(ctx, "charge", func() (int, error) { return 1, nil }); err != nil {
+		t.Fatalf("step failed: %v", err)
+	}
+	if _, found, err := store.GetStep(workflowID, "charge#000007"); err != nil || !found {
+		t.Fatalf("expected pinned key charge#000007, found=%v err=%v", found, err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expected panic for unseeded step id")
+		}
+	}()
+	_, _ = Step(ctx, "refund", func() (int, error) { return 0, nil })
+}
+
+func TestBulkStepRunsOnlyUncachedIDs(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	const workflowID = "wf-bulk"
+
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	square := func(id string) (string, error) {
+		mu.Lock()
+		calls[id]++
+		mu.Unlock()
+		if id == "c" && calls[id] == 1 {
+			return "", errors.New("transient")
+		}
+		return id + id, nil
+	}
+
+	ids := []string{"a", "b", "c", "d"}
+	_, err := BulkStep(NewContext(workflowID, store).WithBulkConcurrency(2), "fanout", ids, square)
+	if err == nil {
+		t.Fatalf("expected first bulk run to fail on c")
+	}
+
+	got, err := BulkStep(NewContext(workflowID, store), "fanout", ids, square)
+	if err != nil {
+		t.Fatalf("resume bulk run failed: %v", err)
+	}
+	want := []string{"aa", "bb", "cc", "dd"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("result %d got=%q want=%q", i, got[i], want[i])
+		}
+	}
+	for _, id := range []string{"a", "b", "d"} {
+		if calls[id] != 1 {
+			t.Fatalf("expected %s to run once, ran %d times", id, calls[id])
+		}
+	}
+	if calls["c"] != 2 {
+		t.Fatalf("expected c to be retried once, ran %d times", calls["c"])
+	}
+}
+
+func TestMaxStepsStopsRunawayLoop(t *testing.T) {
+	store := newTestStore(t)
+	const workflowID = "wf-max-steps"
+
+	ctx := NewContext(workflowID, store).WithMaxSteps(3)
+	calls := 0
+	var err error
+	for i := 0; i < 10 && err == nil; i++ {
+		_, err = Step(ctx, "poll", func() (int, error) {
+			calls++
+			return calls, nil
+		})
+	}
+	if !errors.Is(err, ErrMaxStepsExceeded) {
+		t.Fatalf("expected ErrMaxStepsExceeded, got %v", err)
+	}
+	if calls != 3 {
+		t.Fatalf("expected 3 executions before the limit, got %d", calls)
+	}
+	rows, err := store.ListSteps(workflowID)
+	if err != nil {
+		t.Fatalf("list steps failed: %v", err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("expected no row for the rejected step, got %d rows", len(rows))
+	}
+}
+
+func TestMaxStepsCoversForks(t *testing.T) {
+	store := newTestStore(t)
+	const workflowID = "wf-max-steps-fork"
+
+	ctx := NewContext(workflowID, store).WithMaxSteps(3)
+	if _, err := Step(ctx, "load", func() (int, error) { return 1, nil }); err != nil {
+		t.Fatalf("parent step failed: %v", err)
+	}
+	var err error
+	calls := 0
+	for _, prefix := range []string{"billing", "shipping"} {
+		child := ctx.Fork(prefix)
+		if _, err = Step(child, "charge", func() (int, error) {
+			calls++
+			return calls, nil
+		}); err != nil {
+			break
+		}
+		if _, err = Step(child, "notify", func() (int, error) {
+			calls++
+			return calls, nil
+		}); err != nil {
+			break
+		}
+	}
+	if !errors.Is(err, ErrMaxStepsExceeded) {
+		t.Fatalf("expected forks to share the limit, got %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected 2 forked executions before the limit, got %d", calls)
+	}
+	if _, err := Step(ctx, "finish", func() (int, error) { return 0, nil }); !errors.Is(err, ErrMaxStepsExceeded) {
+		t.Fatalf("expected the parent to be over the limit too, got %v", err)
+	}
+}
+
+func TestMaxStepsCountsOnlyExecutionsAndResumes(t *testing.T) {
+	store := newTestStore(t)
+	const workflowID = "wf-max-steps-resume"
+
+	calls := 0
+	run := func(ctx *Context) error {
+		for i := 0; i < 20; i++ {
+			if _, err := Step(ctx, "work", func() (int, error) {
+				calls++
+				return i, nil
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := run(NewContext(workflowID, store).WithMaxSteps(5)); !errors.Is(err, ErrMaxStepsExceeded) {
+		t.Fatalf("expected ErrMaxStepsExceeded, got %v", err)
+	}
+	rows, err := store.ListSteps(workflowID)
+	if err != nil {
+		t.Fatalf("list steps failed: %v", err)
+	}
+	if len(rows) != 5 || calls != 5 {
+		t.Fatalf("expected 5 steps written and executed, got %d rows and %d calls", len(rows), calls)
+	}
+
+	// Replays are free: a second limited run executes five more.
+	if err := run(NewContext(workflowID, store).WithMaxSteps(5)); !errors.Is(err, ErrMaxStepsExceeded) {
+		t.Fatalf("expected ErrMaxStepsExceeded on second run, got %v", err)
+	}
+	if calls != 10 {
+		t.Fatalf("expected cached steps not to count against the limit, got %d calls", calls)
+	}
+
+	if err := run(NewContext(workflowID, store)); err != nil {
+		t.Fatalf("resume without limit failed: %v", err)
+	}
+	rows, err = store.ListSteps(workflowID)
+	if err != nil {
+		t.Fatalf("list steps failed: %v", err)
+	}
+	if len(rows) != 20 || calls != 20 {
+		t.Fatalf("expected all 20 steps to complete once, got %d rows and %d calls", len(rows), calls)
+	}
+	for _, row := range rows {
+		if row.Status != statusCompleted {
+			t.Fatalf("expected %s completed, got %s", row.StepKey, row.Status)
+		}
+	}
+}
+
+func TestNewContextFromExistingContinuesSequences(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	const workflowID = "wf-handoff"
+
+	first := NewContext(workflowID, store)
+	for i := 0; i < 3; i++ {
+		if _, err := Step(first, "page", func() (int, error) { return i, nil }); err != nil {
+			t.Fatalf("first worker step %d failed: %v", i, err)
+		}
+	}
+
+	counters, err := store.LoadStepCounters(workflowID)
+	if err != nil {
+		t.Fatalf("load counters failed: %v", err)
+	}
+	next := NewContextFromExisting(workflowID, first.RunID, counters, store)
+	if next.RunID != first.RunID {
+		t.Fatalf("expected run id to be preserved")
+	}
+	if _, err := Step(next, "page", func() (int, error) { return 3, nil }); err != nil {
+		t.Fatalf("hand-off step failed: %v", err)
+	}
+	if _, found, err := store.GetStep(workflowID, "page#000004"); err != nil || !found {
+		t.Fatalf("expected hand-off step to continue at page#000004, found=%v err=%v", found, err)
+	}
+}
+
+func TestSpanIDChangesWithEachStep(t *testing.T) {
+	store := newTestStore(t)
+	const workflowID = "wf-span"
+
+	collect := func() []string {
+		ctx := NewContext(workflowID, store)
+		ids := []string{ctx.SpanID()}
+		for i := 0; i < 3; i++ {
+			_, err := Step(ctx, "call", func() (string, error) {
+				return ctx.SpanID(), nil
+			})
+			if err != nil {
+				t.Fatalf("step %d failed: %v", i, err)
+			}
+			ids = append(ids, ctx.SpanID())
+		}
+		return ids
+	}
+
+	first := collect()
+	for i, id := range first {
+		if len(id) != 16 {
+			t.Fatalf("expected 16 hex chars, got %q", id)
+		}
+		if i > 0 && (id[:8] != first[0][:8] || id <= first[i-1]) {
+			t.Fatalf("expected span ids to share the workflow prefix and increase: %v", first)
+		}
+	}
+
+	replay := collect()
+	for i := range first {
+		if first[i] != replay[i] {
+			t.Fatalf("span ids differ on replay at %d: %s vs %s", i, first[i], replay[i])
+		}
+	}
+}
+
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []string
+	errs  []error
+}
+
+func (r *recordingTracer) StartSpan(goCtx context.Context, name string, attrs map[string]string) (context.Context, func(error)) {
+	return goCtx, func(err error) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.spans = append(r.spans, name+":"+attrs["step.key"])
+		r.errs = append(r.errs, err)
+	}
+}
+
+func TestTracerWrapsEachStep(t *testing.T) {
+	store := newTestStore(t)
+	tracer := &recordingTracer{}
+
+	ctx := NewContext("wf-tracer", store).WithTracer(tracer)
+	if _, err := Step(ctx, "ok", func() (int, error) { return 1, nil }); err != nil {
+		t.Fatalf("ok step failed: %v", err)
+	}
+	if _, err := Step(ctx, "bad", func() (int, error) { return 0, errors.New("boom") }); err == nil {
+		t.Fatalf("expected bad step to fail")
+	}
+
+	want := []string{"durable.step:ok#000001", "durable.step:bad#000001"}
+	if len(tracer.spans) != 2 || tracer.spans[0] != want[0] || tracer.spans[1] != want[1] {
+		t.Fatalf("unexpected spans: %v", tracer.spans)
+	}
+	if tracer.errs[0] != nil || tracer.errs[1] == nil {
+		t.Fatalf("expected span outcomes nil then error, got %v", tracer.errs)
+	}
+}
+
+func TestContextStoreOptionsCacheAndOutputLimit(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	const workflowID = "wf-store-options"
+
+	seed := NewContext(workflowID, store)
+	if _, err := Step(seed, "load", func() (string, error) { return "seeded", nil }); err != nil {
+		t.Fatalf("seed step failed: %v", err)
+	}
+
+	ctx := NewContextWithStoreOptions(workflowID, store, ContextStoreOptions{ReadFromCache: true, MaxOutputBytes: 16})
+	// With the checkpoint preloaded the replay must not need the row anymore.
+	if err := store.execWrite("DELETE FROM steps WHERE workflow_id='wf-store-options';"); err != nil {
+		t.Fatalf("delete rows failed: %v", err)
+	}
+	got, err := Step(ctx, "load", func() (string, error) { return "re-executed", nil })
+	if err != nil {
+		t.Fatalf("cached step failed: %v", err)
+	}
+	if got != "seeded" {
+		t.Fatalf("expected cached output, got %q", got)
+	}
+
+	if _, err := Step(ctx, "big", func() (string, error) { return strings.Repeat("x", 32), nil }); err == nil {
+		t.Fatalf("expected output over MaxOutputBytes to fail")
+	}
+	record, found, err := store.GetStep(workflowID, "big#000001")
+	if err != nil || !found || record.Status != statusFailed {
+		t.Fatalf("expected oversized step to be marked failed: %+v found=%v err=%v", record, found, err)
+	}
+}
+
+func TestStepMapPreservesAllKeys(t *testing.T) {
+	store := newTestStore(t)
+	const workflowID = "wf-step-map"
+
+	keys := []int{3, 1, 4, 1, 5}
+	var mu sync.Mutex
+	calls := make(map[int]int)
+	square := func(crashOn int) func(*Context, int) (int, error) {
+		return func(_ *Context, k int) (int, error) {
+			mu.Lock()
+			calls[k]++
+			mu.Unlock()
+			if k == crashOn {
+				return 0, errors.New("simulated crash")
+			}
+			return k * k, nil
+		}
+	}
+
+	if _, err := StepMap(NewContext(workflowID, store), "square", keys, square(4)); err == nil {
+		t.Fatalf("expected first run to fail")
+	}
+	got, err := StepMap(NewContext(workflowID, store), "square", keys, square(-1))
+	if err != nil {
+		t.Fatalf("resume failed: %v", err)
+	}
+	want := map[int]int{1: 1, 3: 9, 4: 16, 5: 25}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d keys, got %v", len(want), got)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Fatalf("key %d: expected %d, got %d", k, v, got[k])
+		}
+	}
+	if calls[4] != 2 || calls[3] != 1 || calls[1] != 1 || calls[5] != 1 {
+		t.Fatalf("expected only the failed key to re-run, calls=%v", calls)
+	}
+}
+
+func TestStepMapRejectsKeysThatCollideAfterSanitising(t *testing.T) {
+	store := newTestStore(t)
+	calls := 0
+	_, err := StepMap(NewContext("wf-step-map-collide", store), "user", []string{"Alice", "bob", "alice"}, func(_ *Context, k string) (string, error) {
+		calls++
+		return k, nil
+	})
+	if err == nil || !strings.Contains(err.Error(), `"user_alice"`) {
+		t.Fatalf("expected a collision error, got %v", err)
+	}
+	if calls != 0 {
+		t.Fatalf("expected no key to run, ran %d", calls)
+	}
+}
+
+func TestRetryStopsAtMaxAttempts(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+
+	transient := errors.New("503 from upstream")
+	calls := 0
+	err := Retry(policy, func() error {
+		calls++
+		return &RetryableError{Err: transient}
+	})
+	if !errors.Is(err, transient) || calls != 3 {
+		t.Fatalf("expected 3 attempts ending in the transient error, calls=%d err=%v", calls, err)
+	}
+
+	calls = 0
+	permanent := errors.New("400 bad request")
+	if err := Retry(policy, func() error {
+		calls++
+		return permanent
+	}); !errors.Is(err, permanent) || calls != 1 {
+		t.Fatalf("expected non-retryable error to stop immediately, calls=%d err=%v", calls, err)
+	}
+
+	calls = 0
+	if err := Retry(policy, func() error {
+		calls++
+		if calls < 2 {
+			return &RetryableError{Err: transient}
+		}
+		return nil
+	}); err != nil || calls != 2 {
+		t.Fatalf("expected success on second attempt, calls=%d err=%v", calls, err)
+	}
+}
+
+func TestLoopProgressCountsIterations(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	ctx := NewContext("wf-loop-progress", store)
+
+	completed, total, err := LoopProgress(ctx, "import")
+	if err != nil || completed != 0 || total != 0 {
+		t.Fatalf("expected 0/0 before the loop starts, got %d/%d err=%v", completed, total, err)
+	}
+
+	rows := []string{"a", "b", "c", ""}
+	for i, row := range rows {
+		_, err := Step(ctx, "import", func() (string, error) {
+			if row == "" {
+				return "", errors.New("bad row")
+			}
+			return row, nil
+		})
+		if err != nil && i < 3 {
+			t.Fatalf("iteration %d failed: %v", i, err)
+		}
+	}
+	// Neither a step sharing the prefix nor the same id in a fork counts.
+	if _, err := Step(ctx, "import_summary", func() (int, error) { return 0, nil }); err != nil {
+		t.Fatalf("summary step failed: %v", err)
+	}
+	if _, err := Step(ctx.Fork("other"), "import", func() (int, error) { return 0, nil }); err != nil {
+		t.Fatalf("forked step failed: %v", err)
+	}
+
+	completed, total, err = LoopProgress(ctx, "import")
+	if err != nil {
+		t.Fatalf("progress failed: %v", err)
+	}
+	if completed != 3 || total != 4 {
+		t.Fatalf("expected 3/4 iterations, got %d/%d", completed, total)
+	}
+}
+
+func TestCorruptedChecksumIsDetected(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	const workflowID = "wf-checksum"
+
+	if _, err := Step(NewContext(workflowID, store), "charge", func() (int, error) { return 100, nil }); err != nil {
+		t.Fatalf("seed step failed: %v", err)
+	}
+	row, _, err := store.GetStep(workflowID, "charge#000001")
+	if err != nil {
+		t.Fatalf("load row failed: %v", err)
+	}
+	if row.OutputChecksum != outputChecksum("100") {
+		t.Fatalf("unexpected checksum %q", row.OutputChecksum)
+	}
+
+	// Still valid JSON, so only the checksum can catch it.
+	if err := store.execWrite("UPDATE steps SET output_json='1' WHERE workflow_id='wf-checksum';"); err != nil {
+		t.Fatalf("tamper failed: %v", err)
+	}
+	if _, err := Step(NewContext(workflowID, store), "charge", func() (int, error) { return 100, nil }); !errors.Is(err, ErrOutputCorrupted) {
+		t.Fatalf("expected ErrOutputCorrupted, got %v", err)
+	}
+
+	if err := store.execWrite("UPDATE steps SET output_checksum=NULL WHERE workflow_id='wf-checksum';"); err != nil {
+		t.Fatalf("clear checksum failed: %v", err)
+	}
+	got, err := Step(NewContext(workflowID, store), "charge", func() (int, error) { return 100, nil })
+	if err != nil || got != 1 {
+		t.Fatalf("expected legacy row without checksum to be trusted, got %d err=%v", got, err)
+	}
+}
+
+func TestStepGroupCancelStopsInFlightWork(t *testing.T) {
+	store := newTestStore(t)
+	const workflowID = "wf-group-cancel"
+
+	boom := errors.New("quota exceeded")
+	start := time.Now()
+	_, err := StepGroupWithCancel(NewContext(workflowID, store), context.Background(), "upload", []int{0, 1, 2},
+		func(goCtx context.Context, n int) (int, error) {
+			if n == 0 {
+				return 0, boom
+			}
+			select {
+			case <-goCtx.Done():
+				return 0, goCtx.Err()
+			case <-time.After(5 * time.Second):
+				return n, nil
+			}
+		})
+	if !errors.Is(err, boom) {
+		t.Fatalf("expected the first failure to be returned, got %v", err)
+	}
+	// The group waits for every goroutine, so returning early means the
+	// sleeping steps observed the cancellation.
+	if elapsed := time.Since(start); elapsed > 4*time.Second {
+		t.Fatalf("expected in-flight steps to be cancelled, group took %v", elapsed)
+	}
+
+	got, err := StepGroupWithCancel(NewContext(workflowID, store), context.Background(), "upload", []int{0, 1, 2},
+		func(_ context.Context, n int) (int, error) { return n * 10, nil })
+	if err != nil {
+		t.Fatalf("resume failed: %v", err)
+	}
+	if len(got) != 3 || got[0] != 0 || got[1] != 10 || got[2] != 20 {
+		t.Fatalf("unexpected outputs after resume: %v", got)
+	}
+}
+
+func TestPipeline3ResumesFromIntermediateOutput(t *testing.T) {
+	store := newTestStore(t)
+	const workflowID = "wf-pipeline"
+
+	parseCalls := 0
+	parse := func(raw string) (int, error) {
+		parseCalls++
+		return len(raw), nil
+	}
+	failing := func(n int) (string, error) { return "", errors.New("renderer down") }
+	render := func(n int) (string, error) { return fmt.Sprintf("len=%d", n), nil }
+
+	if _, _, err := Pipeline3(NewContext(workflowID, store), "parse", "render", "hello", parse, failing); err == nil {
+		t.Fatalf("expected second stage to fail")
+	}
+	b, c, err := Pipeline3(NewContext(workflowID, store), "parse", "render", "hello", parse, render)
+	if err != nil {
+		t.Fatalf("resume failed: %v", err)
+	}
+	if b != 5 || c != "len=5" || parseCalls != 1 {
+		t.Fatalf("unexpected pipeline results b=%d c=%q parseCalls=%d", b, c, parseCalls)
+	}
+
+	got, err := Pipeline2(NewContext("wf-pipeline-2", store), "double", 4, func(n int) (int, error) { return n * 2, nil })
+	if err != nil || got != 8 {
+		t.Fatalf("unexpected Pipeline2 result %d err=%v", got, err)
+	}
+}
+
+func TestSchemaVersionMigratesLegacyOutput(t *testing.T) {
+	store := newTestStore(t)
+	const workflowID = "wf-schema-version"
+
+	type addressV2 struct {
+		Street string `json:"street"`
+		City   string `json:"city"`
+	}
+
+	// Version 1 stored the address as a single string.
+	if _, err := StepWithSchemaVersion(NewContext(workflowID, store), "address", 1,
+		func(int, json.RawMessage) (string, error) { return "", errors.New("unused") },
+		func() (string, error) { return "1 Main St, Springfield", nil },
+	); err != nil {
+		t.Fatalf("v1 step failed: %v", err)
+	}
+
+	migrations, executions := 0, 0
+	migrate := func(from int, raw json.RawMessage) (addressV2, error) {
+		migrations++
+		var legacy string
+		if from != 1 {
+			return addressV2{}, fmt.Errorf("unexpected version %d", from)
+		}
+		if err := json.Unmarshal(raw, &legacy); err != nil {
+			return addressV2{}, err
+		}
+		street, city, _ := strings.Cut(legacy, ", ")
+		return addressV2{Street: street, City: city}, nil
+	}
+	fetch := func() (addressV2, error) {
+		executions++
+		return addressV2{Street: "fresh", City: "fresh"}, nil
+	}
+
+	for i := 0; i < 2; i++ {
+		got, err := StepWithSchemaVersion(NewContext(workflowID, store), "address", 2, migrate, fetch)
+		if err != nil {
+			t.Fatalf("v2 run %d failed: %v", i, err)
+		}
+		if got != (addressV2{Street: "1 Main St", City: "Springfield"}) {
+			t.Fatalf("unexpected migrated output: %+v", got)
+		}
+	}
+	if migrations != 1 || executions != 0 {
+		t.Fatalf("expected a single migration and no re-execution, migrations=%d executions=%d", migrations, executions)
+	}
+
+	// A failing migration falls back to re-running fn.
+	got, err := StepWithSchemaVersion(NewContext(workflowID, store), "address", 3,
+		func(int, json.RawMessage) (addressV2, error) { return addressV2{}, errors.New("cannot migrate") },
+		fetch,
+	)
+	if err != nil || executions != 1 || got.Street != "fresh" {
+		t.Fatalf("expected fallback execution, got %+v executions=%d err=%v", got, executions, err)
+	}
+	row, _, _ := store.GetStep(workflowID, "address#000001")
+	if meta, _ := decodeStepMetadata(row); meta["schema_version"] != float64(3) || row.OutputJSON != `{"street":"fresh","city":"fresh"}` {
+		t.Fatalf("expected output and version 3 checkpointed together, got %+v", row)
+	}
+
+	// A failing fallback marks the step failed so the next run re-executes it.
+	_, err = StepWithSchemaVersion(NewContext(workflowID, store), "address", 4,
+		func(int, json.RawMessage) (addressV2, error) { return addressV2{}, errors.New("cannot migrate") },
+		func() (addressV2, error) { return addressV2{}, errors.New("geocoder down") },
+	)
+	if err == nil {
+		t.Fatalf("expected fallback failure")
+	}
+	if row, _, _ := store.GetStep(workflowID, "address#000001"); row.Status != statusFailed {
+		t.Fatalf("expected failed step after fallback failure, got %+v", row)
+	}
+	got, err = StepWithSchemaVersion(NewContext(workflowID, store), "address", 4, migrate, fetch)
+	if err != nil || executions != 2 || got.Street != "fresh" {
+		t.Fatalf("expected re-execution after failure, got %+v executions=%d err=%v", got, executions, err)
+	}
+}
+
+func TestBatchExecuteCommitsTogether(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	const workflowID = "wf-batch"
+
+	calls := 0
+	var mu sync.Mutex
+	run := func(failCharge bool) (*BatchFuture[string], *BatchFuture[int], error) {
+		b := NewBatch(NewContext(workflowID, store))
+		user := BatchAdd(b, "create_user", func() (string, error) {
+			mu.Lock()
+			calls++
+			mu.Unlock()
+			return "user-1", nil
+		})
+		charge := BatchAdd(b, "charge", func() (int, error) {
+			if failCharge {
+				return 0, errors.New("card declined")
+			}
+			return 42, nil
+		})
+		if _, err := user.Result(); err == nil {
+			t.Fatalf("expected Result before Execute to fail")
+		}
+		return user, charge, b.Execute()
+	}
+
+	user, charge, err := run(true)
+	if err == nil {
+		t.Fatalf("expected batch with failing step to fail")
+	}
+	if got, err := user.Result(); err != nil || got != "user-1" {
+		t.Fatalf("expected successful sibling to be committed, got %q err=%v", got, err)
+	}
+	if _, err := charge.Result(); err == nil {
+		t.Fatalf("expected failed future to report its error")
+	}
+
+	user, charge, err = run(false)
+	if err != nil {
+		t.Fatalf("resume failed: %v", err)
+	}
+	u, _ := user.Result()
+	c, _ := charge.Result()
+	if u != "user-1" || c != 42 || calls != 1 {
+		t.Fatalf("unexpected resume results user=%q charge=%d calls=%d", u, c, calls)
+	}
+}
+
+func TestStepWithCallbackCheckpointsAfterCallback(t *testing.T) {
+	store := newTestStore(t)
+	const workflowID = "wf-callback"
+
+	var (
+		got      string
+		statusAt string
+		fnCalls  int
+	)
+	done, err := StepWithCallback(NewContext(workflowID, store), "notify", func() (string, error) {
+		fnCalls++
+		return "sent", nil
+	}, func(out string, err error) {
+		got = out
+		row, _, _ := store.GetStep(workflowID, "notify#000001")
+		statusAt = row.Status
+	})
+	if err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	<-done
+
+	if got != "sent" || statusAt != statusRunning {
+		t.Fatalf("expected callback with output before checkpoint, got %q while status=%s", got, statusAt)
+	}
+	row, _, err := store.GetStep(workflowID, "notify#000001")
+	if err != nil || row.Status != statusCompleted {
+		t.Fatalf("expected completed step after done, got %+v err=%v", row, err)
+	}
+
+	cbCalls := 0
+	done, err = StepWithCallback(NewContext(workflowID, store), "notify", func() (string, error) {
+		fnCalls++
+		return "again", nil
+	}, func(string, error) { cbCalls++ })
+	if err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	<-done
+	if fnCalls != 1 || cbCalls != 0 {
+		t.Fatalf("expected replay to skip fn and cb, fnCalls=%d cbCalls=%d", fnCalls, cbCalls)
+	}
+}
+
+func TestScheduledStepSkipsWaitOnResume(t *testing.T) {
+	store := newTestStore(t)
+	const workflowID = "wf-scheduled"
+
+	// A previous run scheduled the step for a time that has since passed and
+	// crashed while waiting.
+	crashed := NewContext(workflowID, store)
+	pastDue := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339Nano)
+	if err := Checkpoint(crashed, "nightly_report_fire_1", pastDue); err != nil {
+		t.Fatalf("seed fire time failed: %v", err)
+	}
+
+	start := time.Now()
+	// Yearly schedule: without the stored fire time this would wait months.
+	got, err := ScheduledStep(NewContext(workflowID, store), "nightly_report", "0 0 1 1 *", func() (string, error) {
+		return "report", nil
+	})
+	if err != nil {
+		t.Fatalf("resume failed: %v", err)
+	}
+	if got != "report" || time.Since(start) > 5*time.Second {
+		t.Fatalf("expected immediate execution, got %q after %v", got, time.Since(start))
+	}
+
+	row, _, err := store.GetStep(workflowID, "nightly_report#000001")
+	if err != nil || row.Status != statusCompleted {
+		t.Fatalf("expected completed step, got %+v err=%v", row, err)
+	}
+	kept, _, err := ReadCheckpoint[string](NewContext(workflowID, store), "nightly_report_fire_1")
+	if err != nil || kept != pastDue {
+		t.Fatalf("expected original fire time to be kept, got %q err=%v", kept, err)
+	}
+	if meta, err := decodeStepMetadata(row); err != nil || meta["next_fire_at"] != pastDue {
+		t.Fatalf("expected fire time in step metadata, got %v err=%v", meta, err)
+	}
+
+	if _, err := ScheduledStep(NewContext(workflowID, store), "bad", "not a cron", func() (int, error) { return 0, nil }); err == nil {
+		t.Fatalf("expected invalid cron expression to fail")
+	}
+}
+
+func TestScheduledStepWaitEndsOnCancel(t *testing.T) {
+	store := newTestStore(t)
+	const workflowID = "wf-scheduled-cancel"
+
+	ctx, cancel := NewContextWithCancel(workflowID, store)
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := ScheduledStep(ctx, "nightly_report", "0 0 1 1 *", func() (string, error) {
+		t.Errorf("fn must not run before the fire time")
+		return "", nil
+	})
+	if !errors.Is(err, context.Canceled) || time.Since(start) > 5*time.Second {
+		t.Fatalf("expected the wait to end on cancel, got %v after %v", err, time.Since(start))
+	}
+	// The step was never claimed, so nothing is left running.
+	if _, found, _ := store.GetStep(workflowID, "nightly_report#000001"); found {
+		t.Fatalf("expected no claim while waiting for the fire time")
+	}
+	if _, found, _ := ReadCheckpoint[string](NewContext(workflowID, store), "nightly_report_fire_1"); !found {
+		t.Fatalf("expected the fire time to be checkpointed before waiting")
+	}
+}
+
+func TestStepWithOptionsFallsBackOnStoreError(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	const workflowID = "wf-fallback"
+
+	if err := store.execWrite("DROP TABLE steps;"); err != nil {
+		t.Fatalf("drop steps failed: %v", err)
+	}
+
+	if _, err := StepWithOptions(NewContext(workflowID, store), "lookup", StepOptions{}, func() (int, error) { return 1, nil }); err == nil {
+		t.Fatalf("expected store error without fallback")
+	}
+	got, err := StepWithOptions(NewContext(workflowID, store), "lookup", StepOptions{FallbackOnStoreError: true}, func() (int, error) { return 7, nil })
+	if err != nil || got != 7 {
+		t.Fatalf("expected in-memory fallback result, got %d err=%v", got, err)
+	}
+
+	// Errors about the step itself are not store errors and never fall back.
+	ok := newSQLiteTestStore(t)
+	ctx := NewContext(workflowID, ok).WithMaxSteps(1)
+	opts := StepOptions{FallbackOnStoreError: true}
+	if _, err := StepWithOptions(ctx, "a", opts, func() (int, error) { return 1, nil }); err != nil {
+		t.Fatalf("first step failed: %v", err)
+	}
+	if _, err := StepWithOptions(ctx, "b", opts, func() (int, error) { return 2, nil }); !errors.Is(err, ErrMaxStepsExceeded) {
+		t.Fatalf("expected ErrMaxStepsExceeded, got %v", err)
+	}
+}
+
+type invoiceTotal struct {
+	Cents int `json:"cents"`
+}
+
+func TestCachedInterfaceOutputMismatchErrors(t *testing.T) {
+	store := newTestStore(t)
+	const workflowID = "wf-interface-output"
+
+	run := func(id string, fn func() (any, error)) (any, error) {
+		return Step(NewContext(workflowID, store), id, fn)
+	}
+
+	first, err := run("total", func() (any, error) { return invoiceTotal{Cents: 995}, nil })
+	if err != nil {
+		t.Fatalf("first run failed: %v", err)
+	}
+	if _, ok := first.(invoiceTotal); !ok {
+		t.Fatalf("expected live result to keep its type, got %T", first)
+	}
+	row, _, _ := store.GetStep(workflowID, "total#000001")
+	if meta, _ := decodeStepMetadata(row); row.Status != statusCompleted || meta["output_type"] != "engine.invoiceTotal" {
+		t.Fatalf("expected output type stored with the checkpoint, got %+v", row)
+	}
+	// Replaying would hand back a map[string]any where callers expect invoiceTotal.
+	if _, err := run("total", func() (any, error) { return invoiceTotal{}, nil }); !errors.Is(err, ErrOutputTypeMismatch) {
+		t.Fatalf("expected ErrOutputTypeMismatch, got %v", err)
+	}
+
+	// JSON-native results survive the round trip and replay normally.
+	if _, err := run("label", func() (any, error) { return "paid", nil }); err != nil {
+		t.Fatalf("string step failed: %v", err)
+	}
+	got, err := run("label", func() (any, error) { return "unused", nil })
+	if err != nil || got != "paid" {
+		t.Fatalf("expected cached string, got %v err=%v", got, err)
+	}
+
+	// Non-empty interfaces cannot be decoded at all.
+	if _, err := Step(NewContext(workflowID, store), "stringer", func() (fmt.Stringer, error) { return time.Second, nil }); err != nil {
+		t.Fatalf("stringer step failed: %v", err)
+	}
+	if _, err := Step(NewContext(workflowID, store), "stringer", func() (fmt.Stringer, error) { return time.Second, nil }); !errors.Is(err, ErrOutputTypeMismatch) {
+		t.Fatalf("expected ErrOutputTypeMismatch for non-empty interface, got %v", err)
+	}
+}
+
+func TestReduceSumsLoopOutputs(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	const workflowID = "wf-reduce"
+
+	run := func() (int, int) {
+		ctx := NewContext(workflowID, store)
+		calls := 0
+		for i := 1; i <= 4; i++ {
+			if _, err := Step(ctx, "price", func() (int, error) { return i * 10, nil }); err != nil {
+				t.Fatalf("loop step %d failed: %v", i, err)
+			}
+		}
+		total, err := Reduce(ctx, "price", 0, func(acc, price int) (int, error) {
+			calls++
+			return acc + price, nil
+		})
+		if err != nil {
+			t.Fatalf("reduce failed: %v", err)
+		}
+		return total, calls
+	}
+
+	total, calls := run()
+	if total != 100 || calls != 4 {
+		t.Fatalf("expected 100 over 4 folds, got %d over %d", total, calls)
+	}
+	total, calls = run()
+	if total != 100 || calls != 0 {
+		t.Fatalf("expected replay to return checkpointed 100 without folding, got %d over %d", total, calls)
+	}
+}
+
+// interfaceOnlyBackend hides every *Store method outside StoreBackend.
+type interfaceOnlyBackend struct {
+	inner *Store
+	calls map[string]int
+}
+
+func (b *interfaceOnlyBackend) InitSchema() error { return b.inner.InitSchema() }
+
+func (b *interfaceOnlyBackend) GetStep(workflowID, stepKey string) (StepRecord, bool, error) {
+	b.calls["GetStep"]++
+	return b.inner.GetStep(workflowID, stepKey)
+}
+
+func (b *interfaceOnlyBackend) UpsertRunning(workflowID string, ref StepRef, runID string) error {
+	b.calls["UpsertRunning"]++
+	return b.inner.UpsertRunning(workflowID, ref, runID)
+}
+
+func (b *interfaceOnlyBackend) MarkCompleted(workflowID, stepKey, runID, outputJSON string) error {
+	b.calls["MarkCompleted"]++
+	return b.inner.MarkCompleted(workflowID, stepKey, runID, outputJSON)
+}
+
+func (b *interfaceOnlyBackend) MarkFailed(workflowID, stepKey, runID, errText string) error {
+	b.calls["MarkFailed"]++
+	return b.inner.MarkFailed(workflowID, stepKey, runID, errText)
+}
+
+func (b *interfaceOnlyBackend) ListSteps(workflowID string) ([]StepRecord, error) {
+	b.calls["ListSteps"]++
+	return b.inner.ListSteps(workflowID)
+}
+
+func TestStepRunsAgainstStoreBackendInterface(t *testing.T) {
+	backend := &interfaceOnlyBackend{inner: newSQLiteTestStore(t), calls: make(map[string]int)}
+
+	executions := 0
+	workflow := func(ctx *Context) error {
+		v, err := Step(ctx, "charge", func() (int, error) {
+			executions++
+			return 42, nil
+		})
+		if err != nil {
+			return err
+		}
+		if v != 42 {
+			return fmt.Errorf("unexpected value %d", v)
+		}
+		return nil
+	}
+	for i := 0; i < 2; i++ {
+		if err := RunWorkflow(backend, "wf-backend", workflow); err != nil {
+			t.Fatalf("run %d failed: %v", i, err)
+		}
+	}
+	if executions != 1 {
+		t.Fatalf("expected replay through the interface, executed %d times", executions)
+	}
+	if backend.calls["UpsertRunning"] != 1 || backend.calls["MarkCompleted"] != 1 || backend.calls["GetStep"] != 2 {
+		t.Fatalf("unexpected backend calls: %v", backend.calls)
+	}
+
+	ctx := NewContext("wf-backend", backend)
+	if _, _, err := LoopProgress(ctx, "charge"); !errors.Is(err, ErrUnsupportedBackend) {
+		t.Fatalf("expected ErrUnsupportedBackend for SQL-only feature, got %v", err)
+	}
+}
+
+func TestStepWithContextLeavesStepRunningWhenCancelledBeforeCheckpoint(t *testing.T) {
+	store := newTestStore(t)
+	const workflowID = "wf-step-context"
+
+	type ctxKey struct{}
+	goCtx, cancel := context.WithCancel(context.WithValue(context.Background(), ctxKey{}, "req-7"))
+	_, err := StepWithContext(goCtx, NewContext(workflowID, store), "charge", func(c context.Context) (int, error) {
+		if c.Value(ctxKey{}) != "req-7" {
+			t.Errorf("step did not receive the caller's context")
+		}
+		cancel() // cancelled after the work is done but before the checkpoint
+		return 100, nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	row, found, err := store.GetStep(workflowID, "charge#000001")
+	if err != nil || !found || row.Status != statusRunning || row.OutputJSON != "" {
+		t.Fatalf("expected step left running without output, got %+v found=%v err=%v", row, found, err)
+	}
+
+	calls := 0
+	v, err := StepWithContext(context.Background(), NewContext(workflowID, store), "charge", func(context.Context) (int, error) {
+		calls++
+		return 100, nil
+	})
+	if err != nil || v != 100 || calls != 1 {
+		t.Fatalf("expected resume to re-execute the step, got v=%d calls=%d err=%v", v, calls, err)
+	}
+
+	done, stop := context.WithCancel(context.Background())
+	stop()
+	if _, err := StepWithContext(done, NewContext(workflowID, store), "refund", func(context.Context) (int, error) {
+		t.Fatalf("step must not run with a cancelled context")
+		return 0, nil
+	}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled before claiming, got %v", err)
+	}
+	if _, found, _ := store.GetStep(workflowID, "refund#000001"); found {
+		t.Fatalf("expected no claim for a cancelled context")
+	}
+}
+
+func TestStepWithRetryPersistsAttempts(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	const workflowID = "wf-step-retry"
+
+	ctx := NewContext(workflowID, store).WithRetryBackoff(time.Millisecond, 2*time.Millisecond)
+	calls := 0
+	v, err := StepWithRetry(ctx, "charge", 3, func() (int, error) {
+		calls++
+		if calls == 2 {
+			history, _ := store.GetStepHistory(workflowID, "charge#000001")
+			last := history[len(history)-2]
+			if last.Status != statusRunning || !strings.Contains(last.ErrorText, "attempt 1/3") {
+				t.Errorf("expected the first attempt's error in history, got %+v", last)
+			}
+			if n, _ := store.GetStepAttemptCount(workflowID, "charge#000001"); n != 2 {
+				t.Errorf("expected the retry to be the second claim, got %d", n)
+			}
+			if row, _, _ := store.GetStep(workflowID, "charge#000001"); !strings.Contains(row.ErrorText, "attempt 1/3") {
+				t.Errorf("expected the running row to keep the first attempt's error, got %q", row.ErrorText)
+			}
+		}
+		if calls < 3 {
+			return 0, errors.New("gateway timeout")
+		}
+		return 7, nil
+	})
+	if err != nil || v != 7 || calls != 3 {
+		t.Fatalf("expected success on third attempt, got v=%d calls=%d err=%v", v, calls, err)
+	}
+	row, _, _ := store.GetStep(workflowID, "charge#000001")
+	if n, _ := store.GetStepAttemptCount(workflowID, "charge#000001"); row.Status != statusCompleted || n != 3 {
+		t.Fatalf("unexpected completed row after %d claims: %+v", n, row)
+	}
+
+	// A run that crashed after two failed attempts leaves one for the resume.
+	dead := NewContext(workflowID, store)
+	ref := dead.nextStepRef("refund")
+	for i := 0; i < 2; i++ {
+		if err := store.UpsertRunning(workflowID, ref, dead.RunID); err != nil {
+			t.Fatalf("seed claim failed: %v", err)
+		}
+	}
+	if err := store.RecordStepAttempt(workflowID, ref.StepKey, dead.RunID, "attempt 2/3: boom"); err != nil {
+		t.Fatalf("seed attempt failed: %v", err)
+	}
+	calls = 0
+	_, err = StepWithRetry(NewContext(workflowID, store).WithRetryBackoff(0, 0), "refund", 3, func() (int, error) {
+		calls++
+		return 0, errors.New("still down")
+	})
+	if err == nil || calls != 1 || !strings.Contains(err.Error(), "attempt 3/3") {
+		t.Fatalf("expected resume to make only the last attempt, calls=%d err=%v", calls, err)
+	}
+	row, _, _ = store.GetStep(workflowID, ref.StepKey)
+	if row.Status != statusFailed || row.RetryBase != 0 {
+		t.Fatalf("expected failed row with the first budget, got %+v", row)
+	}
+
+	// Re-running the failed step starts a fresh budget.
+	calls = 0
+	_, err = StepWithRetry(NewContext(workflowID, store).WithRetryBackoff(0, 0), "refund", 3, func() (int, error) {
+		calls++
+		return 0, errors.New("still down")
+	})
+	if err == nil || calls != 3 {
+		t.Fatalf("expected a fresh budget of 3 attempts, calls=%d err=%v", calls, err)
+	}
+	row, _, _ = store.GetStep(workflowID, ref.StepKey)
+	if row.RetryBase != 3 {
+		t.Fatalf("expected the second budget to start after 3 claims, got %+v", row)
+	}
+}
+
+func TestStepWithTimeoutFailsSlowStepAndResumes(t *testing.T) {
+	store := newTestStore(t)
+	const workflowID = "wf-step-timeout"
+
+	start := time.Now()
+	_, err := StepWithTimeout(NewContext(workflowID, store), "lookup", 50*time.Millisecond, func() (string, error) {
+		time.Sleep(200 * time.Millisecond)
+		return "late", nil
+	})
+	if !errors.Is(err, ErrStepTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Fatalf("expected to give up after ~50ms, took %s", elapsed)
+	}
+	row, _, _ := store.GetStep(workflowID, "lookup#000001")
+	if row.Status != statusFailed || !strings.Contains(row.ErrorText, "timed out") {
+		t.Fatalf("expected failed row with timeout text, got %+v", row)
+	}
+
+	v, err := StepWithTimeout(NewContext(workflowID, store), "lookup", time.Second, func() (string, error) {
+		return "fast", nil
+	})
+	if err != nil || v != "fast" {
+		t.Fatalf("expected resume to re-execute, got v=%q err=%v", v, err)
+	}
+}
+
+func TestSideEffectRecordsMarkerWithoutOutput(t *testing.T) {
+	store := newTestStore(t)
+	const workflowID = "wf-side-effect"
+
+	calls := 0
+	audit := func() error { calls++; return nil }
+	for i := 0; i < 2; i++ {
+		if err := SideEffect(NewContext(workflowID, store), "audit", audit); err != nil {
+			t.Fatalf("run %d failed: %v", i, err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("expected completed side effect to be skipped on resume, ran %d times", calls)
+	}
+	row, _, _ := store.GetStep(workflowID, "audit#000001")
+	if row.Status != statusCompleted || row.OutputJSON != "null" {
+		t.Fatalf("expected completed marker without output, got %+v", row)
+	}
+
+	err := SideEffect(NewContext(workflowID, store), "notify", func() error { return errors.New("smtp down") })
+	if err == nil {
+		t.Fatalf("expected side effect error")
+	}
+	if row, _, _ := store.GetStep(workflowID, "notify#000001"); row.Status != statusFailed {
+		t.Fatalf("expected failed side effect, got %+v", row)
+	}
+}
+
+func TestCheckpointRoundTripsAndOverwrites(t *testing.T) {
+	for name, backend := range map[string]StoreBackend{"sqlite": newTestStore(t), "memory": NewMemoryStore()} {
+		t.Run(name, func(t *testing.T) {
+			const workflowID = "wf-checkpoint"
+			ctx := NewContext(workflowID, backend)
+
+			if _, found, err := ReadCheckpoint[[]string](ctx, "seen"); found || err != nil {
+				t.Fatalf("expected no checkpoint yet, found=%v err=%v", found, err)
+			}
+			if err := Checkpoint(ctx, "seen", []string{"a"}); err != nil {
+				t.Fatalf("checkpoint failed: %v", err)
+			}
+			if err := Checkpoint(ctx, "seen", []string{"a", "b"}); err != nil {
+				t.Fatalf("overwrite failed: %v", err)
+			}
+
+			// A step with the same id keeps its own sequence numbering.
+			if _, err := Step(ctx, "seen_checkpoint", func() (int, error) { return 1, nil }); err != nil {
+				t.Fatalf("step failed: %v", err)
+			}
+
+			// Forks keep their own checkpoints under the same key.
+			if _, found, err := ReadCheckpoint[[]string](ctx.Fork("child"), "seen"); found || err != nil {
+				t.Fatalf("expected the fork to have no checkpoint, found=%v err=%v", found, err)
+			}
+			if err := Checkpoint(ctx.Fork("child"), "seen", []string{"c"}); err != nil {
+				t.Fatalf("fork checkpoint failed: %v", err)
+			}
+
+			got, found, err := ReadCheckpoint[[]string](NewContext(workflowID, backend), "seen")
+			if err != nil || !found || strings.Join(got, ",") != "a,b" {
+				t.Fatalf("expected latest checkpoint a,b, got %v found=%v err=%v", got, found, err)
+			}
+			got, found, err = ReadCheckpoint[[]string](NewContext(workflowID, backend).Fork("child"), "seen")
+			if err != nil || !found || strings.Join(got, ",") != "c" {
+				t.Fatalf("expected fork checkpoint c, got %v found=%v err=%v", got, found, err)
+			}
+			if store, ok := backend.(*Store); ok {
+				if problems, err := store.ValidateIntegrity(workflowID); err != nil || len(problems) != 0 {
+					t.Fatalf("expected checkpoint rows to pass integrity checks, got %v err=%v", problems, err)
+				}
+			}
+		})
+	}
+}
+
+func TestDurableSleepWaitsOnlyRemainingTime(t *testing.T) {
+	store := newTestStore(t)
+	const workflowID = "wf-durable-sleep"
+
+	start := time.Now()
+	if err := DurableSleep(NewContext(workflowID, store), "cool_down", 30*time.Millisecond); err != nil {
+		t.Fatalf("first sleep failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Fatalf("expected to sleep at least 30ms, slept %s", elapsed)
+	}
+
+	// Restart mid-sleep: the stored wake-up is 50ms away even though the
+	// call asks for an hour.
+	ctx := NewContext(workflowID, store)
+	if err := Checkpoint(ctx, "cool_down_sleep_1", time.Now().Add(50*time.Millisecond).UTC().Format(time.RFC3339Nano)); err != nil {
+		t.Fatalf("rewind wake-up failed: %v", err)
+	}
+	start = time.Now()
+	if err := DurableSleep(ctx, "cool_down", time.Hour); err != nil {
+		t.Fatalf("resumed sleep failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("expected to wait only the remaining ~50ms, waited %s", elapsed)
+	}
+
+	// Past the deadline the sleep returns at once.
+	start = time.Now()
+	if err := DurableSleep(NewContext(workflowID, store), "cool_down", time.Hour); err != nil {
+		t.Fatalf("expired sleep failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 20*time.Millisecond {
+		t.Fatalf("expected expired sleep to return immediately, took %s", elapsed)
+	}
+}
+
+func TestParallelMapCachesSuccessfulElements(t *testing.T) {
+	store := newTestStore(t)
+	const workflowID = "wf-parallel-map"
+
+	var calls sync.Map
+	run := func(failOn int) ([]int, error) {
+		return ParallelMap(NewContext(workflowID, store), "square", []int{1, 2, 3, 4}, func(_ *Context, n int) (int, error) {
+			v, _ := calls.LoadOrStore(n, new(int64))
+			atomic.AddInt64(v.(*int64), 1)
+			if n == failOn {
+				return 0, fmt.Errorf("element %d failed", n)
+			}
+			return n * n, nil
+		})
+	}
+
+	if _, err := run(3); err == nil || !strings.Contains(err.Error(), "element 3 failed") {
+		t.Fatalf("expected element 3 to fail, got %v", err)
+	}
+	out, err := run(0)
+	if err != nil {
+		t.Fatalf("second run failed: %v", err)
+	}
+	if fmt.Sprint(out) != "[1 4 9 16]" {
+		t.Fatalf("expected outputs in input order, got %v", out)
+	}
+	for n, want := range map[int]int64{1: 1, 2: 1, 3: 2, 4: 1} {
+		v, _ := calls.Load(n)
+		if got := atomic.LoadInt64(v.(*int64)); got != want {
+			t.Fatalf("element %d ran %d times, want %d", n, got, want)
+		}
+	}
+	if _, found, _ := store.GetStep(workflowID, "square_2#000001"); !found {
+		t.Fatalf("expected per-index step key square_2#000001")
+	}
+}
+
+func TestPipelineResumesAfterLastCompletedStage(t *testing.T) {
+	store := newTestStore(t)
+	const workflowID = "wf-pipeline-builder"
+
+	calls := map[string]int{}
+	publishFails := true
+	build := func(ctx *Context) *Pipeline[string, int] {
+		normalized := Then(NewPipeline[string](ctx), "normalize", func(s string) (string, error) {
+			calls["normalize"]++
+			return strings.TrimSpace(strings.ToLower(s)), nil
+		})
+		enriched := Then(normalized, "enrich", func(s string) ([]string, error) {
+			calls["enrich"]++
+			return strings.Fields(s), nil
+		})
+		return Then(enriched, "publish", func(words []string) (int, error) {
+			calls["publish"]++
+			if publishFails {
+				return 0, errors.New("broker unavailable")
+			}
+			return len(words), nil
+		})
+	}
+
+	if _, err := build(NewContext(workflowID, store)).Run("  Hello Durable World "); err == nil {
+		t.Fatalf("expected publish to fail")
+	}
+	publishFails = false
+	n, err := build(NewContext(workflowID, store)).Run("  Hello Durable World ")
+	if err != nil || n != 3 {
+		t.Fatalf("expected 3 published words, got %d err=%v", n, err)
+	}
+	if calls["normalize"] != 1 || calls["enrich"] != 1 || calls["publish"] != 2 {
+		t.Fatalf("expected resume from the publish stage, got %v", calls)
+	}
+
+	if _, err := Then(NewPipeline[int](NewContext(workflowID, store)), "", func(int) (int, error) { return 0, nil }).Run(1); err == nil {
+		t.Fatalf("expected empty stage id to be rejected")
+	}
+}
+
+func TestSagaCompensatesWithStoredOutputs(t *testing.T) {
+	store := newTestStore(t)
+	const workflowID = "wf-saga"
+
+	var undone []string
+	refundFails := true
+	courier, shipAttempts := false, 0
+	build := func(ctx *Context) *Saga {
+		saga := NewSaga(ctx)
+		AddSagaStep(saga, "reserve", func() (string, error) {
+			return "seat-12", nil
+		}, func(seat string) error {
+			undone = append(undone, "release "+seat)
+			return nil
+		})
+		AddSagaStep(saga, "charge", func() (int, error) {
+			return 4200, nil
+		}, func(cents int) error {
+			if refundFails {
+				return errors.New("refund api down")
+			}
+			undone = append(undone, fmt.Sprintf("refund %d", cents))
+			return nil
+		})
+		return AddSagaStep(saga, "ship", func() (string, error) {
+			shipAttempts++
+			if !courier {
+				return "", errors.New("no courier")
+			}
+			return "truck-1", nil
+		}, func(string) error {
+			undone = append(undone, "cancel shipment")
+			return nil
+		})
+	}
+
+	if err := build(NewContext(workflowID, store)).Execute(); err == nil || !strings.Contains(err.Error(), "refund api down") {
+		t.Fatalf("expected step and compensation errors, got %v", err)
+	}
+	if !reflect.DeepEqual(undone, []string{"release seat-12"}) {
+		t.Fatalf("expected every compensation to be attempted, got %v", undone)
+	}
+
+	// A courier is now available, but the saga already started rolling back
+	// and must not go forward again.
+	refundFails = false
+	courier = true
+	undone = nil
+	if err := build(NewContext(workflowID, store)).Execute(); err == nil || !strings.Contains(err.Error(), "no courier") {
+		t.Fatalf("expected ship failure, got %v", err)
+	}
+	if !reflect.DeepEqual(undone, []string{"refund 4200"}) {
+		t.Fatalf("expected only the pending refund to run on resume, got %v", undone)
+	}
+	if shipAttempts != 1 {
+		t.Fatalf("expected ship to run once, ran %d times", shipAttempts)
+	}
+}
+
+type recordingListener struct {
+	events []string
+}
+
+func (l *recordingListener) BeforeStep(workflowID, stepKey, runID string) {
+	l.events = append(l.events, "before "+stepKey)
+}
+
+func (l *recordingListener) AfterStep(workflowID, stepKey, runID, status string, durationMs int64) {
+	l.events = append(l.events, "after "+stepKey+" "+status)
+}
+
+type panickingListener struct{}
+
+func (panickingListener) BeforeStep(string, string, string) { panic("listener bug") }
+
+func (panickingListener) AfterStep(string, string, string, string, int64) {}
+
+func TestStepListenersAreStackedAndIsolated(t *testing.T) {
+	store := newTestStore(t)
+	first, second := &recordingListener{}, &recordingListener{}
+	ctx := NewContext("wf-listeners", store).
+		WithListener(first).
+		WithListener(panickingListener{}).
+		WithListener(second)
+
+	if _, err := Step(ctx, "fetch", func() (int, error) { return 1, nil }); err != nil {
+		t.Fatalf("step: %v", err)
+	}
+	if err := SideEffect(ctx, "notify", func() error { return errors.New("smtp down") }); err == nil {
+		t.Fatalf("expected side effect failure")
+	}
+
+	want := []string{"before fetch#000001", "after fetch#000001 completed", "before notify#000001", "after notify#000001 failed"}
+	for _, l := range []*recordingListener{first, second} {
+		if !reflect.DeepEqual(l.events, want) {
+			t.Fatalf("expected %v, got %v", want, l.events)
+		}
+	}
+
+	replay := &recordingListener{}
+	ctx = NewContext("wf-listeners", store).WithListener(replay)
+	if _, err := Step(ctx, "fetch", func() (int, error) { return 1, nil }); err != nil {
+		t.Fatalf("replayed step: %v", err)
+	}
+	if want := []string{"before fetch#000001", "after fetch#000001 cached"}; !reflect.DeepEqual(replay.events, want) {
+		t.Fatalf("expected %v, got %v", want, replay.events)
+	}
+}
+
+func TestZombieTakeoverLogsWarning(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	const workflowID = "wf-zombie-log"
+
+	var buf strings.Builder
+	store.WithLogger(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	oldCtx := NewContext(workflowID, store)
+	ref := oldCtx.nextStepRef("provision")
+	if err := store.UpsertRunning(workflowID, ref, oldCtx.RunID); err != nil {
+		t.Fatalf("seed running row failed: %v", err)
+	}
+	if _, err := Step(NewContext(workflowID, store), "provision", func() (int, error) { return 1, nil }); err != nil {
+		t.Fatalf("resume step failed: %v", err)
+	}
+
+	var takeover, completed map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var rec map[string]any
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("decode log line %q: %v", line, err)
+		}
+		switch rec["msg"] {
+		case "taking over zombie step":
+			takeover = rec
+		case "step completed":
+			completed = rec
+		}
+	}
+	if takeover["level"] != "WARN" || takeover["previous_run_id"] != oldCtx.RunID {
+		t.Fatalf("expected warn record for zombie takeover, got %v in %s", takeover, buf.String())
+	}
+	if completed["level"] != "INFO" || completed["step_key"] != ref.StepKey {
+		t.Fatalf("expected info record for completion, got %v", completed)
+	}
+}
+
+func TestContextWithLoggerOverridesStoreLogger(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	var storeBuf, ctxBuf strings.Builder
+	store.WithLogger(slog.New(slog.NewTextHandler(&storeBuf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	ctx := NewContext("wf-ctx-slog", store).WithLogger(slog.New(slog.NewTextHandler(&ctxBuf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	if _, err := Step(ctx, "fetch", func() (int, error) { return 1, nil }); err != nil {
+		t.Fatalf("step failed: %v", err)
+	}
+	if !strings.Contains(ctxBuf.String(), "step_key=fetch#000001") {
+		t.Fatalf("expected the step event in the context's logger, got %q", ctxBuf.String())
+	}
+	if strings.Contains(storeBuf.String(), "fetch#000001") {
+		t.Fatalf("expected the store's logger to be bypassed, got %q", storeBuf.String())
+	}
+}
+
+func TestCancelStopsLaterStepsButLetsInFlightStepFinish(t *testing.T) {
+	store := newTestStore(t)
+	const workflowID = "wf-cancel"
+
+	ctx, cancel := NewContextWithCancel(workflowID, store)
+	out, err := Step(ctx, "upload", func() (string, error) {
+		cancel()
+		<-ctx.Done()
+		return "uploaded", nil
+	})
+	if err != nil || out != "uploaded" {
+		t.Fatalf("expected in-flight step to finish, got %q err=%v", out, err)
+	}
+	if row, _, _ := store.GetStep(workflowID, "upload#000001"); row.Status != statusCompleted {
+		t.Fatalf("expected in-flight step to be checkpointed, got %+v", row)
+	}
+
+	ran := false
+	if _, err := Step(ctx, "publish", func() (int, error) { ran = true; return 1, nil }); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if ran {
+		t.Fatalf("expected cancelled step not to run")
+	}
+	if _, found, _ := store.GetStep(workflowID, "publish#000001"); found {
+		t.Fatalf("expected cancelled step not to be written")
+	}
+	if ctx.GoContext().Err() != context.Canceled {
+		t.Fatalf("expected GoContext to report cancellation")
+	}
+
+	resumed := NewContext(workflowID, store)
+	if _, err := Step(resumed, "upload", func() (string, error) { return "", errors.New("must replay") }); err != nil {
+		t.Fatalf("replay after cancel failed: %v", err)
+	}
+	if _, err := Step(resumed, "publish", func() (int, error) { return 1, nil }); err != nil {
+		t.Fatalf("resume after cancel failed: %v", err)
+	}
+}
+
+func TestForkNamespacesStepKeys(t *testing.T) {
+	store := newTestStore(t)
+	const workflowID = "wf-fork"
+
+	run := func(ctx *Context) error {
+		if _, err := Step(ctx, "load", func() (int, error) { return 1, nil }); err != nil {
+			return err
+		}
+		for _, prefix := range []string{"billing", "shipping"} {
+			child := ctx.Fork(prefix)
+			if child.RunID != ctx.RunID || child.WorkflowID != ctx.WorkflowID {
+				t.Fatalf("fork must inherit workflow and run id")
+			}
+			for i := 0; i < 2; i++ {
+				if _, err := Step(child, "load", func() (int, error) { return i, nil }); err != nil {
+					return err
+				}
+			}
+		}
+		_, err := Step(ctx, "load", func() (int, error) { return 2, nil })
+		return err
+	}
+
+	ctx := NewContext(workflowID, store).WithZombieTimeout(time.Minute)
+	if child := ctx.Fork("x"); child.ZombieTimeout != time.Minute {
+		t.Fatalf("expected zombie timeout to be inherited, got %v", child.ZombieTimeout)
+	}
+	if err := run(ctx); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	rows, err := store.ListSteps(workflowID)
+	if err != nil {
+		t.Fatalf("list steps failed: %v", err)
+	}
+	got := make([]string, 0, len(rows))
+	for _, row := range rows {
+		got = append(got, row.StepKey)
+	}
+	sort.Strings(got)
+	want := []string{
+		"billing:load#000001", "billing:load#000002",
+		"load#000001", "load#000002",
+		"shipping:load#000001", "shipping:load#000002",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected keys %v, got %v", want, got)
+	}
+
+	if err := run(NewContext(workflowID, store)); err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	if rows, _ := store.ListSteps(workflowID); len(rows) != len(want) {
+		t.Fatalf("expected replay to reuse forked checkpoints, got %d rows", len(rows))
+	}
+}
+
+func TestStepMapNestedStepsReplayAcrossResume(t *testing.T) {
+	store := newTestStore(t)
+	const workflowID = "wf-step-map-nested"
+
+	keys := []string{"a", "b", "c", "d"}
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	fetch := func(failKey string) func(*Context, string) (string, error) {
+		return func(child *Context, k string) (string, error) {
+			var parts []string
+			for _, part := range []string{"head", "body"} {
+				out, err := Step(child, "fetch", func() (string, error) {
+					mu.Lock()
+					calls[k+"/"+part]++
+					mu.Unlock()
+					if k == failKey && part == "body" {
+						return "", errors.New("simulated crash")
+					}
+					return k + "-" + part, nil
+				})
+				if err != nil {
+					return "", err
+				}
+				parts = append(parts, out)
+			}
+			return strings.Join(parts, "+"), nil
+		}
+	}
+
+	if _, err := StepMap(NewContext(workflowID, store), "page", keys, fetch("c")); err == nil {
+		t.Fatalf("expected first run to fail")
+	}
+	got, err := StepMap(NewContext(workflowID, store), "page", keys, fetch(""))
+	if err != nil {
+		t.Fatalf("resume failed: %v", err)
+	}
+	for _, k := range keys {
+		if want := k + "-head+" + k + "-body"; got[k] != want {
+			t.Fatalf("key %s: expected %q, got %q", k, want, got[k])
+		}
+		if calls[k+"/head"] != 1 {
+			t.Fatalf("key %s: expected nested head step to replay, calls=%v", k, calls)
+		}
+	}
+	if calls["c/body"] != 2 || calls["a/body"] != 1 {
+		t.Fatalf("expected only the failed nested step to re-run, calls=%v", calls)
+	}
+	for _, key := range []string{"page#c:fetch#000001", "page#c:fetch#000002", "page#a:fetch#000002"} {
+		if record, found, err := store.GetStep(workflowID, key); err != nil || !found || record.Status != statusCompleted {
+			t.Fatalf("expected nested step %s completed, got %+v found=%v err=%v", key, record, found, err)
+		}
+	}
+}
+
+// newTestStore returns the backend for tests that only need StoreBackend
+// semantics; they run in memory without touching the filesystem.
+func newTestStore(t *testing.T) *MemoryStore {
+	t.Helper()
+	return NewMemoryStore()
+}
+
+// newSQLiteTestStore returns a *Store on a temporary database, for tests of
+// SQL behaviour or APIs only *Store has.
+func newSQLiteTestStore(t *testing.T) *Store {
 	t.Helper()
 	store, err := NewStore(t.TempDir() + "/test.db")
 	if err != nil {
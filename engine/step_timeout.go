@@ -0,0 +1,59 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrStepTimeout is returned by StepWithTimeout when fn outlives its budget.
+// It wraps context.DeadlineExceeded.
+var ErrStepTimeout = fmt.Errorf("step timed out: %w", context.DeadlineExceeded)
+
+// StepWithTimeout is Step with fn abandoned after d. A timed-out step is
+// marked failed, so the next run executes it again. fn itself cannot be
+// interrupted and keeps running in the background; its result is discarded.
+func StepWithTimeout[T any](ctx *Context, id string, d time.Duration, fn func() (T, error)) (_ T, err error) {
+	var zero T
+
+	if err := checkStepArgs(ctx, fn == nil); err != nil {
+		return zero, err
+	}
+	if d <= 0 {
+		return zero, errors.New("step timeout must be positive")
+	}
+
+	ref := ctx.nextStepRef(id)
+	end := ctx.notifyBeforeStep(ref)
+	defer func() { end(err) }()
+
+	claim, cached, err := ctx.claimStep(ref)
+	if err != nil {
+		return zero, err
+	}
+	if claim == claimCached {
+		return decodeCached[T](ctx, ref, cached)
+	}
+
+	type outcome struct {
+		result T
+		err    error
+	}
+	return runClaimed(ctx, ref, func() (T, error) {
+		done := make(chan outcome, 1)
+		go func() {
+			result, err := fn()
+			done <- outcome{result, err}
+		}()
+
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case out := <-done:
+			return out.result, out.err
+		case <-timer.C:
+			return zero, fmt.Errorf("%w after %s", ErrStepTimeout, d)
+		}
+	})
+}
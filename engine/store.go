@@ -1,63 +1,134 @@
 package engine
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"log/slog"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	_ "modernc.org/sqlite"
 )
 
 const (
 	statusRunning   = "running"
 	statusCompleted = "completed"
 	statusFailed    = "failed"
+	statusPaused    = "paused"
+	statusCancelled = "cancelled"
 )
 
 type StepRecord struct {
-	WorkflowID string
-	StepKey    string
-	StepID     string
-	Sequence   int
-	Status     string
-	OutputJSON string
-	ErrorText  string
-	RunID      string
-	StartedAt  string
-	UpdatedAt  string
+	WorkflowID     string
+	StepKey        string
+	StepID         string
+	Sequence       int
+	Status         string
+	OutputJSON     string
+	ErrorText      string
+	RunID          string
+	StartedAt      string
+	UpdatedAt      string
+	CompletedAt    string
+	MetadataJSON   string
+	OutputChecksum string
+	// AttemptCount is the number of times the step has been claimed.
+	AttemptCount int
+	// RetryBase is the number of claims the step had when its current retry
+	// budget started, that is when it was last claimed after failing.
+	RetryBase int
 }
 
 type Store struct {
-	dbPath       string
-	busyTimeout  time.Duration
+	db           *sql.DB
+	ownsDB       bool
+	dialect      Dialect
 	maxRetries   int
 	retryBackoff time.Duration
+	shards       map[string]string
+	logger       *slog.Logger
+	readCache    *readCache
 
 	mu sync.Mutex
 }
 
-func NewStore(dbPath string) (*Store, error) {
+func NewStore(dbPath string, opts ...StoreOption) (*Store, error) {
 	if strings.TrimSpace(dbPath) == "" {
 		return nil, errors.New("db path is required")
 	}
-	if _, err := exec.LookPath("sqlite3"); err != nil {
-		return nil, fmt.Errorf("sqlite3 binary not found in PATH: %w", err)
+	o := defaultStoreOptions()
+	for _, opt := range opts {
+		if err := opt(&o); err != nil {
+			return nil, err
+		}
 	}
 	if err := os.MkdirAll(filepath.Dir(dbPath), 0o755); err != nil && filepath.Dir(dbPath) != "." {
 		return nil, fmt.Errorf("create db dir: %w", err)
 	}
 
+	db, err := sql.Open("sqlite", fmt.Sprintf("%s?_pragma=busy_timeout(%d)", dbPath, o.busyTimeout.Milliseconds()))
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite db: %w", err)
+	}
+	// Statements are already serialized by Store.mu; a single connection also
+	// keeps per-connection state such as PRAGMAs consistent.
+	db.SetMaxOpenConns(1)
+
+	s := &Store{
+		db:           db,
+		ownsDB:       true,
+		dialect:      SQLiteDialect{},
+		maxRetries:   o.maxRetries,
+		retryBackoff: o.retryBackoff,
+	}
+	if o.readCacheSize > 0 {
+		s.readCache = newReadCache(o.readCacheSize)
+	}
+	if err := s.initSchema(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := s.checkSchemaVersion(o.minSchemaVersion); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Close releases the database opened by NewStore. Stores built with
+// NewStoreWithSQLDriver leave the handle to its owner.
+func (s *Store) Close() error {
+	if !s.ownsDB {
+		return nil
+	}
+	return s.db.Close()
+}
+
+// NewStoreWithSQLDriver builds a Store on an existing database/sql handle.
+// The caller owns db and chooses the driver; dialect renders the SQL.
+func NewStoreWithSQLDriver(db *sql.DB, dialect Dialect) (*Store, error) {
+	if db == nil {
+		return nil, errors.New("sql db is required")
+	}
+	if dialect == nil {
+		return nil, errors.New("sql dialect is required")
+	}
+
+	o := defaultStoreOptions()
 	s := &Store{
-		dbPath:       dbPath,
-		busyTimeout:  5 * time.Second,
-		maxRetries:   8,
-		retryBackoff: 25 * time.Millisecond,
+		db:           db,
+		dialect:      dialect,
+		maxRetries:   o.maxRetries,
+		retryBackoff: o.retryBackoff,
 	}
 	if err := s.initSchema(); err != nil {
 		return nil, err
@@ -66,118 +137,236 @@ func NewStore(dbPath string) (*Store, error) {
 }
 
 func (s *Store) initSchema() error {
-	schema := `
-PRAGMA journal_mode=WAL;
-PRAGMA synchronous=NORMAL;
-CREATE TABLE IF NOT EXISTS steps (
-  workflow_id TEXT NOT NULL,
-  step_key TEXT NOT NULL,
-  step_id TEXT NOT NULL,
-  sequence INTEGER NOT NULL,
-  status TEXT NOT NULL,
-  output_json TEXT,
-  error_text TEXT,
-  run_id TEXT NOT NULL,
-  started_at TEXT NOT NULL,
-  updated_at TEXT NOT NULL,
-  PRIMARY KEY (workflow_id, step_key)
-);
-CREATE INDEX IF NOT EXISTS idx_steps_workflow_status ON steps(workflow_id, status);
-`
-	return s.execWrite(schema)
+	if _, ok := s.dialect.(SQLiteDialect); ok {
+		// Connection settings, so they cannot be part of a migration.
+		if err := s.execWrite("PRAGMA journal_mode=WAL;\nPRAGMA synchronous=NORMAL;"); err != nil {
+			return err
+		}
+	}
+	return s.migrate()
 }
 
-func (s *Store) GetStep(workflowID, stepKey string) (StepRecord, bool, error) {
-	q := fmt.Sprintf(`
-SELECT workflow_id, step_key, step_id, sequence, status, output_json, error_text, run_id, started_at, updated_at
-FROM steps
-WHERE workflow_id=%s AND step_key=%s
-LIMIT 1;`, sqlString(workflowID), sqlString(stepKey))
+const stepColumns = "workflow_id, step_key, step_id, sequence, status, output_json, error_text, run_id, started_at, updated_at, completed_at, metadata_json, output_checksum, attempt_count, retry_base"
 
-	rows, err := s.queryRows(q)
+func (s *Store) GetStep(workflowID, stepKey string) (StepRecord, bool, error) {
+	if record, ok := s.readCache.get(workflowID, stepKey); ok {
+		return record, true, nil
+	}
+	st := s.dialect.GetStepSQL(workflowID, stepKey)
+	rows, err := s.queryRows(st.Query, st.Args...)
 	if err != nil {
 		return StepRecord{}, false, err
 	}
 	if len(rows) == 0 {
 		return StepRecord{}, false, nil
 	}
-	return parseStepRecord(rows[0]), true, nil
+	record := parseStepRecord(rows[0])
+	s.readCache.put(record)
+	return record, true, nil
+}
+
+func (s *Store) UpsertRunning(workflowID string, ref StepRef, runID string) error {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	return s.execTx(context.Background(), withoutClaimCheck(s.dialect.UpsertRunningSQL(workflowID, ref, runID, now, now)))
+}
+
+// claimStep claims ref for a Context, taking it over from another run only
+// once that run has not touched it for zombieTimeout, and replaces the
+// step's metadata in the same transaction when metadataJSON is set. It fails
+// with ErrStepStillRunning if another run holds or completed the step.
+func (s *Store) claimStep(workflowID string, ref StepRef, runID string, zombieTimeout time.Duration, metadataJSON string) error {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	stmts := s.dialect.UpsertRunningSQL(workflowID, ref, runID, now, staleBefore(now, zombieTimeout))
+	if metadataJSON != "" {
+		stmts = append(stmts, s.dialect.SetStepMetadataSQL(workflowID, ref.StepKey, metadataJSON))
+	}
+	defer s.readCache.remove(workflowID, ref.StepKey)
+	return s.execTx(context.Background(), stmts)
 }
 
-func (s *Store) UpsertRunning(workflowID string, ref stepRef, runID string) error {
+func (s *Store) MarkCompleted(workflowID, stepKey, runID, outputJSON string) error {
+	return s.markCompletedContext(context.Background(), workflowID, stepKey, runID, outputJSON, "")
+}
+
+// markCompletedContext checkpoints outputJSON, replacing the step's metadata
+// with metadataJSON in the same transaction when it is set.
+func (s *Store) markCompletedContext(goCtx context.Context, workflowID, stepKey, runID, outputJSON, metadataJSON string) error {
 	now := time.Now().UTC().Format(time.RFC3339Nano)
-	q := fmt.Sprintf(`
-INSERT INTO steps(workflow_id, step_key, step_id, sequence, status, output_json, error_text, run_id, started_at, updated_at)
-VALUES(%s, %s, %s, %d, %s, NULL, NULL, %s, %s, %s)
+	s.readCache.remove(workflowID, stepKey)
+	stmts := []Statement{s.dialect.MarkCompletedSQL(workflowID, stepKey, runID, outputJSON, now)}
+	if metadataJSON != "" {
+		stmts = append(stmts, s.dialect.SetStepMetadataSQL(workflowID, stepKey, metadataJSON))
+	}
+	if err := s.execTx(goCtx, stmts); err != nil {
+		return err
+	}
+	if s.readCache != nil {
+		// Read the row back so the cache holds it exactly as stored.
+		_, _, _ = s.GetStep(workflowID, stepKey)
+	}
+	return nil
+}
+
+func (s *Store) MarkFailed(workflowID, stepKey, runID, errText string) error {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	defer s.readCache.remove(workflowID, stepKey)
+	return s.execStatement(s.dialect.MarkFailedSQL(workflowID, stepKey, runID, errText, now))
+}
+
+// PutCheckpoint writes ref as a completed step holding outputJSON and
+// metadataJSON, creating it or overwriting whatever it held before.
+func (s *Store) PutCheckpoint(workflowID string, ref StepRef, runID, outputJSON, metadataJSON string) error {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	defer s.readCache.remove(workflowID, ref.StepKey)
+	return s.execWrite(`
+INSERT INTO steps(workflow_id, step_key, step_id, sequence, status, output_json, output_checksum, run_id, started_at, updated_at, completed_at, metadata_json)
+VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $9, $9, $10)
 ON CONFLICT(workflow_id, step_key) DO UPDATE SET
-  status=%s,
-  output_json=NULL,
+  status=excluded.status,
+  output_json=excluded.output_json,
+  output_checksum=excluded.output_checksum,
+  metadata_json=excluded.metadata_json,
   error_text=NULL,
   run_id=excluded.run_id,
-  started_at=excluded.started_at,
-  updated_at=excluded.updated_at
-WHERE steps.status <> %s;`,
-		sqlString(workflowID),
-		sqlString(ref.StepKey),
-		sqlString(ref.StepID),
+  updated_at=excluded.updated_at,
+  completed_at=excluded.completed_at;`,
+		workflowID,
+		ref.StepKey,
+		ref.StepID,
 		ref.Sequence,
-		sqlString(statusRunning),
-		sqlString(runID),
-		sqlString(now),
-		sqlString(now),
-		sqlString(statusRunning),
-		sqlString(statusCompleted),
+		statusCompleted,
+		outputJSON,
+		outputChecksum(outputJSON),
+		runID,
+		now,
+		sqlNullString(metadataJSON),
 	)
-	return s.execWrite(q)
 }
 
-func (s *Store) MarkCompleted(workflowID, stepKey, runID, outputJSON string) error {
+// RecordStepAttempt notes the error of a failed attempt of a step that will
+// be retried. The step stays running; only error_text changes.
+func (s *Store) RecordStepAttempt(workflowID, stepKey, runID, errText string) error {
 	now := time.Now().UTC().Format(time.RFC3339Nano)
-	q := fmt.Sprintf(`
+	return s.execWrite(`
 UPDATE steps
-SET status=%s,
-    output_json=%s,
-    error_text=NULL,
-    run_id=%s,
-    updated_at=%s
-WHERE workflow_id=%s AND step_key=%s;`,
-		sqlString(statusCompleted),
-		sqlString(outputJSON),
-		sqlString(runID),
-		sqlString(now),
-		sqlString(workflowID),
-		sqlString(stepKey),
+SET error_text=$1,
+    run_id=$2,
+    updated_at=$3
+WHERE workflow_id=$4 AND step_key=$5 AND status=$6;`,
+		errText,
+		runID,
+		now,
+		workflowID,
+		stepKey,
+		statusRunning,
 	)
-	return s.execWrite(q)
 }
 
-func (s *Store) MarkFailed(workflowID, stepKey, runID, errText string) error {
-	now := time.Now().UTC().Format(time.RFC3339Nano)
-	q := fmt.Sprintf(`
-UPDATE steps
-SET status=%s,
-    error_text=%s,
-    run_id=%s,
-    updated_at=%s
-WHERE workflow_id=%s AND step_key=%s;`,
-		sqlString(statusFailed),
-		sqlString(errText),
-		sqlString(runID),
-		sqlString(now),
-		sqlString(workflowID),
-		sqlString(stepKey),
-	)
-	return s.execWrite(q)
+// BatchUpsertRunning claims several steps in a single transaction.
+func (s *Store) BatchUpsertRunning(workflowID string, refs []StepRef, runID string) error {
+	var wb WriteBatch
+	for _, ref := range refs {
+		wb.UpsertRunning(workflowID, ref, runID)
+	}
+	return s.ApplyWriteBatch(&wb)
+}
+
+// BatchMarkCompleted checkpoints several step outputs, keyed by step key, in
+// a single transaction.
+func (s *Store) BatchMarkCompleted(workflowID, runID string, outputs map[string]string) error {
+	keys := make([]string, 0, len(outputs))
+	for key := range outputs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var wb WriteBatch
+	for _, key := range keys {
+		wb.MarkCompleted(workflowID, key, runID, outputs[key])
+	}
+	return s.ApplyWriteBatch(&wb)
 }
 
+func (s *Store) SetStepMetadata(workflowID, stepKey, metadataJSON string) error {
+	defer s.readCache.remove(workflowID, stepKey)
+	return s.execStatement(s.dialect.SetStepMetadataSQL(workflowID, stepKey, metadataJSON))
+}
+
+// ListSteps returns the workflow's steps ordered by sequence, then step key,
+// so the iterations of a loop interleave with the steps around them.
 func (s *Store) ListSteps(workflowID string) ([]StepRecord, error) {
-	q := fmt.Sprintf(`
-SELECT workflow_id, step_key, step_id, sequence, status, output_json, error_text, run_id, started_at, updated_at
+	st := s.dialect.ListStepsSQL(workflowID)
+	return s.queryStepRecords(st.Query, st.Args...)
+}
+
+// LoadStepCounters returns the highest sequence recorded for each step id.
+func (s *Store) LoadStepCounters(workflowID string) (map[string]int, error) {
+	rows, err := s.queryRows(`
+SELECT step_id, MAX(sequence) AS max_sequence
 FROM steps
-WHERE workflow_id=%s
-ORDER BY step_key;`, sqlString(workflowID))
+WHERE workflow_id=$1
+GROUP BY step_id;`, workflowID)
+	if err != nil {
+		return nil, err
+	}
+	counters := make(map[string]int, len(rows))
+	for _, row := range rows {
+		counters[asString(row["step_id"])] = asInt(row["max_sequence"])
+	}
+	return counters, nil
+}
+
+// ListFailedSteps returns the workflow's failed steps in ListSteps order.
+func (s *Store) ListFailedSteps(workflowID string) ([]StepRecord, error) {
+	return s.listStepsWithStatus(workflowID, statusFailed)
+}
+
+// ListRunningSteps returns the workflow's running steps in ListSteps order.
+func (s *Store) ListRunningSteps(workflowID string) ([]StepRecord, error) {
+	return s.listStepsWithStatus(workflowID, statusRunning)
+}
+
+func (s *Store) listStepsWithStatus(workflowID, status string) ([]StepRecord, error) {
+	return s.queryStepRecords(`
+SELECT `+stepColumns+`
+FROM steps
+WHERE workflow_id=$1 AND status=$2
+ORDER BY sequence, step_key;`, workflowID, status)
+}
+
+// GetStepAttemptCount reports how many times a step has been claimed for
+// execution. Stores whose schema has no step_attempts table report 0.
+func (s *Store) GetStepAttemptCount(workflowID, stepKey string) (int, error) {
+	rows, err := s.queryRows(`
+SELECT COUNT(*) AS attempts
+FROM step_attempts
+WHERE workflow_id=$1 AND step_key=$2;`, workflowID, stepKey)
+	if err != nil {
+		if strings.Contains(err.Error(), "no such table") {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	return asInt(rows[0]["attempts"]), nil
+}
+
+func (s *Store) GetTopKSlowSteps(workflowID string, k int) ([]StepRecord, error) {
+	if k <= 0 {
+		return nil, nil
+	}
+	return s.queryStepRecords(`
+SELECT `+stepColumns+`
+FROM steps
+WHERE workflow_id=$1 AND status=$2 AND completed_at IS NOT NULL
+ORDER BY julianday(completed_at) - julianday(started_at) DESC, step_key
+LIMIT $3;`, workflowID, statusCompleted, k)
+}
 
-	rows, err := s.queryRows(q)
+func (s *Store) queryStepRecords(query string, args ...any) ([]StepRecord, error) {
+	rows, err := s.queryRows(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -188,81 +377,186 @@ ORDER BY step_key;`, sqlString(workflowID))
 	return out, nil
 }
 
-func (s *Store) execWrite(sql string) error {
+// beginSQL opens a write transaction. SQLite takes the write lock up front
+// so the transaction cannot fail to upgrade halfway through.
+func (s *Store) beginSQL() string {
+	if _, ok := s.dialect.(SQLiteDialect); ok {
+		return "BEGIN IMMEDIATE;"
+	}
+	return "BEGIN;"
+}
+
+// execWrite runs query with args bound to its $n placeholders, retrying
+// while the database is busy. A query without args may hold several
+// statements.
+func (s *Store) execWrite(query string, args ...any) error {
+	return s.execWriteContext(context.Background(), query, args...)
+}
+
+// execWriteContext is execWrite that gives up, without retrying, once goCtx
+// is done.
+func (s *Store) execWriteContext(goCtx context.Context, query string, args ...any) error {
+	_, err := s.execWriteRowsContext(goCtx, query, args...)
+	return err
+}
+
+func (s *Store) execStatement(st Statement) error {
+	return s.execWrite(st.Query, st.Args...)
+}
+
+// execWriteRows is execWrite for a single statement, returning how many rows
+// it changed.
+func (s *Store) execWriteRows(query string, args ...any) (int, error) {
+	n, err := s.execWriteRowsContext(context.Background(), query, args...)
+	return int(n), err
+}
+
+func (s *Store) execWriteRowsContext(goCtx context.Context, query string, args ...any) (int64, error) {
+	return s.retryWrite(goCtx, func(conn *sql.Conn) (int64, error) {
+		res, err := conn.ExecContext(goCtx, query, args...)
+		if err != nil {
+			return 0, err
+		}
+		return res.RowsAffected()
+	})
+}
+
+// execTx runs stmts in order in one transaction, retrying the whole
+// transaction while the database is busy. An interrupted transaction is
+// rolled back, so nothing is half-applied.
+func (s *Store) execTx(goCtx context.Context, stmts []Statement) error {
+	_, err := s.retryWrite(goCtx, func(conn *sql.Conn) (int64, error) {
+		if _, err := conn.ExecContext(goCtx, s.beginSQL()); err != nil {
+			return 0, err
+		}
+		for _, st := range stmts {
+			res, err := conn.ExecContext(goCtx, st.Query, st.Args...)
+			if err != nil {
+				return 0, err
+			}
+			if !st.Claim {
+				continue
+			}
+			if n, err := res.RowsAffected(); err != nil {
+				return 0, err
+			} else if n == 0 {
+				return 0, ErrStepStillRunning
+			}
+		}
+		_, err := conn.ExecContext(goCtx, "COMMIT;")
+		return 0, err
+	})
+	return err
+}
+
+// retryWrite runs fn on a pinned connection, retrying on busy errors like
+// execWrite. fn returns a count for the caller.
+func (s *Store) retryWrite(goCtx context.Context, fn func(*sql.Conn) (int64, error)) (int64, error) {
 	var lastErr error
 	for attempt := 0; attempt <= s.maxRetries; attempt++ {
 		s.mu.Lock()
-		output, err := s.runSQLite(false, sql)
+		n, err := s.runWrite(goCtx, fn)
 		s.mu.Unlock()
 		if err == nil {
-			return nil
+			return n, nil
 		}
-		lastErr = annotateSQLiteError(err, output)
-		if !isBusyError(output) || attempt == s.maxRetries {
-			return lastErr
+		lastErr = err
+		if goCtx.Err() != nil || !isBusyError(lastErr) || attempt == s.maxRetries {
+			return 0, lastErr
 		}
+		s.slogger().Warn("database busy, retrying write", "attempt", attempt+1, "max_retries", s.maxRetries, "error", err)
 		time.Sleep(s.retryBackoff * time.Duration(attempt+1))
 	}
-	return lastErr
+	return 0, lastErr
 }
 
-func (s *Store) queryRows(sql string) ([]map[string]any, error) {
+// queryRows runs query with args bound to its $n placeholders.
+func (s *Store) queryRows(query string, args ...any) ([]map[string]any, error) {
 	s.mu.Lock()
-	output, err := s.runSQLite(true, sql)
-	s.mu.Unlock()
+	defer s.mu.Unlock()
+	return s.queryDB(query, args...)
+}
+
+// runWrite runs fn on one pinned connection. A failed transaction would
+// otherwise stay open on a pooled connection, so it is rolled back before
+// the connection is released.
+func (s *Store) runWrite(goCtx context.Context, fn func(*sql.Conn) (int64, error)) (int64, error) {
+	conn, err := s.db.Conn(goCtx)
 	if err != nil {
-		return nil, annotateSQLiteError(err, output)
+		return 0, err
 	}
+	defer conn.Close()
 
-	trimmed := bytes.TrimSpace(output)
-	if len(trimmed) == 0 {
-		return nil, nil
+	n, err := fn(conn)
+	if err != nil {
+		_, _ = conn.ExecContext(context.Background(), "ROLLBACK;")
+		return 0, err
 	}
+	return n, nil
+}
 
-	var rows []map[string]any
-	if err := json.Unmarshal(trimmed, &rows); err != nil {
-		return nil, fmt.Errorf("parse sqlite json output: %w", err)
+// queryDB scans rows into column-name maps so every query shares the record
+// parsers regardless of the driver.
+func (s *Store) queryDB(query string, args ...any) ([]map[string]any, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
 	}
-	return rows, nil
+	return scanRows(rows)
 }
 
-func (s *Store) runSQLite(jsonMode bool, sql string) ([]byte, error) {
-	busyMS := strconv.Itoa(int(s.busyTimeout / time.Millisecond))
-	args := []string{"-cmd", ".timeout " + busyMS}
-	if jsonMode {
-		args = append([]string{"-json"}, args...)
-	}
-	args = append(args, s.dbPath, sql)
+func scanRows(rows *sql.Rows) ([]map[string]any, error) {
+	defer rows.Close()
 
-	cmd := exec.Command("sqlite3", args...)
-	return cmd.CombinedOutput()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var out []map[string]any
+	for rows.Next() {
+		values := make([]any, len(cols))
+		ptrs := make([]any, len(cols))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		row := make(map[string]any, len(cols))
+		for i, col := range cols {
+			if b, ok := values[i].([]byte); ok {
+				row[col] = string(b)
+				continue
+			}
+			row[col] = values[i]
+		}
+		out = append(out, row)
+	}
+	return out, rows.Err()
 }
 
-func isBusyError(output []byte) bool {
-	msg := strings.ToLower(string(output))
+func isBusyError(err error) bool {
+	msg := strings.ToLower(err.Error())
 	return strings.Contains(msg, "database is locked") || strings.Contains(msg, "sqlite_busy")
 }
 
-func annotateSQLiteError(err error, output []byte) error {
-	msg := strings.TrimSpace(string(output))
-	if msg == "" {
-		return err
-	}
-	return fmt.Errorf("%w: %s", err, msg)
-}
-
 func parseStepRecord(row map[string]any) StepRecord {
 	return StepRecord{
-		WorkflowID: asString(row["workflow_id"]),
-		StepKey:    asString(row["step_key"]),
-		StepID:     asString(row["step_id"]),
-		Sequence:   asInt(row["sequence"]),
-		Status:     asString(row["status"]),
-		OutputJSON: asString(row["output_json"]),
-		ErrorText:  asString(row["error_text"]),
-		RunID:      asString(row["run_id"]),
-		StartedAt:  asString(row["started_at"]),
-		UpdatedAt:  asString(row["updated_at"]),
+		WorkflowID:     asString(row["workflow_id"]),
+		StepKey:        asString(row["step_key"]),
+		StepID:         asString(row["step_id"]),
+		Sequence:       asInt(row["sequence"]),
+		Status:         asString(row["status"]),
+		OutputJSON:     asString(row["output_json"]),
+		ErrorText:      asString(row["error_text"]),
+		RunID:          asString(row["run_id"]),
+		StartedAt:      asString(row["started_at"]),
+		UpdatedAt:      asString(row["updated_at"]),
+		CompletedAt:    asString(row["completed_at"]),
+		MetadataJSON:   asString(row["metadata_json"]),
+		OutputChecksum: asString(row["output_checksum"]),
+		AttemptCount:   asInt(row["attempt_count"]),
+		RetryBase:      asInt(row["retry_base"]),
 	}
 }
 
@@ -285,6 +579,8 @@ func asInt(v any) int {
 		return int(x)
 	case int:
 		return x
+	case int64:
+		return int(x)
 	case string:
 		n, _ := strconv.Atoi(x)
 		return n
@@ -293,6 +589,32 @@ func asInt(v any) int {
 	}
 }
 
-func sqlString(s string) string {
-	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+// outputChecksum is the hex SHA-256 stored alongside output_json so replays
+// can detect rows that changed after they were checkpointed.
+func outputChecksum(outputJSON string) string {
+	sum := sha256.Sum256([]byte(outputJSON))
+	return hex.EncodeToString(sum[:])
+}
+
+// sqlArgs collects the arguments of a query built up piece by piece.
+type sqlArgs []any
+
+// add appends v and returns the placeholder that refers to it.
+func (a *sqlArgs) add(v any) string {
+	*a = append(*a, v)
+	return "$" + strconv.Itoa(len(*a))
+}
+
+// sqlNullString returns v as a query argument, or nil for the empty string
+// that reading a NULL column produces.
+func sqlNullString(v string) any {
+	if v == "" {
+		return nil
+	}
+	return v
+}
+
+// sqlTime formats t as the timestamps are stored.
+func sqlTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
 }
@@ -0,0 +1,93 @@
+package engine
+
+import (
+	"fmt"
+	"time"
+)
+
+// StoreOption configures NewStore.
+type StoreOption func(*storeOptions) error
+
+type storeOptions struct {
+	busyTimeout      time.Duration
+	maxRetries       int
+	retryBackoff     time.Duration
+	minSchemaVersion int
+	readCacheSize    int
+}
+
+func defaultStoreOptions() storeOptions {
+	return storeOptions{
+		busyTimeout:  5 * time.Second,
+		maxRetries:   8,
+		retryBackoff: 25 * time.Millisecond,
+	}
+}
+
+// WithBusyTimeout sets how long SQLite waits on a locked database before a
+// statement fails with SQLITE_BUSY.
+func WithBusyTimeout(d time.Duration) StoreOption {
+	return func(o *storeOptions) error {
+		if d < 0 {
+			return fmt.Errorf("busy timeout must not be negative, got %s", d)
+		}
+		o.busyTimeout = d
+		return nil
+	}
+}
+
+// WithMaxRetries sets how many times a write is retried after SQLITE_BUSY.
+func WithMaxRetries(n int) StoreOption {
+	return func(o *storeOptions) error {
+		if n < 1 {
+			return fmt.Errorf("max retries must be at least 1, got %d", n)
+		}
+		o.maxRetries = n
+		return nil
+	}
+}
+
+// WithRetryBackoff sets the base delay between busy retries; the nth retry
+// waits n times as long.
+func WithRetryBackoff(d time.Duration) StoreOption {
+	return func(o *storeOptions) error {
+		if d < 0 {
+			return fmt.Errorf("retry backoff must not be negative, got %s", d)
+		}
+		o.retryBackoff = d
+		return nil
+	}
+}
+
+// WithSchemaVersionCheck makes NewStore fail with ErrSchemaVersionTooOld
+// unless the store ends up at schema version required or later. NewStore
+// migrates older databases to currentSchemaVersion itself, so this is a check
+// that the engine supports the schema a caller depends on: a required version
+// newer than this build can create fails before the database is opened, and
+// the version actually recorded after migration is checked as well.
+func WithSchemaVersionCheck(required int) StoreOption {
+	return func(o *storeOptions) error {
+		if required < 0 {
+			return fmt.Errorf("required schema version must not be negative, got %d", required)
+		}
+		if required > currentSchemaVersion {
+			return fmt.Errorf("%w: engine supports up to %d, need %d", ErrSchemaVersionTooOld, currentSchemaVersion, required)
+		}
+		o.minSchemaVersion = required
+		return nil
+	}
+}
+
+func (s *Store) checkSchemaVersion(required int) error {
+	if required <= 0 {
+		return nil
+	}
+	version, err := s.SchemaVersion()
+	if err != nil {
+		return fmt.Errorf("read schema version: %w", err)
+	}
+	if version < required {
+		return fmt.Errorf("%w: have %d, need %d", ErrSchemaVersionTooOld, version, required)
+	}
+	return nil
+}
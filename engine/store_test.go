@@ -0,0 +1,1056 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateIntegrityReportsBrokenRows(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	const workflowID = "wf-integrity"
+
+	ctx := NewContext(workflowID, store)
+	for _, id := range []string{"create_record", "send_email"} {
+		if _, err := Step(ctx, id, func() (string, error) { return "ok", nil }); err != nil {
+			t.Fatalf("seed step %s failed: %v", id, err)
+		}
+	}
+
+	problems, err := store.ValidateIntegrity(workflowID)
+	if err != nil {
+		t.Fatalf("validate clean workflow failed: %v", err)
+	}
+	if len(problems) != 0 {
+		t.Fatalf("expected clean workflow, got %v", problems)
+	}
+
+	if err := store.execWrite(`
+UPDATE steps
+SET output_json=NULL, started_at='yesterday'
+WHERE workflow_id='wf-integrity' AND step_key='send_email#000001';`); err != nil {
+		t.Fatalf("failed to damage row: %v", err)
+	}
+
+	problems, err = store.ValidateIntegrity(workflowID)
+	if err != nil {
+		t.Fatalf("validate damaged workflow failed: %v", err)
+	}
+	kinds := make(map[string]bool)
+	for _, p := range problems {
+		if p.StepKey != "send_email#000001" {
+			t.Fatalf("unexpected problem on %s: %v", p.StepKey, p)
+		}
+		kinds[p.Kind] = true
+	}
+	if !kinds[IntegrityMissingOutput] || !kinds[IntegrityInvalidTimestamp] {
+		t.Fatalf("expected missing output and invalid timestamp problems, got %v", problems)
+	}
+}
+
+func TestGetTopKSlowStepsOrdersByDuration(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	const workflowID = "wf-profile"
+
+	ctx := NewContext(workflowID, store)
+	delays := map[string]time.Duration{
+		"fast":   0,
+		"slow":   120 * time.Millisecond,
+		"medium": 60 * time.Millisecond,
+	}
+	for _, id := range []string{"fast", "slow", "medium"} {
+		d := delays[id]
+		if _, err := Step(ctx, id, func() (string, error) {
+			time.Sleep(d)
+			return id, nil
+		}); err != nil {
+			t.Fatalf("step %s failed: %v", id, err)
+		}
+	}
+
+	top, err := store.GetTopKSlowSteps(workflowID, 2)
+	if err != nil {
+		t.Fatalf("top-k query failed: %v", err)
+	}
+	if len(top) != 2 {
+		t.Fatalf("expected 2 rows, got %d", len(top))
+	}
+	if top[0].StepID != "slow" || top[1].StepID != "medium" {
+		t.Fatalf("unexpected order: %s, %s", top[0].StepKey, top[1].StepKey)
+	}
+	if top[0].CompletedAt == "" {
+		t.Fatalf("expected completed_at to be recorded")
+	}
+}
+
+func TestWorkflowLogAppendsInOrder(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	const workflowID = "wf-audit-log"
+
+	ctx := NewContext(workflowID, store).WithCustomLogger(NewStoreLogger(store, workflowID))
+	if _, err := Step(ctx, "create_record", func() (int, error) { return 1, nil }); err != nil {
+		t.Fatalf("step failed: %v", err)
+	}
+	if err := store.AppendWorkflowLog(workflowID, []LogEntry{
+		{Level: LogLevelWarn, Message: "manual note"},
+		{Message: "second note", FieldsJSON: `{"ticket":"OPS-1"}`},
+	}); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+
+	entries, err := store.GetWorkflowLog(workflowID, 0)
+	if err != nil {
+		t.Fatalf("read log failed: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(entries))
+	}
+	for i, e := range entries {
+		if e.Seq != i+1 {
+			t.Fatalf("entry %d has seq %d", i, e.Seq)
+		}
+	}
+	if entries[0].Message != "step completed" || entries[2].Level != LogLevelInfo {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+
+	tail, err := store.GetWorkflowLog(workflowID, 2)
+	if err != nil {
+		t.Fatalf("read log tail failed: %v", err)
+	}
+	if len(tail) != 1 || tail[0].FieldsJSON != `{"ticket":"OPS-1"}` {
+		t.Fatalf("unexpected tail: %+v", tail)
+	}
+}
+
+func TestGetStepsMatchingCombinesFilters(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	const workflowID = "wf-matching"
+
+	ctx := NewContext(workflowID, store)
+	for _, id := range []string{"charge_card", "charge_card", "charge_fee", "notify"} {
+		if _, err := Step(ctx, id, func() (string, error) { return id, nil }); err != nil {
+			t.Fatalf("step %s failed: %v", id, err)
+		}
+	}
+	if _, err := Step(ctx, "charge_refund", func() (string, error) { return "", errors.New("declined") }); err == nil {
+		t.Fatalf("expected failing step")
+	}
+
+	got, err := store.GetStepsMatching(workflowID, StepFilter{StepIDPrefix: "charge_", Status: statusCompleted})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("expected 3 completed charge steps, got %d", len(got))
+	}
+
+	page, err := store.GetStepsMatching(workflowID, StepFilter{StepIDPrefix: "charge_", Limit: 2, Offset: 1})
+	if err != nil {
+		t.Fatalf("paged query failed: %v", err)
+	}
+	if len(page) != 2 || page[0].StepKey != "charge_card#000002" {
+		t.Fatalf("unexpected page: %+v", page)
+	}
+
+	future, err := store.GetStepsMatching(workflowID, StepFilter{CreatedAfter: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("time query failed: %v", err)
+	}
+	if len(future) != 0 {
+		t.Fatalf("expected no steps created in the future, got %d", len(future))
+	}
+
+	byRun, err := store.GetStepsMatching(workflowID, StepFilter{RunID: ctx.RunID, Status: statusFailed})
+	if err != nil {
+		t.Fatalf("run query failed: %v", err)
+	}
+	if len(byRun) != 1 || byRun[0].StepID != "charge_refund" {
+		t.Fatalf("unexpected failed steps: %+v", byRun)
+	}
+}
+
+func TestGetStepsMatchingMultibytePrefix(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	const workflowID = "wf-matching-utf8"
+
+	ctx := NewContext(workflowID, store)
+	for _, child := range []*Context{ctx.Fork("café"), ctx.Fork("cafés"), ctx} {
+		if _, err := Step(child, "order", func() (int, error) { return 1, nil }); err != nil {
+			t.Fatalf("step failed: %v", err)
+		}
+	}
+
+	got, err := store.GetStepsMatching(workflowID, StepFilter{StepIDPrefix: "café:"})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if len(got) != 1 || got[0].StepKey != "café:order#000001" {
+		t.Fatalf("expected only the café fork's step, got %+v", got)
+	}
+}
+
+func TestGetGlobalStatsCountsAcrossWorkflows(t *testing.T) {
+	store := newSQLiteTestStore(t)
+
+	for _, wf := range []string{"wf-stats-a", "wf-stats-b"} {
+		ctx := NewContext(wf, store)
+		for _, id := range []string{"one", "two"} {
+			if _, err := Step(ctx, id, func() (int, error) { return 1, nil }); err != nil {
+				t.Fatalf("step failed: %v", err)
+			}
+		}
+	}
+	ctx := NewContext("wf-stats-b", store)
+	if _, err := Step(ctx, "three", func() (int, error) { return 0, errors.New("nope") }); err == nil {
+		t.Fatalf("expected failing step")
+	}
+	if err := store.MarkWorkflowRunning("wf-stats-b", ctx.RunID); err != nil {
+		t.Fatalf("mark running failed: %v", err)
+	}
+
+	stats, err := store.GetGlobalStats()
+	if err != nil {
+		t.Fatalf("stats failed: %v", err)
+	}
+	if stats.TotalWorkflows != 2 || stats.TotalSteps != 5 || stats.CompletedSteps != 4 || stats.FailedSteps != 1 || stats.RunningSteps != 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	if stats.AvgStepsPerWorkflow != 2.5 {
+		t.Fatalf("unexpected average: %v", stats.AvgStepsPerWorkflow)
+	}
+	if stats.OldestRunningWorkflowAge < 0 || stats.OldestRunningWorkflowAge > time.Minute {
+		t.Fatalf("unexpected oldest running age: %v", stats.OldestRunningWorkflowAge)
+	}
+}
+
+func TestGetStepAttemptCountTracksClaims(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	const workflowID = "wf-attempts"
+
+	for i := 0; i < 3; i++ {
+		ctx := NewContext(workflowID, store)
+		_, _ = Step(ctx, "flaky", func() (int, error) {
+			if i < 2 {
+				return 0, errors.New("transient")
+			}
+			return 1, nil
+		})
+	}
+	// A cached replay is not an attempt.
+	if _, err := Step(NewContext(workflowID, store), "flaky", func() (int, error) { return 1, nil }); err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+
+	n, err := store.GetStepAttemptCount(workflowID, "flaky#000001")
+	if err != nil {
+		t.Fatalf("attempt count failed: %v", err)
+	}
+	if n != 3 {
+		t.Fatalf("expected 3 attempts, got %d", n)
+	}
+
+	if err := store.execWrite("DROP TABLE step_attempts;"); err != nil {
+		t.Fatalf("drop table failed: %v", err)
+	}
+	n, err = store.GetStepAttemptCount(workflowID, "flaky#000001")
+	if err != nil || n != 0 {
+		t.Fatalf("expected 0 attempts without table, got %d err=%v", n, err)
+	}
+}
+
+func TestApplyWriteBatchIsAtomic(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	const workflowID = "wf-write-batch"
+
+	ctx := NewContext(workflowID, store)
+	a := ctx.nextStepRef("level_a")
+	b := ctx.nextStepRef("level_b")
+
+	var wb WriteBatch
+	wb.UpsertRunning(workflowID, a, ctx.RunID)
+	wb.UpsertRunning(workflowID, b, ctx.RunID)
+	wb.MarkCompleted(workflowID, a.StepKey, ctx.RunID, `"a"`)
+	wb.MarkFailed(workflowID, b.StepKey, ctx.RunID, "b failed")
+	if err := store.ApplyWriteBatch(&wb); err != nil {
+		t.Fatalf("apply batch failed: %v", err)
+	}
+
+	rows, err := store.ListSteps(workflowID)
+	if err != nil {
+		t.Fatalf("list steps failed: %v", err)
+	}
+	if len(rows) != 2 || rows[0].Status != statusCompleted || rows[1].Status != statusFailed {
+		t.Fatalf("unexpected rows after batch: %+v", rows)
+	}
+
+	// A statement that violates the schema rolls back the whole batch.
+	var bad WriteBatch
+	bad.MarkCompleted(workflowID, b.StepKey, ctx.RunID, `"b"`)
+	bad.UpsertRunning(workflowID, StepRef{StepID: "broken", StepKey: "broken#000001", Sequence: 1}, "")
+	bad.ops = append(bad.ops, func(Dialect, string) []Statement {
+		return []Statement{{Query: "INSERT INTO steps(workflow_id) VALUES(NULL);"}}
+	})
+	if err := store.ApplyWriteBatch(&bad); err == nil {
+		t.Fatalf("expected batch with invalid statement to fail")
+	}
+	row, _, err := store.GetStep(workflowID, b.StepKey)
+	if err != nil {
+		t.Fatalf("load row failed: %v", err)
+	}
+	if row.Status != statusFailed {
+		t.Fatalf("expected failed batch to be rolled back, got status %s", row.Status)
+	}
+}
+
+func TestErrorsByTypeGroupsOnPrefix(t *testing.T) {
+	store := newSQLiteTestStore(t)
+
+	longTail := strings.Repeat("x", 100)
+	fail := func(workflowID, id, msg string) {
+		ctx := NewContext(workflowID, store)
+		if _, err := Step(ctx, id, func() (int, error) { return 0, errors.New(msg) }); err == nil {
+			t.Fatalf("expected %s to fail", id)
+		}
+	}
+	fail("wf-errors-a", "one", "timeout "+longTail+" attempt 1")
+	fail("wf-errors-a", "two", "timeout "+longTail+" attempt 2")
+	fail("wf-errors-a", "three", "card declined")
+	fail("wf-errors-b", "one", "card declined")
+
+	byType, err := store.GetStepErrorsByType("wf-errors-a")
+	if err != nil {
+		t.Fatalf("errors by type failed: %v", err)
+	}
+	if len(byType) != 2 || byType["card declined"] != 1 || byType[("timeout " + longTail)[:80]] != 2 {
+		t.Fatalf("unexpected grouping: %v", byType)
+	}
+
+	global, err := store.GetGlobalErrorsByType(1)
+	if err != nil {
+		t.Fatalf("global errors failed: %v", err)
+	}
+	if len(global) != 1 || global[0].Count != 2 {
+		t.Fatalf("unexpected global top errors: %+v", global)
+	}
+}
+
+func TestAggregateStepOutputsFoldsInSequenceOrder(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	const workflowID = "wf-aggregate"
+
+	ctx := NewContext(workflowID, store)
+	for i := 1; i <= 4; i++ {
+		if _, err := Step(ctx, "count", func() (int, error) { return i, nil }); err != nil {
+			t.Fatalf("step %d failed: %v", i, err)
+		}
+	}
+	if _, err := Step(ctx, "other", func() (int, error) { return 100, nil }); err != nil {
+		t.Fatalf("other step failed: %v", err)
+	}
+
+	out, err := store.AggregateStepOutputs(workflowID, "count", []byte("0"), func(acc, item []byte) ([]byte, error) {
+		return []byte(string(acc) + "," + string(item)), nil
+	})
+	if err != nil {
+		t.Fatalf("aggregate failed: %v", err)
+	}
+	if string(out) != "0,1,2,3,4" {
+		t.Fatalf("unexpected fold: %s", out)
+	}
+
+	boom := errors.New("boom")
+	if _, err := store.AggregateStepOutputs(workflowID, "count", nil, func(acc, item []byte) ([]byte, error) {
+		return nil, boom
+	}); !errors.Is(err, boom) {
+		t.Fatalf("expected reducer error, got %v", err)
+	}
+}
+
+func TestStreamStepOutputsStopsOnError(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	const workflowID = "wf-stream"
+
+	ctx := NewContext(workflowID, store)
+	for i := 1; i <= 3; i++ {
+		if _, err := Step(ctx, "page", func() (map[string]int, error) { return map[string]int{"n": i}, nil }); err != nil {
+			t.Fatalf("step %d failed: %v", i, err)
+		}
+	}
+
+	var seqs []int
+	var raws []string
+	if err := store.StreamStepOutputs(workflowID, "page", func(seq int, raw json.RawMessage) error {
+		seqs = append(seqs, seq)
+		raws = append(raws, string(raw))
+		return nil
+	}); err != nil {
+		t.Fatalf("stream failed: %v", err)
+	}
+	if len(seqs) != 3 || seqs[0] != 1 || seqs[2] != 3 || raws[1] != `{"n":2}` {
+		t.Fatalf("unexpected stream: seqs=%v raws=%v", seqs, raws)
+	}
+
+	stop := errors.New("disk full")
+	visited := 0
+	err := store.StreamStepOutputs(workflowID, "page", func(int, json.RawMessage) error {
+		visited++
+		return stop
+	})
+	if !errors.Is(err, stop) || visited != 1 {
+		t.Fatalf("expected stream to stop after first error, visited=%d err=%v", visited, err)
+	}
+}
+
+func TestGetTotalOutputSizeIncreasesAfterStepCompletion(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	const workflowID = "wf-output-size"
+
+	before, err := store.GetTotalOutputSize(workflowID)
+	if err != nil || before != 0 {
+		t.Fatalf("expected empty workflow to have size 0, got %d err=%v", before, err)
+	}
+
+	ctx := NewContext(workflowID, store)
+	if _, err := Step(ctx, "payload", func() (string, error) { return "0123456789", nil }); err != nil {
+		t.Fatalf("step failed: %v", err)
+	}
+	if _, err := Step(NewContext("wf-output-size-other", store), "payload", func() (int, error) { return 7, nil }); err != nil {
+		t.Fatalf("other workflow step failed: %v", err)
+	}
+
+	after, err := store.GetTotalOutputSize(workflowID)
+	if err != nil {
+		t.Fatalf("size failed: %v", err)
+	}
+	if after != int64(len(`"0123456789"`)) {
+		t.Fatalf("unexpected workflow output size %d", after)
+	}
+	global, err := store.GetGlobalOutputSize()
+	if err != nil {
+		t.Fatalf("global size failed: %v", err)
+	}
+	if global != after+1 {
+		t.Fatalf("unexpected global output size %d", global)
+	}
+}
+
+func TestGetStepTimingParsesTimestamps(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	const workflowID = "wf-timing"
+
+	ctx := NewContext(workflowID, store)
+	if _, err := Step(ctx, "wait", func() (int, error) {
+		time.Sleep(20 * time.Millisecond)
+		return 1, nil
+	}); err != nil {
+		t.Fatalf("step failed: %v", err)
+	}
+	started, completed, found, err := store.GetStepTiming(workflowID, "wait#000001")
+	if err != nil || !found {
+		t.Fatalf("timing failed: found=%v err=%v", found, err)
+	}
+	if d := completed.Sub(started); d < 20*time.Millisecond {
+		t.Fatalf("expected duration of at least 20ms, got %v", d)
+	}
+
+	ref := ctx.nextStepRef("pending")
+	if err := store.UpsertRunning(workflowID, ref, ctx.RunID); err != nil {
+		t.Fatalf("seed running row failed: %v", err)
+	}
+	started, completed, found, err = store.GetStepTiming(workflowID, ref.StepKey)
+	if err != nil || !found || started.IsZero() || !completed.IsZero() {
+		t.Fatalf("expected running step with no completion time: %v %v found=%v err=%v", started, completed, found, err)
+	}
+
+	if _, _, found, err := store.GetStepTiming(workflowID, "missing#000001"); err != nil || found {
+		t.Fatalf("expected missing step to be not found, found=%v err=%v", found, err)
+	}
+}
+
+func TestWithSchemaVersionCheck(t *testing.T) {
+	dbPath := t.TempDir() + "/schema.db"
+
+	store, err := NewStore(dbPath, WithSchemaVersionCheck(currentSchemaVersion))
+	if err != nil {
+		t.Fatalf("open with current version failed: %v", err)
+	}
+	version, err := store.SchemaVersion()
+	if err != nil || version != currentSchemaVersion {
+		t.Fatalf("expected schema version %d, got %d err=%v", currentSchemaVersion, version, err)
+	}
+
+	if _, err := NewStore(dbPath, WithSchemaVersionCheck(currentSchemaVersion+1)); !errors.Is(err, ErrSchemaVersionTooOld) {
+		t.Fatalf("expected ErrSchemaVersionTooOld, got %v", err)
+	}
+	if _, err := NewStore(dbPath, WithSchemaVersionCheck(-1)); err == nil {
+		t.Fatalf("expected negative version to be rejected")
+	}
+
+	// A version this engine cannot create is refused before anything is opened.
+	unopened := t.TempDir() + "/future/schema.db"
+	if _, err := NewStore(unopened, WithSchemaVersionCheck(currentSchemaVersion+1)); !errors.Is(err, ErrSchemaVersionTooOld) {
+		t.Fatalf("expected ErrSchemaVersionTooOld, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Dir(unopened)); !os.IsNotExist(err) {
+		t.Fatalf("expected no database to be created, stat err=%v", err)
+	}
+}
+
+func TestMigrationsUpgradeOlderDatabasesOnce(t *testing.T) {
+	dir := t.TempDir()
+	legacy := map[string]string{
+		// Written by an engine that recorded version 1 before attempt_count existed.
+		"v1.db": `
+CREATE TABLE steps (workflow_id TEXT NOT NULL, step_key TEXT NOT NULL, step_id TEXT NOT NULL, sequence INTEGER NOT NULL,
+  status TEXT NOT NULL, output_json TEXT, error_text TEXT, run_id TEXT NOT NULL, started_at TEXT NOT NULL, updated_at TEXT NOT NULL,
+  completed_at TEXT, metadata_json TEXT, output_checksum TEXT, PRIMARY KEY (workflow_id, step_key));
+CREATE TABLE schema_migrations (version INTEGER NOT NULL PRIMARY KEY, applied_at TEXT NOT NULL);
+INSERT INTO schema_migrations VALUES (1, '2024-01-01T00:00:00Z');`,
+		// Written before schema_migrations and the later step columns existed.
+		"v0.db": `
+CREATE TABLE steps (workflow_id TEXT NOT NULL, step_key TEXT NOT NULL, step_id TEXT NOT NULL, sequence INTEGER NOT NULL,
+  status TEXT NOT NULL, output_json TEXT, error_text TEXT, run_id TEXT NOT NULL, started_at TEXT NOT NULL, updated_at TEXT NOT NULL,
+  PRIMARY KEY (workflow_id, step_key));`,
+	}
+	for name, ddl := range legacy {
+		path := filepath.Join(dir, name)
+		db, err := sql.Open("sqlite", path)
+		if err != nil {
+			t.Fatalf("%s: open: %v", name, err)
+		}
+		if _, err := db.Exec(ddl + `
+INSERT INTO steps(workflow_id, step_key, step_id, sequence, status, output_json, run_id, started_at, updated_at)
+VALUES ('wf-legacy', 'load#000001', 'load', 1, 'completed', '7', 'run-old', '2024-01-01T00:00:00Z', '2024-01-01T00:00:00Z');`); err != nil {
+			t.Fatalf("%s: seed: %v", name, err)
+		}
+		db.Close()
+
+		for i := 0; i < 2; i++ {
+			store, err := NewStore(path)
+			if err != nil {
+				t.Fatalf("%s: open %d: %v", name, i, err)
+			}
+			rows, err := store.queryRows("SELECT version FROM schema_migrations ORDER BY version;")
+			if err != nil || len(rows) != currentSchemaVersion {
+				t.Fatalf("%s: expected one row per migration, got %v err=%v", name, rows, err)
+			}
+			out, err := Step(NewContext("wf-legacy", store), "load", func() (int, error) { return 0, errors.New("must replay") })
+			if err != nil || out != 7 {
+				t.Fatalf("%s: expected legacy checkpoint to replay, got %d err=%v", name, out, err)
+			}
+			store.Close()
+		}
+	}
+
+	if last := schemaMigrations[len(schemaMigrations)-1].version; last != currentSchemaVersion {
+		t.Fatalf("currentSchemaVersion %d does not match last migration %d", currentSchemaVersion, last)
+	}
+}
+
+func TestGetStepHistoryKeepsOverwrittenAttempts(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	const workflowID = "wf-history"
+
+	for i := 1; i <= 4; i++ {
+		ctx := NewContext(workflowID, store)
+		_, err := Step(ctx, "flaky", func() (int, error) {
+			if i <= 3 {
+				return 0, fmt.Errorf("attempt %d failed", i)
+			}
+			return 42, nil
+		})
+		if (err == nil) != (i == 4) {
+			t.Fatalf("attempt %d: unexpected err=%v", i, err)
+		}
+	}
+
+	history, err := store.GetStepHistory(workflowID, "flaky#000001")
+	if err != nil {
+		t.Fatalf("history failed: %v", err)
+	}
+	if len(history) != 4 {
+		t.Fatalf("expected 3 overwritten attempts plus the current row, got %d", len(history))
+	}
+	for i, rec := range history[:3] {
+		if rec.Status != statusFailed || !strings.Contains(rec.ErrorText, fmt.Sprintf("attempt %d failed", i+1)) {
+			t.Fatalf("history[%d]: expected failed attempt %d, got %+v", i, i+1, rec)
+		}
+	}
+	for i, rec := range history {
+		if rec.AttemptCount != i+1 {
+			t.Fatalf("history[%d]: expected attempt_count %d, got %d", i, i+1, rec.AttemptCount)
+		}
+	}
+	if last := history[3]; last.Status != statusCompleted || last.OutputJSON != "42" {
+		t.Fatalf("expected completed current row, got %+v", last)
+	}
+	runs := map[string]bool{}
+	for _, rec := range history {
+		runs[rec.RunID] = true
+	}
+	if len(runs) != 4 {
+		t.Fatalf("expected each attempt to keep its own run id, got %v", runs)
+	}
+}
+
+func TestListFailedAndRunningStepsUseSequenceOrder(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	const workflowID = "wf-list-status"
+
+	ctx := NewContext(workflowID, store)
+	for i := 0; i < 2; i++ {
+		_, _ = Step(ctx, "upload", func() (int, error) { return 0, errors.New("timeout") })
+		_, _ = Step(ctx, "audit", func() (int, error) { return 0, errors.New("denied") })
+		if _, err := Step(ctx, "ok", func() (int, error) { return i, nil }); err != nil {
+			t.Fatalf("ok step failed: %v", err)
+		}
+	}
+	if err := store.UpsertRunning(workflowID, ctx.nextStepRef("sync"), ctx.RunID); err != nil {
+		t.Fatalf("seed running row failed: %v", err)
+	}
+
+	keys := func(records []StepRecord) string {
+		out := make([]string, len(records))
+		for i, r := range records {
+			out[i] = r.StepKey
+		}
+		return strings.Join(out, ",")
+	}
+	failed, err := store.ListFailedSteps(workflowID)
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if got, want := keys(failed), "audit#000001,upload#000001,audit#000002,upload#000002"; got != want {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+	running, err := store.ListRunningSteps(workflowID)
+	if err != nil || keys(running) != "sync#000001" {
+		t.Fatalf("expected the running sync step, got %s err=%v", keys(running), err)
+	}
+	all, err := store.ListSteps(workflowID)
+	if err != nil || len(all) != 7 || all[4].StepKey != "audit#000002" {
+		t.Fatalf("expected ListSteps in sequence order, got %s err=%v", keys(all), err)
+	}
+}
+
+func TestReadCacheServesCompletedStepsWithoutQuerying(t *testing.T) {
+	if _, err := NewStore(t.TempDir()+"/bad.db", WithReadCache(0)); err == nil {
+		t.Fatalf("expected a zero-sized read cache to be rejected")
+	}
+	store, err := NewStore(t.TempDir()+"/cache.db", WithReadCache(2))
+	if err != nil {
+		t.Fatalf("new store failed: %v", err)
+	}
+	const workflowID = "wf-read-cache"
+
+	ctx := NewContext(workflowID, store)
+	for _, id := range []string{"a", "b", "c"} {
+		if _, err := Step(ctx, id, func() (string, error) { return id, nil }); err != nil {
+			t.Fatalf("step %s failed: %v", id, err)
+		}
+	}
+	_, _ = Step(ctx, "broken", func() (int, error) { return 0, errors.New("boom") })
+
+	// Remove the rows behind the cache's back: cached reads must not notice.
+	if err := store.execWrite("DELETE FROM steps WHERE workflow_id='wf-read-cache';"); err != nil {
+		t.Fatalf("delete rows failed: %v", err)
+	}
+	for _, key := range []string{"b#000001", "c#000001"} {
+		if _, found, err := store.GetStep(workflowID, key); err != nil || !found {
+			t.Fatalf("expected %s from the cache, found=%v err=%v", key, found, err)
+		}
+	}
+	// Capacity 2: the oldest completion and the failed step were not kept.
+	for _, key := range []string{"a#000001", "broken#000001"} {
+		if _, found, _ := store.GetStep(workflowID, key); found {
+			t.Fatalf("expected %s not to be cached", key)
+		}
+	}
+
+	if err := store.ResetStep(workflowID, "c#000001"); !errors.Is(err, ErrStepNotFound) {
+		t.Fatalf("expected ErrStepNotFound for the deleted row, got %v", err)
+	}
+	if _, found, _ := store.GetStep(workflowID, "c#000001"); found {
+		t.Fatalf("expected ResetStep to invalidate the cached row")
+	}
+}
+
+func TestDialectUpsertRunningLeavesTransactionToCaller(t *testing.T) {
+	ref := StepRef{StepID: "a", Sequence: 1, StepKey: "a#000001"}
+	for _, d := range []Dialect{SQLiteDialect{}, PostgresDialect{}} {
+		for _, st := range d.UpsertRunningSQL("wf", ref, "run", "2024-01-01T00:00:00Z", "2024-01-01T00:00:00Z") {
+			sql := strings.ToUpper(st.Query)
+			// A COMMIT here would end the transaction of a surrounding WriteBatch.
+			if strings.Contains(sql, "BEGIN") || strings.Contains(sql, "COMMIT") {
+				t.Fatalf("%s UpsertRunningSQL must not control the transaction:\n%s", d.Name(), sql)
+			}
+			// Values are bound, never spliced into the SQL.
+			if strings.Contains(st.Query, "'wf'") || strings.Contains(st.Query, "'run'") {
+				t.Fatalf("%s UpsertRunningSQL inlines a value:\n%s", d.Name(), st.Query)
+			}
+		}
+	}
+}
+
+func TestClaimDoesNotTakeOverLiveStep(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	workflowID := "wf-claim-race"
+
+	// Both runs loaded the step before either claimed it.
+	first := NewContext(workflowID, store).WithZombieTimeout(time.Hour)
+	second := NewContext(workflowID, store).WithZombieTimeout(time.Hour)
+	ref := first.nextStepRef("charge")
+	if err := first.claimRunning(ref); err != nil {
+		t.Fatalf("first claim failed: %v", err)
+	}
+	if err := second.claimRunning(ref); !errors.Is(err, ErrStepStillRunning) {
+		t.Fatalf("expected the second claim to lose, got %v", err)
+	}
+	record, _, err := store.GetStep(workflowID, ref.StepKey)
+	if err != nil {
+		t.Fatalf("load step failed: %v", err)
+	}
+	if record.RunID != first.RunID || record.AttemptCount != 1 {
+		t.Fatalf("expected the first run to keep the step with one attempt, got %+v", record)
+	}
+	if attempts, _ := store.GetStepAttemptCount(workflowID, ref.StepKey); attempts != 1 {
+		t.Fatalf("expected the lost claim to record no attempt, got %d", attempts)
+	}
+}
+
+func TestNewStoreOptions(t *testing.T) {
+	store, err := NewStore(t.TempDir()+"/opts.db", WithBusyTimeout(time.Second), WithMaxRetries(3), WithRetryBackoff(time.Millisecond))
+	if err != nil {
+		t.Fatalf("open with options failed: %v", err)
+	}
+	if store.maxRetries != 3 || store.retryBackoff != time.Millisecond {
+		t.Fatalf("options not applied: retries=%d backoff=%s", store.maxRetries, store.retryBackoff)
+	}
+	rows, err := store.queryRows("PRAGMA busy_timeout;")
+	if err != nil || len(rows) != 1 || asInt(rows[0]["timeout"]) != 1000 {
+		t.Fatalf("expected busy_timeout 1000, got %v err=%v", rows, err)
+	}
+
+	defaults, err := NewStore(t.TempDir() + "/defaults.db")
+	if err != nil {
+		t.Fatalf("open with defaults failed: %v", err)
+	}
+	if defaults.maxRetries != 8 || defaults.retryBackoff != 25*time.Millisecond {
+		t.Fatalf("unexpected defaults: retries=%d backoff=%s", defaults.maxRetries, defaults.retryBackoff)
+	}
+
+	for name, opt := range map[string]StoreOption{
+		"negative timeout": WithBusyTimeout(-time.Second),
+		"zero retries":     WithMaxRetries(0),
+		"negative backoff": WithRetryBackoff(-time.Millisecond),
+	} {
+		if _, err := NewStore(t.TempDir()+"/bad.db", opt); err == nil {
+			t.Fatalf("%s: expected option to be rejected", name)
+		}
+	}
+}
+
+func TestLoadStepCountersMatchesInMemoryCounters(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	const workflowID = "wf-load-counters"
+
+	ctx := NewContext(workflowID, store)
+	for _, id := range []string{"fetch", "fetch", "parse", "fetch", "store", "parse"} {
+		if _, err := Step(ctx, id, func() (string, error) { return id, nil }); err != nil {
+			t.Fatalf("step %s failed: %v", id, err)
+		}
+	}
+
+	counters, err := store.LoadStepCounters(workflowID)
+	if err != nil {
+		t.Fatalf("load counters failed: %v", err)
+	}
+	inMemory := ctx.counter.(*sequentialCounter).counts
+	if len(counters) != len(inMemory) {
+		t.Fatalf("expected %d step ids, got %v", len(inMemory), counters)
+	}
+	for id, seq := range inMemory {
+		if counters[id] != seq {
+			t.Fatalf("step id %s: store has %d, context has %d", id, counters[id], seq)
+		}
+	}
+
+	empty, err := store.LoadStepCounters("wf-load-counters-none")
+	if err != nil || len(empty) != 0 {
+		t.Fatalf("expected no counters for unknown workflow, got %v err=%v", empty, err)
+	}
+}
+
+func TestGetWorkflowProgressCountsByStatus(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	const workflowID = "wf-progress"
+
+	ctx := NewContext(workflowID, store)
+	for _, id := range []string{"a", "b"} {
+		if _, err := Step(ctx, id, func() (int, error) { return 1, nil }); err != nil {
+			t.Fatalf("step %s failed: %v", id, err)
+		}
+	}
+	_, _ = Step(ctx, "c", func() (int, error) { return 0, errors.New("nope") })
+	if err := store.UpsertRunning(workflowID, ctx.nextStepRef("d"), ctx.RunID); err != nil {
+		t.Fatalf("seed running row failed: %v", err)
+	}
+
+	completed, total, err := store.GetWorkflowProgress(workflowID)
+	if err != nil {
+		t.Fatalf("progress failed: %v", err)
+	}
+	if completed != 2 || total != 4 {
+		t.Fatalf("expected 2/4, got %d/%d", completed, total)
+	}
+}
+
+func TestGetWorkflowSummaryCountsMixedStatuses(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	const workflowID = "wf-summary"
+
+	ctx := NewContext(workflowID, store)
+	for _, id := range []string{"a", "b", "c"} {
+		if _, err := Step(ctx, id, func() (int, error) { return 1, nil }); err != nil {
+			t.Fatalf("step %s failed: %v", id, err)
+		}
+	}
+	_, _ = Step(ctx, "d", func() (int, error) { return 0, errors.New("nope") })
+
+	summary, err := store.GetWorkflowSummary(workflowID)
+	if err != nil {
+		t.Fatalf("summary failed: %v", err)
+	}
+	if summary.TotalSteps != 4 || summary.CompletedSteps != 3 || summary.FailedSteps != 1 || summary.RunningSteps != 0 || summary.Status != statusFailed {
+		t.Fatalf("unexpected summary: %+v", summary)
+	}
+
+	if err := store.UpsertRunning(workflowID, ctx.nextStepRef("e"), ctx.RunID); err != nil {
+		t.Fatalf("seed running row failed: %v", err)
+	}
+	summary, err = store.GetWorkflowSummary(workflowID)
+	if err != nil {
+		t.Fatalf("summary failed: %v", err)
+	}
+	if summary.WorkflowID != workflowID || summary.TotalSteps != 5 || summary.RunningSteps != 1 || summary.Status != statusRunning {
+		t.Fatalf("unexpected summary: %+v", summary)
+	}
+	started, err := time.Parse(time.RFC3339Nano, summary.StartedAt)
+	if err != nil {
+		t.Fatalf("parse started_at %q: %v", summary.StartedAt, err)
+	}
+	updated, err := time.Parse(time.RFC3339Nano, summary.LastUpdatedAt)
+	if err != nil {
+		t.Fatalf("parse last_updated_at %q: %v", summary.LastUpdatedAt, err)
+	}
+	if updated.Before(started) {
+		t.Fatalf("expected last update %s not before start %s", updated, started)
+	}
+
+	if _, err := store.GetWorkflowSummary("wf-missing"); !errors.Is(err, ErrWorkflowNotFound) {
+		t.Fatalf("expected ErrWorkflowNotFound, got %v", err)
+	}
+}
+
+func TestExportImportWorkflowRoundTrip(t *testing.T) {
+	src := newSQLiteTestStore(t)
+	const workflowID = "wf-export"
+
+	ctx := NewContext(workflowID, src)
+	for i := 0; i < 19; i++ {
+		if _, err := Step(ctx, "page", func() (map[string]int, error) { return map[string]int{"page": i}, nil }); err != nil {
+			t.Fatalf("page %d failed: %v", i, err)
+		}
+	}
+	_, _ = Step(ctx, "publish", func() (int, error) { return 0, errors.New("broker down") })
+
+	var snapshot bytes.Buffer
+	if err := src.ExportWorkflow(workflowID, &snapshot); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	want, err := src.ListSteps(workflowID)
+	if err != nil || len(want) != 20 {
+		t.Fatalf("expected 20 source steps, got %d err=%v", len(want), err)
+	}
+
+	dst := newSQLiteTestStore(t)
+	for i := 0; i < 2; i++ {
+		if err := dst.ImportWorkflow(bytes.NewReader(snapshot.Bytes())); err != nil {
+			t.Fatalf("import %d failed: %v", i, err)
+		}
+	}
+	got, err := dst.ListSteps(workflowID)
+	if err != nil {
+		t.Fatalf("list imported steps failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("imported steps differ:\n got %+v\nwant %+v", got, want)
+	}
+
+	mixed := `[{"WorkflowID":"wf-a","StepKey":"a#000001"},{"WorkflowID":"wf-b","StepKey":"b#000001"}]`
+	if err := dst.ImportWorkflow(strings.NewReader(mixed)); err == nil {
+		t.Fatalf("expected snapshot spanning workflows to be rejected")
+	}
+}
+
+func TestWatchStepEmitsChangesUntilTerminal(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	const workflowID = "wf-watch"
+
+	ctx := NewContext(workflowID, store)
+	ref := ctx.nextStepRef("export")
+	if err := store.UpsertRunning(workflowID, ref, ctx.RunID); err != nil {
+		t.Fatalf("seed running row failed: %v", err)
+	}
+
+	goCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	updates := store.WatchStep(goCtx, workflowID, ref.StepKey, time.Millisecond)
+
+	first := <-updates
+	if first.Status != statusRunning {
+		t.Fatalf("expected running status first, got %s", first.Status)
+	}
+	if err := store.MarkCompleted(workflowID, ref.StepKey, ctx.RunID, `"done"`); err != nil {
+		t.Fatalf("complete failed: %v", err)
+	}
+
+	var seen []StepRecord
+	for record := range updates {
+		seen = append(seen, record)
+	}
+	if goCtx.Err() != nil {
+		t.Fatalf("watch did not close on terminal status")
+	}
+	if len(seen) != 1 || seen[0].Status != statusCompleted || seen[0].OutputJSON != `"done"` {
+		t.Fatalf("unexpected updates after completion: %+v", seen)
+	}
+
+	stopCtx, stop := context.WithCancel(context.Background())
+	idle := store.WatchStep(stopCtx, workflowID, "missing#000001", time.Millisecond)
+	stop()
+	for range idle {
+		t.Fatalf("expected no updates for missing step")
+	}
+}
+
+func TestListDistinctStepIDsIsSorted(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	const workflowID = "wf-distinct-ids"
+
+	ctx := NewContext(workflowID, store)
+	for _, id := range []string{"send", "fetch", "send", "archive", "fetch"} {
+		if _, err := Step(ctx, id, func() (string, error) { return id, nil }); err != nil {
+			t.Fatalf("step %s failed: %v", id, err)
+		}
+	}
+
+	ids, err := store.ListDistinctStepIDs(workflowID)
+	if err != nil {
+		t.Fatalf("list ids failed: %v", err)
+	}
+	if strings.Join(ids, ",") != "archive,fetch,send" {
+		t.Fatalf("unexpected step ids: %v", ids)
+	}
+}
+
+func TestTableSizeReportsPages(t *testing.T) {
+	store := newSQLiteTestStore(t)
+
+	if err := RunWorkflow(store, "wf-table-size", func(ctx *Context) error {
+		for i := 0; i < 3; i++ {
+			if _, err := Step(ctx, "chunk", func() (string, error) { return strings.Repeat("x", 512), nil }); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("seed workflow failed: %v", err)
+	}
+
+	report, err := store.TableSize()
+	if err != nil {
+		t.Fatalf("table size failed: %v", err)
+	}
+	if report.StepsRowCount != 3 || report.WorkflowsRowCount != 1 {
+		t.Fatalf("unexpected row counts: %+v", report)
+	}
+	if report.StepsDataBytes <= 0 || report.StepsIndexBytes <= 0 || report.WorkflowsDataBytes <= 0 {
+		t.Fatalf("expected positive page sizes: %+v", report)
+	}
+}
+
+func TestMemoryStoreMatchesSQLiteSemantics(t *testing.T) {
+	backends := map[string]StoreBackend{
+		"sqlite": newSQLiteTestStore(t),
+		"memory": NewMemoryStore(),
+	}
+	for name, backend := range backends {
+		t.Run(name, func(t *testing.T) {
+			const workflowID = "wf-backend-semantics"
+			ref := StepRef{StepID: "charge", Sequence: 1, StepKey: "charge#000001"}
+
+			if err := backend.UpsertRunning(workflowID, ref, "run-1"); err != nil {
+				t.Fatalf("claim failed: %v", err)
+			}
+			if err := backend.MarkFailed(workflowID, ref.StepKey, "run-1", "declined"); err != nil {
+				t.Fatalf("mark failed failed: %v", err)
+			}
+			if err := backend.UpsertRunning(workflowID, ref, "run-2"); err != nil {
+				t.Fatalf("retry claim failed: %v", err)
+			}
+			record, _, _ := backend.GetStep(workflowID, ref.StepKey)
+			if record.Status != statusRunning || record.RunID != "run-2" || record.ErrorText != "" {
+				t.Fatalf("expected retry to reset the step, got %+v", record)
+			}
+
+			if err := backend.MarkCompleted(workflowID, ref.StepKey, "run-2", `{"ok":true}`); err != nil {
+				t.Fatalf("mark completed failed: %v", err)
+			}
+			// A completed step is never overwritten by a later claim.
+			if err := backend.UpsertRunning(workflowID, ref, "run-3"); err != nil {
+				t.Fatalf("claim of completed step failed: %v", err)
+			}
+			record, found, err := backend.GetStep(workflowID, ref.StepKey)
+			if err != nil || !found {
+				t.Fatalf("get failed: found=%v err=%v", found, err)
+			}
+			if record.Status != statusCompleted || record.RunID != "run-2" || record.OutputJSON != `{"ok":true}` || record.OutputChecksum != outputChecksum(`{"ok":true}`) {
+				t.Fatalf("completed step was modified: %+v", record)
+			}
+
+			// Updates to unknown steps are no-ops, as with an UPDATE matching nothing.
+			if err := backend.MarkCompleted(workflowID, "ghost#000001", "run-2", "1"); err != nil {
+				t.Fatalf("mark unknown step failed: %v", err)
+			}
+			if _, found, _ := backend.GetStep(workflowID, "ghost#000001"); found {
+				t.Fatalf("expected unknown step to stay absent")
+			}
+
+			calls := 0
+			for i := 0; i < 2; i++ {
+				ctx := NewContext(workflowID, backend)
+				if _, err := Step(ctx, "ship", func() (string, error) { calls++; return "parcel", nil }); err != nil {
+					t.Fatalf("step failed: %v", err)
+				}
+			}
+			if calls != 1 {
+				t.Fatalf("expected step to replay, executed %d times", calls)
+			}
+			steps, err := backend.ListSteps(workflowID)
+			if err != nil || len(steps) != 2 || steps[0].StepKey != "charge#000001" || steps[1].StepKey != "ship#000001" {
+				t.Fatalf("unexpected steps: %+v err=%v", steps, err)
+			}
+		})
+	}
+}
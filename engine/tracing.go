@@ -0,0 +1,39 @@
+package engine
+
+import (
+	"context"
+	"strconv"
+)
+
+// Tracer is a minimal tracing hook. StartSpan returns the span's context and
+// a function that ends the span with the step's outcome.
+type Tracer interface {
+	StartSpan(goCtx context.Context, name string, attrs map[string]string) (context.Context, func(error))
+}
+
+func (c *Context) WithTracer(t Tracer) *Context {
+	c.tracer = t
+	return c
+}
+
+// startSpan opens the tracing span for a step. Executed steps get a span
+// around fn with the span's context passed on; replayed steps get a span with
+// step.cached=true that ends immediately, which tracers may drop.
+func (c *Context) startSpan(goCtx context.Context, ref StepRef, cached bool) (context.Context, func(error)) {
+	if c.tracer == nil {
+		return goCtx, func(error) {}
+	}
+	spanCtx, end := c.tracer.StartSpan(goCtx, "durable.step", map[string]string{
+		"workflow.id": c.WorkflowID,
+		"step.key":    ref.StepKey,
+		"step.run_id": c.RunID,
+		"step.cached": strconv.FormatBool(cached),
+	})
+	if spanCtx == nil {
+		spanCtx = goCtx
+	}
+	if end == nil {
+		end = func(error) {}
+	}
+	return spanCtx, end
+}
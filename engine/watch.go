@@ -0,0 +1,50 @@
+package engine
+
+import (
+	"context"
+	"time"
+)
+
+// minWatchInterval keeps watchers from turning into a busy loop against the
+// database.
+const minWatchInterval = 10 * time.Millisecond
+
+// WatchStep polls a step every pollInterval (at least 10ms) and sends its
+// record whenever the status or updated_at changes. The channel is closed
+// when goCtx is done or after the step has been seen completed or failed.
+// Read errors are skipped and retried on the next poll.
+func (s *Store) WatchStep(goCtx context.Context, workflowID, stepKey string, pollInterval time.Duration) <-chan StepRecord {
+	if pollInterval < minWatchInterval {
+		pollInterval = minWatchInterval
+	}
+	ch := make(chan StepRecord)
+
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+
+		var last StepRecord
+		for {
+			record, found, err := s.GetStep(workflowID, stepKey)
+			if err == nil && found && (record.Status != last.Status || record.UpdatedAt != last.UpdatedAt) {
+				select {
+				case ch <- record:
+				case <-goCtx.Done():
+					return
+				}
+				last = record
+				if record.Status == statusCompleted || record.Status == statusFailed {
+					return
+				}
+			}
+
+			select {
+			case <-ticker.C:
+			case <-goCtx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
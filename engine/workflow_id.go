@@ -0,0 +1,80 @@
+package engine
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+const crockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+var ulidState struct {
+	mu      sync.Mutex
+	lastMS  uint64
+	entropy [10]byte
+}
+
+// GenerateWorkflowID returns a ULID, optionally prefixed with "prefix-". IDs
+// sort lexicographically by creation time; within one millisecond the random
+// part is incremented so ordering holds for IDs from the same process.
+func GenerateWorkflowID(prefix string) string {
+	id := newULID(time.Now())
+	if prefix == "" {
+		return id
+	}
+	return prefix + "-" + id
+}
+
+func RunWorkflowAutoID(store *Store, prefix string, fn WorkflowFunc) (string, error) {
+	workflowID := GenerateWorkflowID(prefix)
+	return workflowID, RunWorkflow(store, workflowID, fn)
+}
+
+func newULID(now time.Time) string {
+	ms := uint64(now.UnixMilli())
+
+	ulidState.mu.Lock()
+	if ms <= ulidState.lastMS {
+		ms = ulidState.lastMS
+		incrementEntropy(&ulidState.entropy)
+	} else {
+		ulidState.lastMS = ms
+		if _, err := rand.Read(ulidState.entropy[:]); err != nil {
+			binary.BigEndian.PutUint64(ulidState.entropy[2:], uint64(now.UnixNano()))
+		}
+	}
+	var raw [16]byte
+	binary.BigEndian.PutUint16(raw[0:], uint16(ms>>32))
+	binary.BigEndian.PutUint32(raw[2:], uint32(ms))
+	copy(raw[6:], ulidState.entropy[:])
+	ulidState.mu.Unlock()
+
+	return encodeULID(raw)
+}
+
+func incrementEntropy(e *[10]byte) {
+	for i := len(e) - 1; i >= 0; i-- {
+		e[i]++
+		if e[i] != 0 {
+			return
+		}
+	}
+}
+
+// encodeULID renders 128 bits as 26 Crockford base32 characters; the first
+// character carries only three bits.
+func encodeULID(raw [16]byte) string {
+	out := make([]byte, 26)
+	for i := range out {
+		var v byte
+		for j := 0; j < 5; j++ {
+			v <<= 1
+			if p := i*5 + j - 2; p >= 0 {
+				v |= (raw[p/8] >> (7 - p%8)) & 1
+			}
+		}
+		out[i] = crockfordAlphabet[v]
+	}
+	return string(out)
+}
@@ -0,0 +1,125 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const workflowMetadataDDL = `
+CREATE TABLE IF NOT EXISTS workflow_metadata (
+  workflow_id TEXT NOT NULL,
+  key TEXT NOT NULL,
+  value TEXT NOT NULL,
+  updated_at TEXT NOT NULL,
+  PRIMARY KEY (workflow_id, key)
+);`
+
+func createWorkflowMetadata(*Store) ([]string, error) {
+	return []string{workflowMetadataDDL}, nil
+}
+
+// workflowMetadataStore is implemented by backends that persist workflow
+// metadata. RunWorkflow loads it at start and WithMetadata writes through it.
+type workflowMetadataStore interface {
+	GetWorkflowMetadata(workflowID string) (map[string]string, error)
+	UpsertWorkflowMetadata(workflowID string, meta map[string]string) error
+}
+
+// WithMetadata attaches key=value to the workflow run. Backends that
+// support it persist the pair immediately; a failed write is logged and the
+// pair is still kept in memory.
+func (c *Context) WithMetadata(key, value string) *Context {
+	c.metaMu.Lock()
+	if c.metadata == nil {
+		c.metadata = make(map[string]string)
+	}
+	c.metadata[key] = value
+	c.metaMu.Unlock()
+
+	if store, ok := c.backend.(workflowMetadataStore); ok {
+		if err := store.UpsertWorkflowMetadata(c.WorkflowID, map[string]string{key: value}); err != nil {
+			c.Log(LogLevelWarn, "persist workflow metadata failed", map[string]any{"key": key, "error": err.Error()})
+		}
+	}
+	return c
+}
+
+// Metadata returns a copy of the run's metadata, including pairs persisted by
+// earlier runs of the workflow when it was started by RunWorkflow.
+func (c *Context) Metadata() map[string]string {
+	c.metaMu.Lock()
+	defer c.metaMu.Unlock()
+	out := make(map[string]string, len(c.metadata))
+	for k, v := range c.metadata {
+		out[k] = v
+	}
+	return out
+}
+
+// loadMetadata seeds the context with the workflow's persisted metadata.
+func (c *Context) loadMetadata() error {
+	store, ok := c.backend.(workflowMetadataStore)
+	if !ok {
+		return nil
+	}
+	meta, err := store.GetWorkflowMetadata(c.WorkflowID)
+	if err != nil {
+		return err
+	}
+	c.metaMu.Lock()
+	defer c.metaMu.Unlock()
+	if c.metadata == nil {
+		c.metadata = make(map[string]string, len(meta))
+	}
+	for k, v := range meta {
+		if _, set := c.metadata[k]; !set {
+			c.metadata[k] = v
+		}
+	}
+	return nil
+}
+
+// UpsertWorkflowMetadata sets each key in meta for the workflow in one
+// transaction. Keys not in meta are left unchanged.
+func (s *Store) UpsertWorkflowMetadata(workflowID string, meta map[string]string) error {
+	if len(meta) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(meta))
+	for k := range meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	stmts := make([]Statement, 0, len(keys))
+	for _, k := range keys {
+		stmts = append(stmts, Statement{Query: `
+INSERT INTO workflow_metadata(workflow_id, key, value, updated_at)
+VALUES($1, $2, $3, $4)
+ON CONFLICT(workflow_id, key) DO UPDATE SET
+  value=excluded.value,
+  updated_at=excluded.updated_at;`,
+			Args: []any{workflowID, k, meta[k], now},
+		})
+	}
+	if err := s.execTx(context.Background(), stmts); err != nil {
+		return fmt.Errorf("upsert metadata for workflow %s: %w", workflowID, err)
+	}
+	return nil
+}
+
+// GetWorkflowMetadata returns the workflow's metadata, empty if it has none.
+func (s *Store) GetWorkflowMetadata(workflowID string) (map[string]string, error) {
+	rows, err := s.queryRows("SELECT key, value FROM workflow_metadata WHERE workflow_id=$1;", workflowID)
+	if err != nil {
+		return nil, fmt.Errorf("get metadata for workflow %s: %w", workflowID, err)
+	}
+	meta := make(map[string]string, len(rows))
+	for _, row := range rows {
+		meta[asString(row["key"])] = asString(row["value"])
+	}
+	return meta, nil
+}
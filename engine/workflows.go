@@ -0,0 +1,316 @@
+package engine
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ErrWorkflowNotFound = errors.New("workflow not found")
+
+type WorkflowRecord struct {
+	WorkflowID   string
+	RunID        string
+	Status       string
+	InputJSON    string
+	MetadataJSON string
+	Priority     int
+	CreatedAt    string
+	UpdatedAt    string
+}
+
+const workflowColumns = "workflow_id, run_id, status, input_json, metadata_json, priority, created_at, updated_at"
+
+func (s *Store) MarkWorkflowRunning(workflowID, runID string) error {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	return s.execWrite(`
+INSERT INTO workflows(workflow_id, run_id, status, created_at, updated_at)
+VALUES($1, $2, $3, $4, $4)
+ON CONFLICT(workflow_id) DO UPDATE SET
+  run_id=excluded.run_id,
+  status=excluded.status,
+  updated_at=excluded.updated_at;`,
+		workflowID,
+		runID,
+		statusRunning,
+		now,
+	)
+}
+
+func (s *Store) MarkWorkflowStatus(workflowID, runID, status string) error {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	return s.execWrite(`
+UPDATE workflows
+SET status=$1,
+    updated_at=$2
+WHERE workflow_id=$3 AND run_id=$4;`,
+		status,
+		now,
+		workflowID,
+		runID,
+	)
+}
+
+// GetUnclaimedWorkflows returns running workflows that nothing has touched
+// for staleAfter. There is no lease table; the newest of the workflow row's
+// and its steps' updated_at is treated as the owner's heartbeat.
+func (s *Store) GetUnclaimedWorkflows(staleAfter time.Duration, limit int) ([]WorkflowRecord, error) {
+	cutoff := time.Now().Add(-staleAfter)
+	args := []any{statusRunning, sqlTime(cutoff)}
+	q := `
+SELECT ` + workflowColumns + `
+FROM workflows w
+WHERE w.status=$1
+  AND MAX(
+        julianday(w.updated_at),
+        COALESCE((SELECT MAX(julianday(st.updated_at)) FROM steps st WHERE st.workflow_id=w.workflow_id), 0)
+      ) < julianday($2)
+ORDER BY w.priority DESC, w.created_at ASC`
+	if limit > 0 {
+		q += "\nLIMIT $3"
+		args = append(args, limit)
+	}
+	return s.queryWorkflowRecords(q+";", args...)
+}
+
+func (s *Store) GetWorkflowRecord(workflowID string) (WorkflowRecord, bool, error) {
+	records, err := s.queryWorkflowRecords(`
+SELECT `+workflowColumns+`
+FROM workflows
+WHERE workflow_id=$1
+LIMIT 1;`, workflowID)
+	if err != nil {
+		return WorkflowRecord{}, false, err
+	}
+	if len(records) == 0 {
+		return WorkflowRecord{}, false, nil
+	}
+	return records[0], true, nil
+}
+
+// ListWorkflowsWithStatus pages through workflows in status, most recently
+// updated first.
+func (s *Store) ListWorkflowsWithStatus(status string, limit, offset int) ([]WorkflowRecord, error) {
+	switch status {
+	case statusRunning, statusCompleted, statusFailed, statusPaused, statusCancelled:
+	default:
+		return nil, fmt.Errorf("unknown workflow status %q", status)
+	}
+
+	args := []any{status}
+	q := `
+SELECT ` + workflowColumns + `
+FROM workflows
+WHERE status=$1
+ORDER BY julianday(updated_at) DESC, updated_at DESC, workflow_id`
+	if limit > 0 || offset > 0 {
+		if limit <= 0 {
+			limit = -1
+		}
+		q += "\nLIMIT $2 OFFSET $3"
+		args = append(args, limit, offset)
+	}
+	return s.queryWorkflowRecords(q+";", args...)
+}
+
+// WorkflowFilter narrows ListWorkflowsPaged. Zero-valued fields are ignored.
+type WorkflowFilter struct {
+	Status        string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+}
+
+const defaultWorkflowPageSize = 100
+
+// ListWorkflowsPaged lists workflows oldest first, limit at a time. Pass the
+// returned nextCursor back to fetch the following page; it is empty after the
+// last page. Pages are keyed on (created_at, workflow_id) rather than an
+// offset, so workflows created between fetches neither shift nor repeat rows.
+func (s *Store) ListWorkflowsPaged(cursor string, limit int, filter WorkflowFilter) ([]WorkflowRecord, string, error) {
+	if limit <= 0 {
+		limit = defaultWorkflowPageSize
+	}
+
+	var args sqlArgs
+	var conds []string
+	if filter.Status != "" {
+		conds = append(conds, "status="+args.add(filter.Status))
+	}
+	if !filter.CreatedAfter.IsZero() {
+		conds = append(conds, "julianday(created_at) > julianday("+args.add(sqlTime(filter.CreatedAfter))+")")
+	}
+	if !filter.CreatedBefore.IsZero() {
+		conds = append(conds, "julianday(created_at) < julianday("+args.add(sqlTime(filter.CreatedBefore))+")")
+	}
+	if cursor != "" {
+		createdAt, workflowID, err := decodeWorkflowCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		conds = append(conds, fmt.Sprintf(
+			"(julianday(created_at) > julianday(%[1]s) OR (julianday(created_at) = julianday(%[1]s) AND workflow_id > %[2]s))",
+			args.add(createdAt), args.add(workflowID)))
+	}
+
+	q := "\nSELECT " + workflowColumns + "\nFROM workflows"
+	if len(conds) > 0 {
+		q += "\nWHERE " + strings.Join(conds, " AND ")
+	}
+	// One extra row tells us whether another page exists.
+	q += "\nORDER BY julianday(created_at), workflow_id\nLIMIT " + args.add(limit+1) + ";"
+
+	page, err := s.queryWorkflowRecords(q, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(page) <= limit {
+		return page, "", nil
+	}
+	page = page[:limit]
+	last := page[len(page)-1]
+	return page, encodeWorkflowCursor(last.CreatedAt, last.WorkflowID), nil
+}
+
+func encodeWorkflowCursor(createdAt, workflowID string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(createdAt + "\x00" + workflowID))
+}
+
+func decodeWorkflowCursor(cursor string) (createdAt, workflowID string, err error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid workflow cursor: %w", err)
+	}
+	createdAt, workflowID, ok := strings.Cut(string(raw), "\x00")
+	if !ok {
+		return "", "", fmt.Errorf("invalid workflow cursor %q", cursor)
+	}
+	return createdAt, workflowID, nil
+}
+
+// forEachWorkflowPageSize bounds how many workflow rows ForEachWorkflow holds
+// in memory at once.
+const forEachWorkflowPageSize = 500
+
+// ForEachWorkflow calls fn for every workflow in workflow_id order, stopping at
+// the first error fn returns. Rows are read in keyset-paginated pages rather
+// than through an open cursor, so fn may write to the store while iterating.
+func (s *Store) ForEachWorkflow(fn func(WorkflowRecord) error) error {
+	after := ""
+	for {
+		page, err := s.queryWorkflowRecords(`
+SELECT `+workflowColumns+`
+FROM workflows
+WHERE workflow_id > $1
+ORDER BY workflow_id
+LIMIT $2;`, after, forEachWorkflowPageSize)
+		if err != nil {
+			return err
+		}
+		for _, wf := range page {
+			if err := fn(wf); err != nil {
+				return err
+			}
+		}
+		if len(page) < forEachWorkflowPageSize {
+			return nil
+		}
+		after = page[len(page)-1].WorkflowID
+	}
+}
+
+// GetWorkflowsByRunID returns the workflows that have a step last owned by
+// runID, which is what a crashed worker was executing.
+func (s *Store) GetWorkflowsByRunID(runID string) ([]string, error) {
+	rows, err := s.queryRows(`
+SELECT DISTINCT workflow_id
+FROM steps
+WHERE run_id=$1
+ORDER BY workflow_id;`, runID)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(rows))
+	for _, row := range rows {
+		ids = append(ids, asString(row["workflow_id"]))
+	}
+	return ids, nil
+}
+
+// ListWorkflowIDs returns the distinct ids of workflows with steps, in order.
+// filter selects by the state of those steps: "running" if any step is
+// running, "failed" if one failed and none is running, "completed" if all
+// completed, or "" for every workflow.
+func (s *Store) ListWorkflowIDs(filter string) ([]string, error) {
+	const running = "SUM(CASE WHEN status=$1 THEN 1 ELSE 0 END)"
+	having := ""
+	var args []any
+	switch filter {
+	case "":
+	case statusRunning:
+		having = "\nHAVING " + running + " > 0"
+		args = []any{statusRunning}
+	case statusFailed:
+		having = "\nHAVING SUM(CASE WHEN status=$2 THEN 1 ELSE 0 END) > 0 AND " + running + " = 0"
+		args = []any{statusRunning, statusFailed}
+	case statusCompleted:
+		having = "\nHAVING SUM(CASE WHEN status<>$1 THEN 1 ELSE 0 END) = 0"
+		args = []any{statusCompleted}
+	default:
+		return nil, fmt.Errorf("unknown workflow filter %q", filter)
+	}
+
+	rows, err := s.queryRows("\nSELECT workflow_id\nFROM steps\nGROUP BY workflow_id"+having+"\nORDER BY workflow_id;", args...)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(rows))
+	for _, row := range rows {
+		ids = append(ids, asString(row["workflow_id"]))
+	}
+	return ids, nil
+}
+
+// GetLatestRunID returns the run that most recently touched any of the
+// workflow's steps.
+func (s *Store) GetLatestRunID(workflowID string) (string, error) {
+	rows, err := s.queryRows(`
+SELECT run_id
+FROM steps
+WHERE workflow_id=$1
+ORDER BY julianday(updated_at) DESC, updated_at DESC
+LIMIT 1;`, workflowID)
+	if err != nil {
+		return "", err
+	}
+	if len(rows) == 0 {
+		return "", fmt.Errorf("%s: %w", workflowID, ErrWorkflowNotFound)
+	}
+	return asString(rows[0]["run_id"]), nil
+}
+
+func (s *Store) queryWorkflowRecords(query string, args ...any) ([]WorkflowRecord, error) {
+	rows, err := s.queryRows(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]WorkflowRecord, 0, len(rows))
+	for _, row := range rows {
+		out = append(out, parseWorkflowRecord(row))
+	}
+	return out, nil
+}
+
+func parseWorkflowRecord(row map[string]any) WorkflowRecord {
+	return WorkflowRecord{
+		WorkflowID:   asString(row["workflow_id"]),
+		RunID:        asString(row["run_id"]),
+		Status:       asString(row["status"]),
+		InputJSON:    asString(row["input_json"]),
+		MetadataJSON: asString(row["metadata_json"]),
+		Priority:     asInt(row["priority"]),
+		CreatedAt:    asString(row["created_at"]),
+		UpdatedAt:    asString(row["updated_at"]),
+	}
+}
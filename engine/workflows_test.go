@@ -0,0 +1,668 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRunWorkflowTracksStatus(t *testing.T) {
+	store := newSQLiteTestStore(t)
+
+	if err := RunWorkflow(store, "wf-tracked-ok", func(ctx *Context) error { return nil }); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	boom := errors.New("boom")
+	if err := RunWorkflow(store, "wf-tracked-fail", func(ctx *Context) error { return boom }); !errors.Is(err, boom) {
+		t.Fatalf("expected workflow error, got %v", err)
+	}
+
+	rows, err := store.queryWorkflowRecords("SELECT " + workflowColumns + " FROM workflows ORDER BY workflow_id;")
+	if err != nil {
+		t.Fatalf("list workflows failed: %v", err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 workflow rows, got %d", len(rows))
+	}
+	if rows[0].Status != statusFailed || rows[1].Status != statusCompleted {
+		t.Fatalf("unexpected statuses: %s=%s %s=%s", rows[0].WorkflowID, rows[0].Status, rows[1].WorkflowID, rows[1].Status)
+	}
+}
+
+func TestGetUnclaimedWorkflowsReturnsStaleRunning(t *testing.T) {
+	store := newSQLiteTestStore(t)
+
+	for _, id := range []string{"wf-stale-low", "wf-stale-high", "wf-active", "wf-done"} {
+		if err := store.MarkWorkflowRunning(id, "run-"+id); err != nil {
+			t.Fatalf("seed %s failed: %v", id, err)
+		}
+	}
+	if err := store.MarkWorkflowStatus("wf-done", "run-wf-done", statusCompleted); err != nil {
+		t.Fatalf("complete wf-done failed: %v", err)
+	}
+	old := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339Nano)
+	if err := store.execWrite(`
+UPDATE workflows SET updated_at='` + old + `'
+WHERE workflow_id IN ('wf-stale-low', 'wf-stale-high', 'wf-done');
+UPDATE workflows SET priority=5 WHERE workflow_id='wf-stale-high';`); err != nil {
+		t.Fatalf("age workflows failed: %v", err)
+	}
+
+	got, err := store.GetUnclaimedWorkflows(10*time.Minute, 10)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("expected 2 unclaimed workflows, got %+v", got)
+	}
+	if got[0].WorkflowID != "wf-stale-high" || got[1].WorkflowID != "wf-stale-low" {
+		t.Fatalf("unexpected order: %s, %s", got[0].WorkflowID, got[1].WorkflowID)
+	}
+
+	// Recent step activity counts as a heartbeat.
+	ctx := NewContext("wf-stale-low", store)
+	if _, err := Step(ctx, "touch", func() (int, error) { return 1, nil }); err != nil {
+		t.Fatalf("step failed: %v", err)
+	}
+	got, err = store.GetUnclaimedWorkflows(10*time.Minute, 10)
+	if err != nil {
+		t.Fatalf("requery failed: %v", err)
+	}
+	if len(got) != 1 || got[0].WorkflowID != "wf-stale-high" {
+		t.Fatalf("expected only wf-stale-high, got %+v", got)
+	}
+}
+
+func TestGetLatestRunIDFollowsMostRecentStep(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	const workflowID = "wf-latest-run"
+
+	if _, err := store.GetLatestRunID(workflowID); !errors.Is(err, ErrWorkflowNotFound) {
+		t.Fatalf("expected ErrWorkflowNotFound, got %v", err)
+	}
+
+	first := NewContext(workflowID, store)
+	if _, err := Step(first, "a", func() (int, error) { return 1, nil }); err != nil {
+		t.Fatalf("first step failed: %v", err)
+	}
+	second := NewContext(workflowID, store)
+	if _, err := Step(second, "b", func() (int, error) { return 2, nil }); err != nil {
+		t.Fatalf("second step failed: %v", err)
+	}
+
+	runID, err := store.GetLatestRunID(workflowID)
+	if err != nil {
+		t.Fatalf("latest run failed: %v", err)
+	}
+	if runID != second.RunID {
+		t.Fatalf("expected %s, got %s", second.RunID, runID)
+	}
+}
+
+func TestGeneratedIDsAreLexicographicallySorted(t *testing.T) {
+	ids := make([]string, 500)
+	for i := range ids {
+		ids[i] = GenerateWorkflowID("")
+	}
+	for i, id := range ids {
+		if len(id) != 26 {
+			t.Fatalf("expected 26 character ULID, got %q", id)
+		}
+		if i > 0 && id <= ids[i-1] {
+			t.Fatalf("ids out of order at %d: %s <= %s", i, id, ids[i-1])
+		}
+	}
+	if id := GenerateWorkflowID("order"); !strings.HasPrefix(id, "order-") || len(id) != len("order-")+26 {
+		t.Fatalf("unexpected prefixed id %q", id)
+	}
+
+	store := newSQLiteTestStore(t)
+	workflowID, err := RunWorkflowAutoID(store, "auto", func(ctx *Context) error {
+		_, err := Step(ctx, "one", func() (int, error) { return 1, nil })
+		return err
+	})
+	if err != nil {
+		t.Fatalf("auto id run failed: %v", err)
+	}
+	record, found, err := store.GetStep(workflowID, "one#000001")
+	if err != nil || !found || record.Status != statusCompleted {
+		t.Fatalf("expected step under generated id %s: found=%v err=%v", workflowID, found, err)
+	}
+}
+
+func TestListWorkflowsWithStatusPagesNewestFirst(t *testing.T) {
+	store := newSQLiteTestStore(t)
+
+	for _, wf := range []string{"wf-list-a", "wf-list-b", "wf-list-c"} {
+		if err := RunWorkflow(store, wf, func(ctx *Context) error { return nil }); err != nil {
+			t.Fatalf("run %s failed: %v", wf, err)
+		}
+	}
+	if err := store.MarkWorkflowRunning("wf-list-d", "run-d"); err != nil {
+		t.Fatalf("mark running failed: %v", err)
+	}
+
+	page, err := store.ListWorkflowsWithStatus(statusCompleted, 2, 0)
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if len(page) != 2 || page[0].WorkflowID != "wf-list-c" || page[1].WorkflowID != "wf-list-b" {
+		t.Fatalf("unexpected first page: %+v", page)
+	}
+	rest, err := store.ListWorkflowsWithStatus(statusCompleted, 2, 2)
+	if err != nil {
+		t.Fatalf("list second page failed: %v", err)
+	}
+	if len(rest) != 1 || rest[0].WorkflowID != "wf-list-a" {
+		t.Fatalf("unexpected second page: %+v", rest)
+	}
+
+	paused, err := store.ListWorkflowsWithStatus(statusPaused, 0, 0)
+	if err != nil || len(paused) != 0 {
+		t.Fatalf("expected no paused workflows, got %v err=%v", paused, err)
+	}
+	if _, err := store.ListWorkflowsWithStatus("sleeping", 0, 0); err == nil {
+		t.Fatalf("expected unknown status to be rejected")
+	}
+}
+
+func TestForEachWorkflowVisitsAll(t *testing.T) {
+	store := newSQLiteTestStore(t)
+
+	const total = forEachWorkflowPageSize + 3
+	var wb strings.Builder
+	for i := 0; i < total; i++ {
+		fmt.Fprintf(&wb, "INSERT INTO workflows(workflow_id, run_id, status, created_at, updated_at) VALUES('wf-each-%04d', 'run', 'completed', 'now', 'now');\n", i)
+	}
+	if err := store.execWrite(wb.String()); err != nil {
+		t.Fatalf("seed workflows failed: %v", err)
+	}
+
+	var visited []string
+	if err := store.ForEachWorkflow(func(wf WorkflowRecord) error {
+		visited = append(visited, wf.WorkflowID)
+		return nil
+	}); err != nil {
+		t.Fatalf("iterate failed: %v", err)
+	}
+	if len(visited) != total || visited[0] != "wf-each-0000" || visited[total-1] != fmt.Sprintf("wf-each-%04d", total-1) {
+		t.Fatalf("expected %d workflows in order, got %d", total, len(visited))
+	}
+
+	stop := errors.New("stop")
+	count := 0
+	err := store.ForEachWorkflow(func(WorkflowRecord) error {
+		count++
+		if count == 3 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) || count != 3 {
+		t.Fatalf("expected early stop after 3 workflows, count=%d err=%v", count, err)
+	}
+}
+
+func TestNewContextFromRecordResumesRun(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	const workflowID = "wf-from-record"
+
+	first := NewContext(workflowID, store)
+	if err := store.MarkWorkflowRunning(workflowID, first.RunID); err != nil {
+		t.Fatalf("mark running failed: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := Step(first, "batch", func() (int, error) { return i, nil }); err != nil {
+			t.Fatalf("step %d failed: %v", i, err)
+		}
+	}
+
+	records, err := store.ListWorkflowsWithStatus(statusRunning, 1, 0)
+	if err != nil || len(records) != 1 {
+		t.Fatalf("load workflow record failed: %v %v", records, err)
+	}
+	counters, err := store.LoadStepCounters(workflowID)
+	if err != nil {
+		t.Fatalf("load counters failed: %v", err)
+	}
+
+	ctx := NewContextFromRecord(records[0], counters, store)
+	if ctx.WorkflowID != workflowID || ctx.RunID != first.RunID {
+		t.Fatalf("expected workflow and run to carry over, got %s/%s", ctx.WorkflowID, ctx.RunID)
+	}
+	if _, err := Step(ctx, "batch", func() (int, error) { return 2, nil }); err != nil {
+		t.Fatalf("resumed step failed: %v", err)
+	}
+	if _, found, err := store.GetStep(workflowID, "batch#000003"); err != nil || !found {
+		t.Fatalf("expected numbering to continue at 3: found=%v err=%v", found, err)
+	}
+}
+
+func TestGetWorkflowRecordRoundTrip(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	const workflowID = "wf-record"
+
+	if _, found, err := store.GetWorkflowRecord(workflowID); err != nil || found {
+		t.Fatalf("expected missing workflow, found=%v err=%v", found, err)
+	}
+
+	var runID string
+	if err := RunWorkflow(store, workflowID, func(ctx *Context) error {
+		runID = ctx.RunID
+		return nil
+	}); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if err := store.execWrite(`
+UPDATE workflows
+SET input_json='{"employee_id":"emp-1"}', metadata_json='{"team":"ops"}', priority=5
+WHERE workflow_id='wf-record';`); err != nil {
+		t.Fatalf("seed fields failed: %v", err)
+	}
+
+	record, found, err := store.GetWorkflowRecord(workflowID)
+	if err != nil || !found {
+		t.Fatalf("load record failed: found=%v err=%v", found, err)
+	}
+	if record.WorkflowID != workflowID || record.RunID != runID || record.Status != statusCompleted ||
+		record.InputJSON != `{"employee_id":"emp-1"}` || record.MetadataJSON != `{"team":"ops"}` || record.Priority != 5 ||
+		record.CreatedAt == "" || record.UpdatedAt == "" {
+		t.Fatalf("unexpected record: %+v", record)
+	}
+}
+
+func TestGetWorkflowsByRunIDAttributesSteps(t *testing.T) {
+	store := newSQLiteTestStore(t)
+
+	worker := NewContext("wf-run-a", store)
+	for _, wf := range []string{"wf-run-b", "wf-run-a"} {
+		ctx := NewContextFromExisting(wf, worker.RunID, nil, store)
+		if _, err := Step(ctx, "work", func() (int, error) { return 1, nil }); err != nil {
+			t.Fatalf("step in %s failed: %v", wf, err)
+		}
+	}
+	if _, err := Step(NewContext("wf-run-other", store), "work", func() (int, error) { return 1, nil }); err != nil {
+		t.Fatalf("other step failed: %v", err)
+	}
+
+	ids, err := store.GetWorkflowsByRunID(worker.RunID)
+	if err != nil {
+		t.Fatalf("lookup failed: %v", err)
+	}
+	if strings.Join(ids, ",") != "wf-run-a,wf-run-b" {
+		t.Fatalf("unexpected workflows: %v", ids)
+	}
+}
+
+func TestCrossShardListWorkflows(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	shardPath := t.TempDir() + "/shard.db"
+	shard, err := NewStore(shardPath)
+	if err != nil {
+		t.Fatalf("new shard failed: %v", err)
+	}
+	if err := store.MarkWorkflowRunning("wf-shard-main", "run-main"); err != nil {
+		t.Fatalf("mark main failed: %v", err)
+	}
+	if err := shard.MarkWorkflowRunning("wf-shard-east", "run-east"); err != nil {
+		t.Fatalf("mark shard failed: %v", err)
+	}
+
+	if err := store.AttachShard("east", shardPath); err != nil {
+		t.Fatalf("attach failed: %v", err)
+	}
+	if err := store.AttachShard("east", shardPath); err == nil {
+		t.Fatalf("expected duplicate shard name to be rejected")
+	}
+	if err := store.AttachShard("bad name", shardPath); err == nil {
+		t.Fatalf("expected invalid shard name to be rejected")
+	}
+
+	all, err := store.CrossShardListWorkflows()
+	if err != nil {
+		t.Fatalf("cross-shard list failed: %v", err)
+	}
+	if len(all) != 2 || all[0].WorkflowID != "wf-shard-east" || all[1].WorkflowID != "wf-shard-main" {
+		t.Fatalf("unexpected cross-shard workflows: %+v", all)
+	}
+
+	if err := store.DetachShard("east"); err != nil {
+		t.Fatalf("detach failed: %v", err)
+	}
+	if err := store.DetachShard("east"); err == nil {
+		t.Fatalf("expected detaching an unknown shard to fail")
+	}
+	local, err := store.CrossShardListWorkflows()
+	if err != nil || len(local) != 1 || local[0].WorkflowID != "wf-shard-main" {
+		t.Fatalf("expected only main workflows after detach, got %+v err=%v", local, err)
+	}
+}
+
+func TestListWorkflowsPagedIsStable(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	for i := 0; i < 5; i++ {
+		if err := store.MarkWorkflowRunning(fmt.Sprintf("wf-paged-%d", i), "run"); err != nil {
+			t.Fatalf("mark %d failed: %v", i, err)
+		}
+	}
+
+	var seen []string
+	cursor := ""
+	for page := 0; ; page++ {
+		rows, next, err := store.ListWorkflowsPaged(cursor, 2, WorkflowFilter{Status: statusRunning})
+		if err != nil {
+			t.Fatalf("page %d failed: %v", page, err)
+		}
+		for _, wf := range rows {
+			seen = append(seen, wf.WorkflowID)
+		}
+		if page == 0 {
+			// A workflow created mid-listing lands after the cursor instead of
+			// shifting rows already returned.
+			if err := store.MarkWorkflowRunning("wf-paged-late", "run"); err != nil {
+				t.Fatalf("mark late failed: %v", err)
+			}
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	want := "wf-paged-0,wf-paged-1,wf-paged-2,wf-paged-3,wf-paged-4,wf-paged-late"
+	if got := strings.Join(seen, ","); got != want {
+		t.Fatalf("unexpected listing order:\n got %s\nwant %s", got, want)
+	}
+	if _, _, err := store.ListWorkflowsPaged("not a cursor!", 2, WorkflowFilter{}); err == nil {
+		t.Fatalf("expected malformed cursor to be rejected")
+	}
+}
+
+func TestListWorkflowIDsFiltersByStepStatus(t *testing.T) {
+	store := newSQLiteTestStore(t)
+
+	seed := func(workflowID string, statuses ...string) {
+		ctx := NewContext(workflowID, store)
+		for i, status := range statuses {
+			id := fmt.Sprintf("step%d", i)
+			switch status {
+			case statusCompleted:
+				if _, err := Step(ctx, id, func() (int, error) { return i, nil }); err != nil {
+					t.Fatalf("seed %s: %v", workflowID, err)
+				}
+			case statusFailed:
+				if _, err := Step(ctx, id, func() (int, error) { return 0, errors.New("boom") }); err == nil {
+					t.Fatalf("seed %s: expected failure", workflowID)
+				}
+			case statusRunning:
+				if err := store.UpsertRunning(workflowID, ctx.nextStepRef(id), ctx.RunID); err != nil {
+					t.Fatalf("seed %s: %v", workflowID, err)
+				}
+			}
+		}
+	}
+	seed("wf-c", statusCompleted, statusCompleted)
+	seed("wf-a", statusCompleted, statusRunning)
+	seed("wf-b", statusFailed, statusCompleted)
+	seed("wf-d", statusFailed, statusRunning)
+
+	cases := map[string][]string{
+		"":              {"wf-a", "wf-b", "wf-c", "wf-d"},
+		statusRunning:   {"wf-a", "wf-d"},
+		statusFailed:    {"wf-b"},
+		statusCompleted: {"wf-c"},
+	}
+	for filter, want := range cases {
+		got, err := store.ListWorkflowIDs(filter)
+		if err != nil {
+			t.Fatalf("filter %q: %v", filter, err)
+		}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Fatalf("filter %q: expected %v, got %v", filter, want, got)
+		}
+	}
+	if _, err := store.ListWorkflowIDs("paused"); err == nil {
+		t.Fatalf("expected unknown filter to be rejected")
+	}
+}
+
+func TestWorkflowMetadataPersistsAcrossRuns(t *testing.T) {
+	store := newSQLiteTestStore(t)
+
+	err := RunWorkflow(store, "wf-meta", func(ctx *Context) error {
+		ctx.WithMetadata("tenant", "acme").WithMetadata("region", "eu")
+		return errors.New("boom")
+	})
+	if err == nil {
+		t.Fatalf("expected first run to fail")
+	}
+	meta, err := store.GetWorkflowMetadata("wf-meta")
+	if err != nil {
+		t.Fatalf("get metadata failed: %v", err)
+	}
+	if len(meta) != 2 || meta["tenant"] != "acme" || meta["region"] != "eu" {
+		t.Fatalf("unexpected persisted metadata: %v", meta)
+	}
+
+	var seen map[string]string
+	err = RunWorkflow(store, "wf-meta", func(ctx *Context) error {
+		ctx.WithMetadata("region", "us")
+		seen = ctx.Metadata()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("second run failed: %v", err)
+	}
+	if len(seen) != 2 || seen["tenant"] != "acme" || seen["region"] != "us" {
+		t.Fatalf("expected resumed run to see earlier metadata, got %v", seen)
+	}
+	seen["tenant"] = "mutated"
+
+	if err := store.UpsertWorkflowMetadata("wf-meta", map[string]string{"owner": "ops"}); err != nil {
+		t.Fatalf("upsert failed: %v", err)
+	}
+	meta, err = store.GetWorkflowMetadata("wf-meta")
+	if err != nil {
+		t.Fatalf("get metadata failed: %v", err)
+	}
+	if len(meta) != 3 || meta["tenant"] != "acme" || meta["region"] != "us" || meta["owner"] != "ops" {
+		t.Fatalf("unexpected metadata after upsert: %v", meta)
+	}
+	if empty, err := store.GetWorkflowMetadata("wf-none"); err != nil || len(empty) != 0 {
+		t.Fatalf("expected no metadata for unknown workflow, got %v err=%v", empty, err)
+	}
+}
+
+func TestRunWorkflowWithContextPropagatesCancellation(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	const workflowID = "wf-run-cancel"
+
+	goCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ran := 0
+	err := RunWorkflowWithContext(goCtx, store, workflowID, func(ctx *Context) error {
+		for i := 0; i < 3; i++ {
+			if _, err := Step(ctx, "work", func() (int, error) {
+				ran++
+				if i == 1 {
+					cancel()
+				}
+				return i, nil
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if ran != 2 {
+		t.Fatalf("expected the step after cancellation not to run, ran %d", ran)
+	}
+	rows, err := store.ListSteps(workflowID)
+	if err != nil || len(rows) != 2 || rows[1].Status != statusCompleted {
+		t.Fatalf("expected 2 completed steps, got %+v err=%v", rows, err)
+	}
+	record, found, err := store.GetWorkflowRecord(workflowID)
+	if err != nil || !found || record.Status != statusCancelled {
+		t.Fatalf("expected workflow recorded as cancelled, got %+v err=%v", record, err)
+	}
+
+	if err := RunWorkflowWithContext(context.Background(), store, workflowID, func(ctx *Context) error {
+		_, err := Step(ctx, "work", func() (int, error) { return 0, nil })
+		return err
+	}); err != nil {
+		t.Fatalf("resume failed: %v", err)
+	}
+}
+
+func TestRunWorkflowAsyncFutures(t *testing.T) {
+	store := newTestStore(t)
+
+	futures := make([]*WorkflowFuture, 10)
+	for i := range futures {
+		steps := i + 1
+		futures[i] = RunWorkflowAsync(store, fmt.Sprintf("wf-async-%02d", i), func(ctx *Context) error {
+			for j := 0; j < steps; j++ {
+				if _, err := Step(ctx, "work", func() (int, error) { return j, nil }); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	for i, f := range futures {
+		if err := f.Wait(); err != nil {
+			t.Fatalf("workflow %d failed: %v", i, err)
+		}
+		select {
+		case <-f.Done():
+		default:
+			t.Fatalf("expected Done to be closed after Wait")
+		}
+		rows, err := store.ListSteps(fmt.Sprintf("wf-async-%02d", i))
+		if err != nil || len(rows) != i+1 {
+			t.Fatalf("workflow %d: expected %d steps, got %d err=%v", i, i+1, len(rows), err)
+		}
+	}
+
+	release := make(chan struct{})
+	f := RunWorkflowAsync(store, "wf-async-cancel", func(ctx *Context) error {
+		<-release
+		_, err := Step(ctx, "after", func() (int, error) { return 1, nil })
+		return err
+	})
+	if f.Err() != nil {
+		t.Fatalf("expected no error while running")
+	}
+	f.Cancel()
+	close(release)
+	if err := f.Wait(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestSchedulerIntervalRunsAndSkipsWhileRunning(t *testing.T) {
+	store := newSQLiteTestStore(t)
+	sched := NewScheduler(store)
+
+	var mu sync.Mutex
+	ticks := 0
+	sched.AddInterval(10*time.Millisecond, "tick", func(ctx *Context) error {
+		mu.Lock()
+		ticks++
+		mu.Unlock()
+		_, err := Step(ctx, "work", func() (int, error) { return 1, nil })
+		return err
+	})
+	release := make(chan struct{})
+	slowRuns := 0
+	sched.AddInterval(5*time.Millisecond, "slow", func(ctx *Context) error {
+		mu.Lock()
+		slowRuns++
+		mu.Unlock()
+		<-release
+		return nil
+	})
+	if err := sched.AddCron("not a cron spec", "bad", func(*Context) error { return nil }); err == nil {
+		t.Fatalf("expected invalid cron spec to be rejected")
+	}
+
+	sched.Start()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		n := ticks
+		mu.Unlock()
+		if n >= 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected at least 3 interval runs, got %d", n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	mu.Lock()
+	slow := slowRuns
+	mu.Unlock()
+	close(release)
+	sched.Stop()
+
+	if slow != 1 {
+		t.Fatalf("expected slow schedule to skip while its run was in flight, started %d", slow)
+	}
+	records, err := store.ListWorkflowsWithStatus(statusCompleted, 0, 0)
+	if err != nil {
+		t.Fatalf("list workflows failed: %v", err)
+	}
+	seen := make(map[string]bool)
+	for _, r := range records {
+		if strings.HasPrefix(r.WorkflowID, "tick-") {
+			seen[r.WorkflowID] = true
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(seen) != ticks {
+		t.Fatalf("expected %d distinct completed tick workflows, got %d", ticks, len(seen))
+	}
+}
+
+func TestParseCronNextFireTimes(t *testing.T) {
+	base := time.Date(2024, time.January, 31, 10, 17, 30, 0, time.UTC) // a Wednesday
+	cases := []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2024, 1, 31, 10, 18, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 1, 31, 10, 30, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", time.Date(2024, 1, 31, 13, 0, 0, 0, time.UTC)},
+		{"30 2 1 * *", time.Date(2024, 2, 1, 2, 30, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 8 * * 1,5", time.Date(2024, 2, 2, 8, 0, 0, 0, time.UTC)},
+		{"0 8 * * 7", time.Date(2024, 2, 4, 8, 0, 0, 0, time.UTC)},
+		// Day of month and day of week both restricted: either one matches.
+		{"0 0 15 * 4", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, tc := range cases {
+		schedule, err := parseCron(tc.spec)
+		if err != nil {
+			t.Fatalf("%q: parse failed: %v", tc.spec, err)
+		}
+		if got := schedule.Next(base); !got.Equal(tc.want) {
+			t.Fatalf("%q: expected %v, got %v", tc.spec, tc.want, got)
+		}
+	}
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "a * * * *"} {
+		if _, err := parseCron(spec); err == nil {
+			t.Fatalf("%q: expected parse error", spec)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// WriteBatch accumulates step state changes for Store.ApplyWriteBatch, which
+// commits them together or not at all.
+type WriteBatch struct {
+	ops []func(d Dialect, now string) []Statement
+}
+
+func (wb *WriteBatch) UpsertRunning(workflowID string, ref StepRef, runID string) {
+	wb.ops = append(wb.ops, func(d Dialect, now string) []Statement {
+		return withoutClaimCheck(d.UpsertRunningSQL(workflowID, ref, runID, now, now))
+	})
+}
+
+// claim is UpsertRunning for a Context: it only takes ref over from another
+// run once that run has not touched it for zombieTimeout, and fails the batch
+// with ErrStepStillRunning if another run holds or completed the step.
+func (wb *WriteBatch) claim(workflowID string, ref StepRef, runID string, zombieTimeout time.Duration) {
+	wb.ops = append(wb.ops, func(d Dialect, now string) []Statement {
+		return d.UpsertRunningSQL(workflowID, ref, runID, now, staleBefore(now, zombieTimeout))
+	})
+}
+
+// staleBefore is the last update time at which a step running under another
+// run counts as abandoned.
+func staleBefore(now string, zombieTimeout time.Duration) string {
+	if zombieTimeout <= 0 {
+		return now
+	}
+	t, err := time.Parse(time.RFC3339Nano, now)
+	if err != nil {
+		return now
+	}
+	return sqlTime(t.Add(-zombieTimeout))
+}
+
+// withoutClaimCheck lets UpsertRunning leave a completed step as it is
+// instead of failing.
+func withoutClaimCheck(stmts []Statement) []Statement {
+	for i := range stmts {
+		stmts[i].Claim = false
+	}
+	return stmts
+}
+
+func (wb *WriteBatch) MarkCompleted(workflowID, stepKey, runID, outputJSON string) {
+	wb.ops = append(wb.ops, func(d Dialect, now string) []Statement {
+		return []Statement{d.MarkCompletedSQL(workflowID, stepKey, runID, outputJSON, now)}
+	})
+}
+
+func (wb *WriteBatch) MarkFailed(workflowID, stepKey, runID, errText string) {
+	wb.ops = append(wb.ops, func(d Dialect, now string) []Statement {
+		return []Statement{d.MarkFailedSQL(workflowID, stepKey, runID, errText, now)}
+	})
+}
+
+func (wb *WriteBatch) SetStepMetadata(workflowID, stepKey, metadataJSON string) {
+	wb.ops = append(wb.ops, func(d Dialect, _ string) []Statement {
+		return []Statement{d.SetStepMetadataSQL(workflowID, stepKey, metadataJSON)}
+	})
+}
+
+func (wb *WriteBatch) Len() int {
+	return len(wb.ops)
+}
+
+func (s *Store) ApplyWriteBatch(wb *WriteBatch) error {
+	if wb == nil {
+		return errors.New("write batch is nil")
+	}
+	if len(wb.ops) == 0 {
+		return nil
+	}
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	var stmts []Statement
+	for _, op := range wb.ops {
+		stmts = append(stmts, op(s.dialect, now)...)
+	}
+	defer s.readCache.clear()
+	return s.execTx(context.Background(), stmts)
+}
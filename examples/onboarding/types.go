@@ -1,9 +1,12 @@
 package onboarding
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 )
 
 type Input struct {
@@ -14,7 +17,7 @@ type Input struct {
 
 type Options struct {
 	StateDir string
-	Crash    CrashSpec
+	Crash    CrashPlan
 }
 
 type CrashSpec struct {
@@ -27,13 +30,131 @@ func (c CrashSpec) Enabled() bool {
 }
 
 func (c CrashSpec) MaybeCrash(stepID, point string) {
-	if !c.Enabled() {
+	if c.matches(stepID, point) {
+		crash(stepID, point)
+	}
+}
+
+func (c CrashSpec) matches(stepID, point string) bool {
+	return c.Enabled() &&
+		strings.EqualFold(strings.TrimSpace(c.Step), stepID) &&
+		strings.EqualFold(strings.TrimSpace(c.Point), point)
+}
+
+func (c CrashSpec) String() string {
+	return c.Step + ":" + c.Point
+}
+
+func crash(stepID, point string) {
+	fmt.Fprintf(os.Stderr, "simulating crash at %s (%s side effect)\n", stepID, point)
+	os.Exit(42)
+}
+
+// CrashPlan is a sequence of crashes to inject, one per process. Next indexes
+// the spec that fires next; when the plan tracks progress in a state dir the
+// index survives the simulated crash, so rerunning with the same plan moves
+// on to the following spec.
+type CrashPlan struct {
+	Specs []CrashSpec
+	Next  int
+
+	progressPath string
+	workflowID   string
+}
+
+// crashMu serializes MaybeCrash, which parallel steps may call concurrently.
+var crashMu sync.Mutex
+
+// ParseCrashPlan parses comma-separated <step>:<before|after> specs.
+func ParseCrashPlan(spec string) (CrashPlan, error) {
+	var plan CrashPlan
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		c, err := ParseCrashSpec(part)
+		if err != nil {
+			return CrashPlan{}, err
+		}
+		plan.Specs = append(plan.Specs, c)
+	}
+	return plan, nil
+}
+
+func ParseCrashSpec(spec string) (CrashSpec, error) {
+	parts := strings.Split(strings.TrimSpace(spec), ":")
+	if len(parts) != 2 {
+		return CrashSpec{}, errors.New("crash must be in format <step>:<before|after>")
+	}
+	step := strings.TrimSpace(parts[0])
+	point := strings.ToLower(strings.TrimSpace(parts[1]))
+	if step == "" {
+		return CrashSpec{}, errors.New("crash step cannot be empty")
+	}
+	if point != "before" && point != "after" {
+		return CrashSpec{}, errors.New("crash point must be before or after")
+	}
+	return CrashSpec{Step: step, Point: point}, nil
+}
+
+func (p *CrashPlan) String() string {
+	names := make([]string, len(p.Specs))
+	for i, c := range p.Specs {
+		names[i] = c.String()
+	}
+	return strings.Join(names, ",")
+}
+
+// MaybeCrash crashes if stepID and point match the next spec in the plan,
+// recording the advanced index first when progress is tracked.
+func (p *CrashPlan) MaybeCrash(stepID, point string) {
+	crashMu.Lock()
+	defer crashMu.Unlock()
+
+	if p.Next >= len(p.Specs) || !p.Specs[p.Next].matches(stepID, point) {
 		return
 	}
-	if strings.EqualFold(strings.TrimSpace(c.Step), stepID) && strings.EqualFold(strings.TrimSpace(c.Point), point) {
-		fmt.Fprintf(os.Stderr, "simulating crash at %s (%s side effect)\n", stepID, point)
-		os.Exit(42)
+	p.Next++
+	if p.progressPath != "" {
+		if err := p.saveProgress(); err != nil {
+			fmt.Fprintf(os.Stderr, "unable to record crash plan progress: %v\n", err)
+		}
+	}
+	crash(stepID, point)
+}
+
+type crashProgress struct {
+	Plan string `json:"plan"`
+	Next int    `json:"next"`
+}
+
+// trackProgress makes the plan resume from, and record to, stateDir for
+// workflowID. Progress recorded for a different plan is ignored.
+func (p *CrashPlan) trackProgress(stateDir, workflowID string) error {
+	if len(p.Specs) == 0 {
+		return nil
+	}
+	p.progressPath = filepath.Join(stateDir, "crash_progress.json")
+	p.workflowID = workflowID
+
+	progress := make(map[string]crashProgress)
+	if err := readJSON(p.progressPath, &progress); err != nil {
+		return err
+	}
+	if saved, ok := progress[workflowID]; ok && saved.Plan == p.String() {
+		p.Next = saved.Next
+	}
+	return nil
+}
+
+func (p *CrashPlan) saveProgress() error {
+	progress := make(map[string]crashProgress)
+	if err := readJSON(p.progressPath, &progress); err != nil {
+		return err
 	}
+	progress[p.workflowID] = crashProgress{Plan: p.String(), Next: p.Next}
+	return writeJSON(p.progressPath, progress)
 }
 
 type EmployeeRecord struct {
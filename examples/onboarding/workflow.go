@@ -2,10 +2,8 @@ package onboarding
 
 import (
 	"fmt"
-	"sync"
 
 	"durableexec/engine"
-	"durableexec/internal/errgroup"
 )
 
 func Run(ctx *engine.Context, input Input, opts Options) error {
@@ -23,8 +21,15 @@ func Run(ctx *engine.Context, input Input, opts Options) error {
 	if err != nil {
 		return err
 	}
+	if err := opts.Crash.trackProgress(services.stateDir, ctx.WorkflowID); err != nil {
+		return err
+	}
+
+	// Checkpoints live under the onboarding: namespace, so callers can embed
+	// this workflow next to their own steps.
+	flow := ctx.Fork("onboarding")
 
-	record, err := engine.Step(ctx, "create_record", func() (EmployeeRecord, error) {
+	record, err := engine.Step(flow, "create_record", func() (EmployeeRecord, error) {
 		opts.Crash.MaybeCrash("create_record", "before")
 		out, callErr := services.CreateRecord(input)
 		opts.Crash.MaybeCrash("create_record", "after")
@@ -34,50 +39,25 @@ func Run(ctx *engine.Context, input Input, opts Options) error {
 		return err
 	}
 
-	var (
-		laptop LaptopProvision
-		access AccessProvision
-		mu     sync.Mutex
-		g      errgroup.Group
-	)
-
-	g.Go(func() error {
-		res, stepErr := engine.Step(ctx, "provision_laptop", func() (LaptopProvision, error) {
+	laptop, access, err := engine.WhenAll2(flow, "provision_laptop", "provision_access",
+		func() (LaptopProvision, error) {
 			opts.Crash.MaybeCrash("provision_laptop", "before")
 			out, callErr := services.ProvisionLaptop(record.EmployeeID)
 			opts.Crash.MaybeCrash("provision_laptop", "after")
 			return out, callErr
-		})
-		if stepErr != nil {
-			return stepErr
-		}
-		mu.Lock()
-		laptop = res
-		mu.Unlock()
-		return nil
-	})
-
-	g.Go(func() error {
-		res, stepErr := engine.Step(ctx, "provision_access", func() (AccessProvision, error) {
+		},
+		func() (AccessProvision, error) {
 			opts.Crash.MaybeCrash("provision_access", "before")
 			out, callErr := services.ProvisionAccess(record.EmployeeID)
 			opts.Crash.MaybeCrash("provision_access", "after")
 			return out, callErr
-		})
-		if stepErr != nil {
-			return stepErr
-		}
-		mu.Lock()
-		access = res
-		mu.Unlock()
-		return nil
-	})
-
-	if err := g.Wait(); err != nil {
+		},
+	)
+	if err != nil {
 		return err
 	}
 
-	_, err = engine.Step(ctx, "send_welcome_email", func() (WelcomeEmail, error) {
+	_, err = engine.Step(flow, "send_welcome_email", func() (WelcomeEmail, error) {
 		opts.Crash.MaybeCrash("send_welcome_email", "before")
 		out, callErr := services.SendWelcomeEmail(record.EmployeeID, record.Email, laptop.LaptopID, access.Role)
 		opts.Crash.MaybeCrash("send_welcome_email", "after")
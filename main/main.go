@@ -13,6 +13,26 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate":
+			runValidate(os.Args[2:])
+			return
+		case "profile-workflow":
+			runProfileWorkflow(os.Args[2:])
+			return
+		case "stats":
+			runStats(os.Args[2:])
+			return
+		case "vacuum":
+			runVacuum(os.Args[2:])
+			return
+		case "list-workflows":
+			runListWorkflows(os.Args[2:])
+			return
+		}
+	}
+
 	var (
 		dbPath     string
 		stateDir   string
@@ -29,10 +49,10 @@ func main() {
 	flag.StringVar(&empID, "employee-id", "emp-001", "employee id")
 	flag.StringVar(&name, "name", "Ada Lovelace", "employee name")
 	flag.StringVar(&email, "email", "ada@example.com", "employee email")
-	flag.StringVar(&crashSpec, "crash", "", "simulate crash at <step>:<before|after>, e.g. provision_laptop:after")
+	flag.StringVar(&crashSpec, "crash", "", "simulate crashes at comma-separated <step>:<before|after> points, one per run, e.g. provision_laptop:after,send_welcome_email:before")
 	flag.Parse()
 
-	crash, err := parseCrashSpec(crashSpec)
+	crash, err := onboarding.ParseCrashPlan(crashSpec)
 	if err != nil {
 		exitErr(err)
 	}
@@ -66,24 +86,154 @@ func main() {
 	printWorkflowSteps(store, workflowID)
 }
 
-func parseCrashSpec(spec string) (onboarding.CrashSpec, error) {
-	spec = strings.TrimSpace(spec)
-	if spec == "" {
-		return onboarding.CrashSpec{}, nil
+func runValidate(args []string) {
+	var dbPath, workflowID string
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	fs.StringVar(&dbPath, "db", "./durable.db", "path to sqlite database")
+	fs.StringVar(&workflowID, "workflow-id", "", "workflow instance id to validate")
+	_ = fs.Parse(args)
+
+	if strings.TrimSpace(workflowID) == "" {
+		exitErr(errors.New("validate requires -workflow-id"))
+	}
+
+	store, err := engine.NewStore(dbPath)
+	if err != nil {
+		exitErr(err)
+	}
+	problems, err := store.ValidateIntegrity(workflowID)
+	if err != nil {
+		exitErr(err)
+	}
+	if len(problems) == 0 {
+		fmt.Printf("workflow %q passed integrity checks\n", workflowID)
+		return
+	}
+	fmt.Printf("workflow %q has %d integrity problem(s):\n", workflowID, len(problems))
+	for _, p := range problems {
+		fmt.Printf("  - [%s] %s: %s\n", p.Kind, p.StepKey, p.Detail)
+	}
+	os.Exit(1)
+}
+
+func runProfileWorkflow(args []string) {
+	var (
+		dbPath     string
+		workflowID string
+		top        int
+	)
+	fs := flag.NewFlagSet("profile-workflow", flag.ExitOnError)
+	fs.StringVar(&dbPath, "db", "./durable.db", "path to sqlite database")
+	fs.StringVar(&workflowID, "id", "", "workflow instance id to profile")
+	fs.IntVar(&top, "top", 5, "number of slowest steps to show")
+	_ = fs.Parse(args)
+
+	if strings.TrimSpace(workflowID) == "" {
+		exitErr(errors.New("profile-workflow requires -id"))
+	}
+
+	store, err := engine.NewStore(dbPath)
+	if err != nil {
+		exitErr(err)
+	}
+	steps, err := store.GetTopKSlowSteps(workflowID, top)
+	if err != nil {
+		exitErr(err)
+	}
+	if len(steps) == 0 {
+		fmt.Println("no completed steps found")
+		return
+	}
+	fmt.Printf("slowest steps for %q:\n", workflowID)
+	for _, step := range steps {
+		fmt.Printf("  - %s duration=%s run=%s\n", step.StepKey, stepDuration(step), step.RunID)
+	}
+}
+
+func runStats(args []string) {
+	var dbPath string
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	fs.StringVar(&dbPath, "db", "./durable.db", "path to sqlite database")
+	_ = fs.Parse(args)
+
+	store, err := engine.NewStore(dbPath)
+	if err != nil {
+		exitErr(err)
+	}
+	stats, err := store.GetGlobalStats()
+	if err != nil {
+		exitErr(err)
 	}
-	parts := strings.Split(spec, ":")
-	if len(parts) != 2 {
-		return onboarding.CrashSpec{}, errors.New("crash must be in format <step>:<before|after>")
+	fmt.Printf("workflows:               %d\n", stats.TotalWorkflows)
+	fmt.Printf("steps:                   %d (completed=%d failed=%d running=%d)\n", stats.TotalSteps, stats.CompletedSteps, stats.FailedSteps, stats.RunningSteps)
+	fmt.Printf("avg steps per workflow:  %.2f\n", stats.AvgStepsPerWorkflow)
+	fmt.Printf("oldest running workflow: %s\n", stats.OldestRunningWorkflowAge.Round(time.Second))
+
+	size, err := store.TableSize()
+	if err != nil {
+		exitErr(err)
 	}
-	step := strings.TrimSpace(parts[0])
-	point := strings.ToLower(strings.TrimSpace(parts[1]))
-	if step == "" {
-		return onboarding.CrashSpec{}, errors.New("crash step cannot be empty")
+	fmt.Printf("steps table:             %d rows, %d data bytes, %d index bytes\n", size.StepsRowCount, size.StepsDataBytes, size.StepsIndexBytes)
+	fmt.Printf("workflows table:         %d rows, %d data bytes\n", size.WorkflowsRowCount, size.WorkflowsDataBytes)
+}
+
+func runVacuum(args []string) {
+	var dbPath string
+	fs := flag.NewFlagSet("vacuum", flag.ExitOnError)
+	fs.StringVar(&dbPath, "db", "./durable.db", "path to sqlite database")
+	_ = fs.Parse(args)
+
+	store, err := engine.NewStore(dbPath)
+	if err != nil {
+		exitErr(err)
 	}
-	if point != "before" && point != "after" {
-		return onboarding.CrashSpec{}, errors.New("crash point must be before or after")
+	if err := store.Vacuum(); err != nil {
+		exitErr(err)
 	}
-	return onboarding.CrashSpec{Step: step, Point: point}, nil
+	fmt.Printf("vacuumed %s\n", dbPath)
+}
+
+func runListWorkflows(args []string) {
+	var (
+		dbPath string
+		status string
+		limit  int
+		offset int
+	)
+	fs := flag.NewFlagSet("list-workflows", flag.ExitOnError)
+	fs.StringVar(&dbPath, "db", "./durable.db", "path to sqlite database")
+	fs.StringVar(&status, "status", "running", "workflow status: running, completed, failed, paused or cancelled")
+	fs.IntVar(&limit, "limit", 50, "maximum number of workflows to show")
+	fs.IntVar(&offset, "offset", 0, "number of workflows to skip")
+	_ = fs.Parse(args)
+
+	store, err := engine.NewStore(dbPath)
+	if err != nil {
+		exitErr(err)
+	}
+	workflows, err := store.ListWorkflowsWithStatus(strings.TrimSpace(status), limit, offset)
+	if err != nil {
+		exitErr(err)
+	}
+	if len(workflows) == 0 {
+		fmt.Printf("no %s workflows found\n", status)
+		return
+	}
+	for _, wf := range workflows {
+		fmt.Printf("  - %s status=%s run=%s updated=%s\n", wf.WorkflowID, wf.Status, wf.RunID, wf.UpdatedAt)
+	}
+}
+
+func stepDuration(step engine.StepRecord) time.Duration {
+	started, err := time.Parse(time.RFC3339Nano, step.StartedAt)
+	if err != nil {
+		return 0
+	}
+	completed, err := time.Parse(time.RFC3339Nano, step.CompletedAt)
+	if err != nil {
+		return 0
+	}
+	return completed.Sub(started)
 }
 
 func printWorkflowSteps(store *engine.Store, workflowID string) {
@@ -92,6 +242,9 @@ func printWorkflowSteps(store *engine.Store, workflowID string) {
 		fmt.Fprintf(os.Stderr, "unable to read workflow steps: %v\n", err)
 		return
 	}
+	if ids, err := store.ListWorkflowIDs(""); err == nil {
+		fmt.Printf("workflows in store: %d\n", len(ids))
+	}
 	if len(steps) == 0 {
 		fmt.Println("no step rows found")
 		return